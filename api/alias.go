@@ -0,0 +1,31 @@
+package api
+
+import (
+	"github.com/go-fuego/fuego"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func (rs Resources) RegisterAliasRoutes(s *fuego.Server) {
+	AliasGroup := fuego.Group(s, "/alias")
+
+	fuego.Get(AliasGroup, "", rs.getAliases)
+	fuego.Post(AliasGroup, "", rs.setAlias)
+	fuego.Delete(AliasGroup, "/{id}", rs.deleteAlias)
+}
+
+func (rs Resources) getAliases(c fuego.ContextNoBody) ([]*models.Alias, error) {
+	return rs.Service.GetAliases()
+}
+
+// setAlias creates the alias, or points it to another model when it exists.
+func (rs Resources) setAlias(c *fuego.ContextWithBody[models.AliasCreate]) (*models.Alias, error) {
+	body, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+	return rs.Service.SetAlias(body)
+}
+
+func (rs Resources) deleteAlias(c fuego.ContextNoBody) (any, error) {
+	return nil, rs.Service.DeleteAlias(c.PathParam("id"))
+}
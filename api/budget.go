@@ -0,0 +1,37 @@
+package api
+
+import (
+	"github.com/go-fuego/fuego"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func (rs Resources) RegisterBudgetRoutes(s *fuego.Server) {
+	BudgetGroup := fuego.Group(s, "/budget")
+
+	fuego.Get(BudgetGroup, "/warnings", rs.getBudgetWarnings)
+	fuego.Get(BudgetGroup, "/client", rs.getClientBudgets)
+	fuego.Post(BudgetGroup, "/client", rs.setClientBudget)
+	fuego.Delete(BudgetGroup, "/client/{id}", rs.deleteClientBudget)
+}
+
+// getBudgetWarnings returns the budgets of the providers and the clients that reached their warning percent.
+func (rs Resources) getBudgetWarnings(c fuego.ContextNoBody) ([]models.BudgetStatus, error) {
+	return rs.Service.GetBudgetWarnings()
+}
+
+func (rs Resources) getClientBudgets(c fuego.ContextNoBody) ([]*models.ClientBudget, error) {
+	return rs.Service.GetClientBudgets()
+}
+
+// setClientBudget creates or updates the budget of the client that sends the app.
+func (rs Resources) setClientBudget(c *fuego.ContextWithBody[models.ClientBudgetCreate]) (*models.ClientBudget, error) {
+	body, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+	return rs.Service.SetClientBudget(body)
+}
+
+func (rs Resources) deleteClientBudget(c fuego.ContextNoBody) (any, error) {
+	return nil, rs.Service.DeleteClientBudget(c.PathParam("id"))
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"github.com/go-fuego/fuego"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func (rs Resources) RegisterResponseCacheRoutes(s *fuego.Server) {
+	ResponseCacheGroup := fuego.Group(s, "/cache")
+
+	fuego.Get(ResponseCacheGroup, "", rs.getResponseCaches)
+	fuego.Post(ResponseCacheGroup, "", rs.setResponseCache)
+	fuego.Delete(ResponseCacheGroup, "/{id}", rs.deleteResponseCache)
+}
+
+func (rs Resources) getResponseCaches(c fuego.ContextNoBody) ([]*models.ResponseCache, error) {
+	return rs.Service.GetResponseCaches()
+}
+
+// setResponseCache creates the response cache of the model, or changes its time to live.
+func (rs Resources) setResponseCache(c *fuego.ContextWithBody[models.ResponseCacheCreate]) (*models.ResponseCache, error) {
+	body, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+	return rs.Service.SetResponseCache(body)
+}
+
+func (rs Resources) deleteResponseCache(c fuego.ContextNoBody) (any, error) {
+	return nil, rs.Service.DeleteResponseCache(c.PathParam("id"))
+}
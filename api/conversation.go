@@ -4,6 +4,7 @@ package api
 import (
 	"github.com/go-fuego/fuego"
 	"github.com/y2a-labs/evaluate/models"
+	service "github.com/y2a-labs/evaluate/services"
 )
 
 func (rs Resources) RegisterConversationRoutes(s *fuego.Server) {
@@ -18,7 +19,7 @@ func (rs Resources) RegisterConversationRoutes(s *fuego.Server) {
 }
 
 func (rs Resources) getAllConversations(c fuego.ContextNoBody) ([]*models.Conversation, error) {
-	return rs.Service.GetAllConversations()
+	return rs.Service.GetAllConversations(service.ParseConversationFilter(c.QueryParam("tags"), c.QueryParam("user"), c.QueryParam("app"), c.QueryParam("metadata")))
 }
 
 func (rs Resources) createConversation(c *fuego.ContextWithBody[models.ConversationCreate]) (*models.Conversation, error) {
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"github.com/go-fuego/fuego"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func (rs Resources) RegisterFallbackRoutes(s *fuego.Server) {
+	FallbackGroup := fuego.Group(s, "/fallback")
+
+	fuego.Get(FallbackGroup, "", rs.getFallbackChains)
+	fuego.Post(FallbackGroup, "", rs.setFallbackChain)
+	fuego.Delete(FallbackGroup, "/{id}", rs.deleteFallbackChain)
+}
+
+func (rs Resources) getFallbackChains(c fuego.ContextNoBody) ([]*models.FallbackChain, error) {
+	return rs.Service.GetFallbackChains()
+}
+
+// setFallbackChain creates or replaces the fallback chain of the model.
+func (rs Resources) setFallbackChain(c *fuego.ContextWithBody[models.FallbackChainCreate]) (*models.FallbackChain, error) {
+	body, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+	return rs.Service.SetFallbackChain(body)
+}
+
+func (rs Resources) deleteFallbackChain(c fuego.ContextNoBody) (any, error) {
+	return nil, rs.Service.DeleteFallbackChain(c.PathParam("id"))
+}
@@ -4,6 +4,7 @@ package api
 import (
 	"github.com/go-fuego/fuego"
 	"github.com/y2a-labs/evaluate/models"
+	service "github.com/y2a-labs/evaluate/services"
 )
 
 func (rs Resources) RegisterLLMRoutes(s *fuego.Server) {
@@ -11,6 +12,7 @@ func (rs Resources) RegisterLLMRoutes(s *fuego.Server) {
 
 	fuego.Get(LLMGroup, "/", rs.getAllLLMs)
 	fuego.Post(LLMGroup, "/", rs.createLLM)
+	fuego.Post(LLMGroup, "/prices", rs.importPrices)
 
 	fuego.Get(LLMGroup, "/{id}", rs.getLLM)
 	fuego.Put(LLMGroup, "/{id}", rs.updateLLM)
@@ -30,6 +32,12 @@ func (rs Resources) createLLM(c *fuego.ContextWithBody[models.LLMCreate]) (*mode
 	return rs.Service.CreateLLM(body)
 }
 
+// importPrices sets the prices of the saved models from the price sheet in the request body.
+func (rs Resources) importPrices(c fuego.ContextNoBody) (*service.PriceImportResult, error) {
+	defer c.Req.Body.Close()
+	return rs.Service.ImportPrices(c.Req.Body)
+}
+
 func (rs Resources) getLLM(c fuego.ContextNoBody) (*models.LLM, error) {
 	id := c.PathParam("id")
 
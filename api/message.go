@@ -15,6 +15,7 @@ func (rs Resources) RegisterMessageRoutes(s *fuego.Server) {
 	fuego.Get(MessageGroup, "/{id}", rs.getMessage)
 	fuego.Put(MessageGroup, "/{id}", rs.updateMessage)
 	fuego.Delete(MessageGroup, "/{id}", rs.deleteMessage)
+	fuego.Post(MessageGroup, "/{id}/score", rs.scoreMessage)
 }
 
 func (rs Resources) getAllMessages(c fuego.ContextNoBody) (*[]models.Message, error) {
@@ -55,4 +56,12 @@ func (rs Resources) updateMessage(c *fuego.ContextWithBody[models.MessageUpdate]
 func (rs Resources) deleteMessage(c *fuego.ContextNoBody) (*models.Message, error) {
 	id := c.PathParam("id")
 	return nil, rs.Service.DeleteMessage(id)
-}
\ No newline at end of file
+}
+// scoreMessage saves a score of a logged response, like the feedback of its user.
+func (rs Resources) scoreMessage(c *fuego.ContextWithBody[models.MessageScore]) (*models.MessageMetadata, error) {
+	body, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+	return rs.Service.ScoreMessage(c.PathParam("id"), body)
+}
@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"github.com/y2a-labs/evaluate/models"
+	service "github.com/y2a-labs/evaluate/services"
 	"strings"
 	"time"
 
@@ -23,53 +25,87 @@ func (rs Resources) ProxyOpenaiEmbedding (c *fuego.ContextWithBody[openai.Embedd
 	return rs.Service.ProxyOpenaiEmbedding(c.Context(), body)
 }
 
-func (rs Resources) ProxyOpenaiChatCompletion(c *fuego.ContextWithBody[openai.ChatCompletionRequest]) (any, error) {
+func (rs Resources) ProxyOpenaiChatCompletion(c *fuego.ContextWithBody[models.ProxyChatCompletionRequest]) (any, error) {
 	ctx, cancel := context.WithTimeout(c.Context(), 2*time.Minute)
 	defer cancel()
-	body, err := c.Body()
-	providerId := c.Req.Header.Get("Provider-Id")
+	proxyBody, err := c.Body()
 	if err != nil {
 		return nil, err
 	}
+	body := proxyBody.ChatCompletionRequest
+	options := service.ProxyOptions{
+		ProviderID:     c.Req.Header.Get("Provider-Id"),
+		ConversationID: c.Req.Header.Get("X-Conversation-Id"),
+		Tags:           service.ParseTags(c.Req.Header.Get("X-Evaluate-Tags")),
+		User:           c.Req.Header.Get("X-Evaluate-User"),
+		App:            c.Req.Header.Get("X-Evaluate-App"),
+		Metadata:       proxyBody.Metadata,
+		// Lets a client get a fresh response of a model that has a response cache
+		NoCache:     c.Req.Header.Get("X-Evaluate-Cache") == "bypass",
+		Temperature: proxyBody.Temperature,
+	}
+	if proxyBody.Temperature != nil {
+		body.Temperature = *proxyBody.Temperature
+	}
+	// The header names the user to the proxy, the user field of the request is the fallback
+	if options.User == "" {
+		options.User = body.User
+	}
 
 	var responseContent string
+	// The ID of the logged response is sent before the response, so clients can score it afterwards
+	messageID := uuid.NewString()
 
 	if body.Stream {
 		startTime := time.Now()
-		stream, conversation, err := rs.Service.ProxyOpenaiStream(ctx, body, providerId)
+		stream, route, err := rs.Service.ProxyOpenaiStream(ctx, body, options)
+		if service.IsBudgetError(err) {
+			sendOpenAIError(c.Res, err)
+			return nil, nil
+		}
 		if err != nil {
 			return nil, err
 		}
+		setRouteHeaders(c.Res, route, messageID)
 		defer stream.Close()
 		responseBuffer := strings.Builder{}
+		// The tool calls are streamed in fragments, their arguments are put back together
+		var toolCalls []openai.ToolCall
+		chunks := service.NewStreamChunks(stream, proxyBody.StreamOptions != nil && proxyBody.StreamOptions.IncludeUsage)
 		firstTokenLatencyMs := 0
+		done := false
 		for {
 			resp, err := stream.Recv()
 			// If the stream is done, break out of the loop
 			if errors.Is(err, io.EOF) {
-				_, writeErr := c.Res.Write([]byte("data:[DONE]"))
-				if writeErr != nil {
-					return nil, fmt.Errorf("failed to write response: %w", writeErr)
-				}
+				done = true
 				break
 			}
 			// If the stream is stopped early
 			if err != nil {
+				// The cut off response isn't served from the cache
+				route.CacheKey = ""
 				break
 			}
 
-			// Add the content of resp.Choices[0].Delta.Content to the response buffer
+			// Chunks without choices, like the one with the usage, have nothing to add to the response
 			if len(resp.Choices) > 0 {
+				// Add the content of resp.Choices[0].Delta.Content to the response buffer
 				responseBuffer.WriteString(resp.Choices[0].Delta.Content)
+				toolCalls = service.MergeToolCallDeltas(toolCalls, resp.Choices[0].Delta.ToolCalls)
 				if firstTokenLatencyMs == 0 {
 					firstTokenLatencyMs = int(time.Since(startTime).Milliseconds())
 				}
 			}
 
-			respBytes, err := json.Marshal(resp)
+			respBytes, err := chunks.Chunk(resp)
 			if err != nil {
 				return nil, fmt.Errorf("failed to marshal response to JSON: %w", err)
 			}
+			// The usage that only the proxy asked for isn't sent
+			if respBytes == nil {
+				continue
+			}
 
 			var builder strings.Builder
 
@@ -85,53 +121,112 @@ func (rs Resources) ProxyOpenaiChatCompletion(c *fuego.ContextWithBody[openai.Ch
 
 		// Get the accumulated content from the response buffer
 		responseContent := responseBuffer.String()
+		usage, estimated := service.EstimateUsage(body, service.ResponseText(responseContent, toolCalls), service.StreamUsage(stream))
+		if done {
+			// The client asked for the usage, and the stream didn't send it
+			usageBytes, err := chunks.Usage(usage)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal usage to JSON: %w", err)
+			}
+			if usageBytes != nil {
+				if _, writeErr := c.Res.Write([]byte("data: " + string(usageBytes) + "\n\n")); writeErr != nil {
+					return nil, fmt.Errorf("failed to write response: %w", writeErr)
+				}
+			}
+			_, writeErr := c.Res.Write([]byte("data:[DONE]"))
+			if writeErr != nil {
+				return nil, fmt.Errorf("failed to write response: %w", writeErr)
+			}
+		}
 
 		message := &models.Message{
-			BaseModel:      models.BaseModel{ID: uuid.NewString()},
-			Role:           "assistant",
-			Content:        responseContent,
-			ConversationID: conversation.ID,
-			LLMID:          body.Model,
-			MessageIndex:   len(body.Messages),
+			BaseModel: models.BaseModel{ID: messageID},
+			Role:      "assistant",
+			Content:   responseContent,
+			ToolCalls: toolCalls,
 			Metadata: &models.MessageMetadata{
-				BaseModel:      models.BaseModel{ID: uuid.NewString()},
-				StartLatencyMs: firstTokenLatencyMs,
-				EndLatencyMs:   int(time.Since(startTime).Milliseconds()),
+				BaseModel:        models.BaseModel{ID: uuid.NewString()},
+				StartLatencyMs:   firstTokenLatencyMs,
+				EndLatencyMs:     int(time.Since(startTime).Milliseconds()),
+				InputTokenCount:  usage.PromptTokens,
+				OutputTokenCount: usage.CompletionTokens,
+				EstimatedTokens:  estimated,
 			},
 		}
-		conversation.Messages = append(conversation.Messages, message)
-
-		tx := rs.Service.Db.Save(conversation)
-		if tx.Error != nil {
-			return nil, tx.Error
+		err = rs.Service.AddProxyResponse(route, message)
+		if err != nil {
+			return nil, err
 		}
 
 	} else {
 		startTime := time.Now()
-		response, conversation, err := rs.Service.ProxyOpenaiChat(c.Context(), body, providerId)
+		response, route, err := rs.Service.ProxyOpenaiChat(c.Context(), body, options)
+		if service.IsBudgetError(err) {
+			sendOpenAIError(c.Res, err)
+			return nil, nil
+		}
 		if err != nil {
 			return nil, err
 		}
+		if len(response.Choices) == 0 {
+			// There is no message to log or to send back
+			sendOpenAIError(c.Res, &openai.APIError{
+				Type:           "server_error",
+				HTTPStatusCode: http.StatusBadGateway,
+				Message:        fmt.Sprintf("%s/%s returned no choices", route.ProviderID, route.ModelID),
+			})
+			return nil, nil
+		}
+		setRouteHeaders(c.Res, route, messageID)
 		responseContent = response.Choices[0].Message.Content
+		toolCalls := response.Choices[0].Message.ToolCalls
+		usage, estimated := service.EstimateUsage(body, service.ResponseText(responseContent, toolCalls), response.Usage)
 		message := &models.Message{
-			BaseModel:      models.BaseModel{ID: uuid.NewString()},
-			Role:           "assistant",
-			Content:        responseContent,
-			ConversationID: conversation.ID,
-			LLMID:          body.Model,
-			MessageIndex:   len(body.Messages),
+			BaseModel: models.BaseModel{ID: messageID},
+			Role:      "assistant",
+			Content:   responseContent,
+			ToolCalls: toolCalls,
 			Metadata: &models.MessageMetadata{
-				BaseModel:    models.BaseModel{ID: uuid.NewString()},
-				EndLatencyMs: int(time.Since(startTime).Milliseconds()),
+				BaseModel:        models.BaseModel{ID: uuid.NewString()},
+				EndLatencyMs:     int(time.Since(startTime).Milliseconds()),
+				InputTokenCount:  usage.PromptTokens,
+				OutputTokenCount: usage.CompletionTokens,
+				EstimatedTokens:  estimated,
 			},
 		}
-		conversation.Messages = append(conversation.Messages, message)
-		tx := rs.Service.Db.Save(conversation)
-		if tx.Error != nil {
-			return nil, tx.Error
+		err = rs.Service.AddProxyResponse(route, message)
+		if err != nil {
+			return nil, err
 		}
 		return response, nil
 	}
 
 	return responseContent, nil
 }
+
+func setRouteHeaders(w http.ResponseWriter, route *service.ProxyRoute, messageID string) {
+	// Lets the client send the next request of the chat to the same conversation
+	w.Header().Set("X-Conversation-Id", route.Conversation.ID)
+	// The model that answered, which is a fallback when the requested model failed
+	w.Header().Set("X-Evaluate-Model", route.ProviderID+"/"+route.ModelID)
+	w.Header().Set("X-Evaluate-Message-Id", messageID)
+	if route.CachedFrom != "" {
+		w.Header().Set("X-Evaluate-Cache", "hit")
+	} else if route.CacheKey != "" {
+		w.Header().Set("X-Evaluate-Cache", "miss")
+	}
+	if route.Conversation.SplitArm != "" {
+		w.Header().Set("X-Evaluate-Arm", route.Conversation.SplitArm)
+	}
+}
+
+// sendOpenAIError answers with the status and the body of an OpenAI API error, so clients handle it like one.
+func sendOpenAIError(w http.ResponseWriter, err error) {
+	apiErr := &openai.APIError{}
+	if !errors.As(err, &apiErr) {
+		apiErr = &openai.APIError{Message: err.Error(), HTTPStatusCode: http.StatusInternalServerError}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.HTTPStatusCode)
+	json.NewEncoder(w).Encode(map[string]*openai.APIError{"error": apiErr})
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"github.com/go-fuego/fuego"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func (rs Resources) RegisterShadowRoutes(s *fuego.Server) {
+	ShadowGroup := fuego.Group(s, "/shadow")
+
+	fuego.Get(ShadowGroup, "", rs.getShadows)
+	fuego.Post(ShadowGroup, "", rs.setShadow)
+	fuego.Delete(ShadowGroup, "/{id}", rs.deleteShadow)
+}
+
+func (rs Resources) getShadows(c fuego.ContextNoBody) ([]*models.Shadow, error) {
+	return rs.Service.GetShadows()
+}
+
+// setShadow creates the shadow models of the model, or replaces them.
+func (rs Resources) setShadow(c *fuego.ContextWithBody[models.ShadowCreate]) (*models.Shadow, error) {
+	body, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+	return rs.Service.SetShadow(body)
+}
+
+func (rs Resources) deleteShadow(c fuego.ContextNoBody) (any, error) {
+	return nil, rs.Service.DeleteShadow(c.PathParam("id"))
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"github.com/go-fuego/fuego"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func (rs Resources) RegisterSplitRoutes(s *fuego.Server) {
+	SplitGroup := fuego.Group(s, "/split")
+
+	fuego.Get(SplitGroup, "", rs.getTrafficSplits)
+	fuego.Post(SplitGroup, "", rs.setTrafficSplit)
+	fuego.Delete(SplitGroup, "/{id}", rs.deleteTrafficSplit)
+}
+
+// getTrafficSplits returns the traffic splits with the stats of their arms.
+func (rs Resources) getTrafficSplits(c fuego.ContextNoBody) ([]*models.TrafficSplit, error) {
+	return rs.Service.GetTrafficSplits()
+}
+
+// setTrafficSplit creates the traffic split of the model, or changes its candidate and percent.
+func (rs Resources) setTrafficSplit(c *fuego.ContextWithBody[models.TrafficSplitCreate]) (*models.TrafficSplit, error) {
+	body, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+	return rs.Service.SetTrafficSplit(body)
+}
+
+func (rs Resources) deleteTrafficSplit(c fuego.ContextNoBody) (any, error) {
+	return nil, rs.Service.DeleteTrafficSplit(c.PathParam("id"))
+}
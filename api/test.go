@@ -0,0 +1,70 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-fuego/fuego"
+	service "github.com/y2a-labs/evaluate/services"
+)
+
+func (rs Resources) RegisterTestRoutes(s *fuego.Server) {
+	TestGroup := fuego.Group(s, "/test")
+
+	fuego.Post(TestGroup, "/import", rs.importTests)
+	fuego.GetStd(TestGroup, "/{id}/export", rs.exportTest)
+}
+
+// importTests creates tests from the dataset in the request body.
+// The format, name and comma separated tags are given as query params, the format defaults to jsonl.
+func (rs Resources) importTests(c fuego.ContextNoBody) (*service.ImportResult, error) {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = service.ImportFormatJSONL
+	}
+	name := c.QueryParam("name")
+	if name == "" {
+		name = "Imported test"
+	}
+	defer c.Req.Body.Close()
+	return rs.Service.ImportTests(service.ImportInput{
+		Format: format,
+		Name:   name,
+		Tags:   service.ParseTags(c.QueryParam("tags")),
+		Data:   c.Req.Body,
+	})
+}
+
+// exportTest downloads the results of the test as a csv, json, markdown or html report.
+// The format defaults to json and the version to the latest one.
+func (rs Resources) exportTest(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = service.ExportFormatJSON
+	}
+	contentType, extension, err := service.ExportContentType(format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	version := -1
+	if value := r.URL.Query().Get("version"); value != "" {
+		version, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+	}
+
+	export, err := rs.Service.ExportTest(r.PathValue("id"), version)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("test-%s-v%d.%s", export.TestID, export.Version, extension)))
+	if err := service.WriteTestExport(w, export, format); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"github.com/go-fuego/fuego"
+	"github.com/y2a-labs/evaluate/models"
+	service "github.com/y2a-labs/evaluate/services"
+)
+
+func (rs Resources) RegisterTestRunRoutes(s *fuego.Server) {
+	TestRunGroup := fuego.Group(s, "/testRun")
+
+	fuego.Get(TestRunGroup, "/", rs.getTestRuns)
+	fuego.Get(TestRunGroup, "/{id}", rs.getTestRun)
+	fuego.Get(TestRunGroup, "/{id}/progress", rs.getTestRunProgress)
+	fuego.Post(TestRunGroup, "/{id}/cancel", rs.cancelTestRun)
+}
+
+// getTestRuns lists the runs of the test given with the conversation_id query param.
+func (rs Resources) getTestRuns(c fuego.ContextNoBody) ([]*models.TestRun, error) {
+	conversationID := c.QueryParam("conversation_id")
+	return rs.Service.GetTestRuns(conversationID)
+}
+
+func (rs Resources) getTestRun(c fuego.ContextNoBody) (*models.TestRun, error) {
+	id := c.PathParam("id")
+	testRun, _, err := rs.Service.GetTestRun(id)
+	return testRun, err
+}
+
+// getTestRunProgress returns the progress of a run that was started in the background.
+func (rs Resources) getTestRunProgress(c fuego.ContextNoBody) (*service.TestJobProgress, error) {
+	job, err := rs.Service.GetTestJob(c.PathParam("id"))
+	if err != nil {
+		return nil, err
+	}
+	progress := job.Progress()
+	return &progress, nil
+}
+
+// cancelTestRun cancels a run that was started in the background, the results so far are kept.
+func (rs Resources) cancelTestRun(c fuego.ContextNoBody) (*service.TestJobProgress, error) {
+	job, err := rs.Service.GetTestJob(c.PathParam("id"))
+	if err != nil {
+		return nil, err
+	}
+	job.Cancel()
+	progress := job.Progress()
+	return &progress, nil
+}
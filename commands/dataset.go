@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	service "github.com/y2a-labs/evaluate/services"
+)
+
+type ImportTestsInput struct {
+	DbPath   string
+	EnvPath  string
+	FilePath string
+	Format   string
+	Tags     []string
+}
+
+// ImportTests creates tests from a dataset file and prints the records that couldn't be imported.
+// It returns an error with exit code 1 when any record failed.
+func ImportTests(input ImportTestsInput) error {
+	if input.FilePath == "" {
+		return cli.Exit("select the file to import with --file", 2)
+	}
+	if input.Format == "" {
+		input.Format = service.ImportFormatFromPath(input.FilePath)
+	}
+	file, err := os.Open(input.FilePath)
+	if err != nil {
+		return cli.Exit(err.Error(), 2)
+	}
+	defer file.Close()
+
+	s := service.New(input.DbPath, input.EnvPath)
+	result, err := s.ImportTests(service.ImportInput{
+		Format: input.Format,
+		Name:   strings.TrimSuffix(filepath.Base(input.FilePath), filepath.Ext(input.FilePath)),
+		Tags:   input.Tags,
+		Data:   file,
+	})
+	if err != nil {
+		return cli.Exit(err.Error(), 2)
+	}
+
+	for _, importError := range result.Errors {
+		fmt.Printf("line %d: %s\n", importError.Line, importError.Message)
+	}
+	fmt.Printf("%d tests created, %d already imported, %d failed\n", result.Created, result.Skipped, len(result.Errors))
+	if len(result.Errors) > 0 {
+		return cli.Exit(fmt.Sprintf("%d records could not be imported", len(result.Errors)), 1)
+	}
+	return nil
+}
+
+type ExportTestInput struct {
+	DbPath   string
+	EnvPath  string
+	TestID   string
+	Version  int
+	Format   string
+	FilePath string
+}
+
+// ExportTest writes the results of a test as a report to a file, or to stdout when no file is given.
+func ExportTest(input ExportTestInput) error {
+	if input.TestID == "" {
+		return cli.Exit("select the test to export with --test", 2)
+	}
+	if _, _, err := service.ExportContentType(input.Format); err != nil {
+		return cli.Exit(err.Error(), 2)
+	}
+
+	s := service.New(input.DbPath, input.EnvPath)
+	export, err := s.ExportTest(input.TestID, input.Version)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("test not found: %s", input.TestID), 2)
+	}
+
+	if input.FilePath == "" {
+		return service.WriteTestExport(os.Stdout, export, input.Format)
+	}
+	file, err := os.Create(input.FilePath)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	defer file.Close()
+	if err := service.WriteTestExport(file, export, input.Format); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	return nil
+}
@@ -47,6 +47,11 @@ func logRequest(next http.Handler) http.Handler {
 	})
 }
 
+// Unwrap lets http.ResponseController reach the original writer, so streamed responses can be flushed.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func removeURLTrailingSlash(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println(r.URL.Path)
@@ -69,13 +74,9 @@ func StartServer(port string, dev bool) {
 		options = append([]func(*fuego.Server){fuego.WithTemplateFS(templates.FS)}, options...)
 	}
 
+	// In dev mode the templates are read from the disk, and fuego parses the rendered pages again on every request
 	server := fuego.NewServer(options...)
 
-	// Reparses the templates html/templates on every request
-	if dev {
-		server.DevMode()
-	}
-
 	service := service.New("./data/data.db", "./.env")
 
 	// Logs the requests
@@ -119,6 +120,12 @@ func StartServer(port string, dev bool) {
 	webResources.RegisterPromptRoutes(webGroup)
 	webResources.RegisterProviderRoutes(webGroup)
 	webResources.RegisterMessageMetadataRoutes(webGroup)
+	webResources.RegisterBudgetRoutes(webGroup)
+	webResources.RegisterFallbackRoutes(webGroup)
+	webResources.RegisterAliasRoutes(webGroup)
+	webResources.RegisterSplitRoutes(webGroup)
+	webResources.RegisterShadowRoutes(webGroup)
+	webResources.RegisterResponseCacheRoutes(webGroup)
 
 	apiResources := api.Resources{Service: service}
 
@@ -133,6 +140,14 @@ func StartServer(port string, dev bool) {
 	apiResources.RegisterPromptRoutes(apiGroup)
 	apiResources.RegisterProviderRoutes(apiGroup)
 	apiResources.RegisterMessageMetadataRoutes(apiGroup)
+	apiResources.RegisterTestRunRoutes(apiGroup)
+	apiResources.RegisterTestRoutes(apiGroup)
+	apiResources.RegisterBudgetRoutes(apiGroup)
+	apiResources.RegisterFallbackRoutes(apiGroup)
+	apiResources.RegisterAliasRoutes(apiGroup)
+	apiResources.RegisterSplitRoutes(apiGroup)
+	apiResources.RegisterShadowRoutes(apiGroup)
+	apiResources.RegisterResponseCacheRoutes(apiGroup)
 
 	// Run the server
 	err := server.Run()
@@ -0,0 +1,290 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/y2a-labs/evaluate/models"
+	service "github.com/y2a-labs/evaluate/services"
+)
+
+type RunTestsInput struct {
+	DbPath    string
+	EnvPath   string
+	TestIDs   []string
+	Tags      []string
+	RunCount  int
+	Threshold float64
+	JUnitPath string
+	JSONPath  string
+}
+
+// TestReport is the result of running a single test from the command line.
+type TestReport struct {
+	TestID   string            `json:"test_id"`
+	TestName string            `json:"test_name"`
+	Run      *models.TestRun   `json:"run"`
+	Models   []ModelTestReport `json:"models"`
+	Error    string            `json:"error,omitempty"`
+	Duration time.Duration     `json:"duration_ns"`
+}
+
+type ModelTestReport struct {
+	Provider string  `json:"provider"`
+	Model    string  `json:"model"`
+	Settings string  `json:"settings,omitempty"`
+	Score    float64 `json:"score"`
+	Errors   int     `json:"errors"`
+	Scored   int     `json:"scored"`
+	Passed   bool    `json:"passed"`
+	Failure  string  `json:"failure,omitempty"`
+}
+
+// newModelTestReport checks the results of a model against the threshold. A model whose calls errored or
+// that has no scored results fails whatever its score, since the score doesn't cover all of its results.
+func newModelTestReport(testModel models.TestModels, errors int, threshold float64) ModelTestReport {
+	report := ModelTestReport{
+		Provider: testModel.Provider,
+		Model:    testModel.Model,
+		Settings: testModel.GenerationParams.String(),
+		Score:    testModel.Score,
+		Errors:   errors,
+		Scored:   testModel.ScoredCount,
+	}
+	switch {
+	case errors > 0:
+		report.Failure = fmt.Sprintf("%d of its calls failed", errors)
+	case testModel.ScoredCount == 0:
+		report.Failure = "no results were scored"
+	case testModel.Score < threshold:
+		report.Failure = fmt.Sprintf("score %.2f%% is below the threshold of %.2f%%", testModel.Score, threshold)
+	}
+	report.Passed = report.Failure == ""
+	return report
+}
+
+// RunTests runs every selected test against its configured models and returns an error
+// with exit code 1 when a test fails or a model scores below the threshold.
+func RunTests(input RunTestsInput) error {
+	if len(input.TestIDs) == 0 && len(input.Tags) == 0 {
+		return cli.Exit("select the tests to run with --test or --tag", 2)
+	}
+	if input.RunCount < 1 {
+		input.RunCount = 1
+	}
+
+	s := service.New(input.DbPath, input.EnvPath)
+
+	tests, err := selectTests(s, input.TestIDs, input.Tags)
+	if err != nil {
+		return cli.Exit(err.Error(), 2)
+	}
+	if len(tests) == 0 {
+		return cli.Exit("no tests found", 2)
+	}
+
+	reports := make([]*TestReport, len(tests))
+	for i, test := range tests {
+		fmt.Printf("Running %s (%s)...\n", test.Name, test.ID)
+		reports[i] = runTest(s, test, input)
+	}
+
+	failed := printReports(reports)
+
+	if input.JSONPath != "" {
+		if err := writeJSONReport(input.JSONPath, reports); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+	}
+	if input.JUnitPath != "" {
+		if err := writeJUnitReport(input.JUnitPath, reports); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+	}
+
+	if failed > 0 {
+		return cli.Exit(fmt.Sprintf("%d of %d tests failed", failed, len(reports)), 1)
+	}
+	return nil
+}
+
+func selectTests(s *service.Service, testIDs, tags []string) ([]*models.Conversation, error) {
+	tests := []*models.Conversation{}
+	seen := map[string]bool{}
+	for _, id := range testIDs {
+		conversation, err := s.GetConversation(id)
+		if err != nil {
+			return nil, fmt.Errorf("test not found: %s", id)
+		}
+		if !conversation.IsTest {
+			return nil, fmt.Errorf("conversation %s is not a test", id)
+		}
+		seen[conversation.ID] = true
+		tests = append(tests, conversation)
+	}
+
+	if len(tags) > 0 {
+		tagged, err := s.GetTestsByTags(tags)
+		if err != nil {
+			return nil, err
+		}
+		for _, conversation := range tagged {
+			if !seen[conversation.ID] {
+				seen[conversation.ID] = true
+				tests = append(tests, conversation)
+			}
+		}
+	}
+	return tests, nil
+}
+
+func runTest(s *service.Service, test *models.Conversation, input RunTestsInput) *TestReport {
+	report := &TestReport{TestID: test.ID, TestName: test.Name}
+	// A test without models has nothing to score, it would pass without checking anything
+	if len(test.TestModels) == 0 {
+		report.Error = "the test has no models to run"
+		return report
+	}
+	start := time.Now()
+	testRun, err := s.ExecuteTestWorkflow(service.ExecuteTestInput{
+		Context:        context.Background(),
+		RunCount:       input.RunCount,
+		ConversationID: test.ID,
+	})
+	report.Duration = time.Since(start)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	// The results are sent back on the run, keep the report small
+	testRun.Messages = nil
+	report.Run = testRun
+
+	errorCount := map[string]int{}
+	for _, runError := range testRun.Errors {
+		key := runError.TestModelID
+		if key == "" {
+			key = runError.LLMID
+		}
+		errorCount[key]++
+	}
+
+	for _, testModel := range testRun.Models {
+		report.Models = append(report.Models, newModelTestReport(testModel, errorCount[testModel.Key()], input.Threshold))
+	}
+	return report
+}
+
+// printReports writes a summary table to stdout and returns the number of failed tests.
+func printReports(reports []*TestReport) int {
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TEST\tPROVIDER\tMODEL\tSETTINGS\tSCORE\tERRORS\tRESULT")
+	for _, report := range reports {
+		if report.Error != "" {
+			failed++
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\tERROR: %s\n", report.TestName, report.Error)
+			continue
+		}
+		testFailed := false
+		for _, model := range report.Models {
+			result := "PASS"
+			if !model.Passed {
+				result = "FAIL"
+				testFailed = true
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.2f%%\t%d\t%s\n", report.TestName, model.Provider, model.Model, model.Settings, model.Score, model.Errors, result)
+		}
+		if testFailed {
+			failed++
+		}
+	}
+	w.Flush()
+	return failed
+}
+
+func writeJSONReport(path string, reports []*TestReport) error {
+	data, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to create json report: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeJUnitReport writes a testsuite per test and a testcase per model.
+func writeJUnitReport(path string, reports []*TestReport) error {
+	suites := junitTestSuites{Name: "evaluate"}
+	for _, report := range reports {
+		suite := junitTestSuite{
+			Name: report.TestName,
+			Time: fmt.Sprintf("%.3f", report.Duration.Seconds()),
+		}
+		if report.Error != "" {
+			suite.Errors++
+			suite.TestCases = append(suite.TestCases, junitTestCase{
+				ClassName: report.TestName,
+				Name:      report.TestID,
+				Error:     &junitFailure{Message: report.Error},
+			})
+		}
+		for _, model := range report.Models {
+			testCase := junitTestCase{ClassName: report.TestName, Name: model.Provider + "/" + model.Model}
+			if model.Settings != "" {
+				testCase.Name += " (" + model.Settings + ")"
+			}
+			if !model.Passed {
+				suite.Failures++
+				testCase.Failure = &junitFailure{Message: model.Failure}
+			}
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+		suite.Tests = len(suite.TestCases)
+
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to create junit report: %w", err)
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), data...), 0644)
+}
@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+	service "github.com/y2a-labs/evaluate/services"
+)
+
+func TestNewModelTestReport(t *testing.T) {
+	testModel := models.TestModels{Provider: "openai", Model: "gpt-4o", Score: 90, ScoredCount: 3}
+	assert.True(t, newModelTestReport(testModel, 0, 80).Passed)
+	assert.False(t, newModelTestReport(testModel, 0, 95).Passed)
+
+	// A model with failed calls fails even when its scored results pass
+	report := newModelTestReport(testModel, 1, 80)
+	assert.False(t, report.Passed)
+	assert.Equal(t, "1 of its calls failed", report.Failure)
+
+	// A model whose calls all errored has no score, and doesn't pass at a threshold of 0
+	errored := models.TestModels{Provider: "openai", Model: "gpt-4o"}
+	assert.False(t, newModelTestReport(errored, 3, 0).Passed)
+	report = newModelTestReport(errored, 0, 0)
+	assert.False(t, report.Passed)
+	assert.Equal(t, "no results were scored", report.Failure)
+}
+
+func TestRunTestWithoutModels(t *testing.T) {
+	dir := t.TempDir()
+	s := service.New(filepath.Join(dir, "test.db"), filepath.Join(dir, ".env"))
+	test := &models.Conversation{Name: "empty", IsTest: true}
+	test.ID = "empty"
+
+	report := runTest(s, test, RunTestsInput{RunCount: 1, Threshold: 80})
+	assert.Equal(t, "the test has no models to run", report.Error)
+	assert.Empty(t, report.Models)
+	assert.Equal(t, 1, printReports([]*TestReport{report}))
+}
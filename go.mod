@@ -12,6 +12,7 @@ require (
 	github.com/urfave/cli/v2 v2.27.1
 	golang.org/x/time v0.5.0
 	gorm.io/datatypes v1.2.0
+	gorm.io/driver/sqlite v1.5.5
 	gorm.io/gorm v1.25.7
 )
 
@@ -59,7 +60,6 @@ require (
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 	gorm.io/driver/mysql v1.4.7 // indirect
 	gorm.io/driver/postgres v1.5.6 // indirect
-	gorm.io/driver/sqlite v1.5.5 // indirect
 	modernc.org/libc v1.22.5 // indirect
 	modernc.org/mathutil v1.5.0 // indirect
 	modernc.org/memory v1.5.0 // indirect
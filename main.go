@@ -37,6 +37,152 @@ func main() {
 					return nil
 				},
 			},
+			{
+				Name:    "test",
+				Aliases: []string{"t"},
+				Usage:   "manage tests",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "run",
+						Usage: "run tests against their models and fail when a score is below the threshold",
+						Flags: []cli.Flag{
+							&cli.StringSliceFlag{
+								Name:  "test",
+								Usage: "ID of a test to run, can be repeated",
+							},
+							&cli.StringSliceFlag{
+								Name:  "tag",
+								Usage: "Run every test with this tag, can be repeated",
+							},
+							&cli.IntFlag{
+								Name:  "runs",
+								Usage: "The number of responses to generate for every message",
+								Value: 1,
+							},
+							&cli.Float64Flag{
+								Name:  "threshold",
+								Usage: "The minimum average score (0-100) each model needs to pass",
+								Value: 0,
+							},
+							&cli.StringFlag{
+								Name:  "junit",
+								Usage: "Write the results as JUnit XML to this file",
+							},
+							&cli.StringFlag{
+								Name:  "json",
+								Usage: "Write the results as JSON to this file",
+							},
+							&cli.StringFlag{
+								Name:  "db",
+								Usage: "The database to use",
+								Value: "./data/data.db",
+							},
+							&cli.StringFlag{
+								Name:  "env",
+								Usage: "The .env file with the encryption key",
+								Value: "./.env",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							return commands.RunTests(commands.RunTestsInput{
+								DbPath:    cCtx.String("db"),
+								EnvPath:   cCtx.String("env"),
+								TestIDs:   cCtx.StringSlice("test"),
+								Tags:      cCtx.StringSlice("tag"),
+								RunCount:  cCtx.Int("runs"),
+								Threshold: cCtx.Float64("threshold"),
+								JUnitPath: cCtx.String("junit"),
+								JSONPath:  cCtx.String("json"),
+							})
+						},
+					},
+					{
+						Name:  "import",
+						Usage: "create tests from a JSONL, OpenAI fine-tuning, ShareGPT or CSV dataset",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "file",
+								Aliases:  []string{"f"},
+								Usage:    "The dataset to import",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "jsonl, openai, sharegpt or csv, guessed from the file extension when not set",
+							},
+							&cli.StringSliceFlag{
+								Name:  "tag",
+								Usage: "Add this tag to every imported test, can be repeated",
+							},
+							&cli.StringFlag{
+								Name:  "db",
+								Usage: "The database to use",
+								Value: "./data/data.db",
+							},
+							&cli.StringFlag{
+								Name:  "env",
+								Usage: "The .env file with the encryption key",
+								Value: "./.env",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							return commands.ImportTests(commands.ImportTestsInput{
+								DbPath:   cCtx.String("db"),
+								EnvPath:  cCtx.String("env"),
+								FilePath: cCtx.String("file"),
+								Format:   cCtx.String("format"),
+								Tags:     cCtx.StringSlice("tag"),
+							})
+						},
+					},
+					{
+						Name:  "export",
+						Usage: "write the results of a test as a csv, json, markdown or html report",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "test",
+								Usage:    "ID of the test to export",
+								Required: true,
+							},
+							&cli.IntFlag{
+								Name:  "version",
+								Usage: "The version of the test, the latest one when not set",
+								Value: -1,
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "csv, json, markdown or html",
+								Value: "markdown",
+							},
+							&cli.StringFlag{
+								Name:    "out",
+								Aliases: []string{"o"},
+								Usage:   "Write the report to this file instead of stdout",
+							},
+							&cli.StringFlag{
+								Name:  "db",
+								Usage: "The database to use",
+								Value: "./data/data.db",
+							},
+							&cli.StringFlag{
+								Name:  "env",
+								Usage: "The .env file with the encryption key",
+								Value: "./.env",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							return commands.ExportTest(commands.ExportTestInput{
+								DbPath:   cCtx.String("db"),
+								EnvPath:  cCtx.String("env"),
+								TestID:   cCtx.String("test"),
+								Version:  cCtx.Int("version"),
+								Format:   cCtx.String("format"),
+								FilePath: cCtx.String("out"),
+							})
+						},
+					},
+				},
+			},
 		},
 	}
 	err := app.Run(os.Args)
@@ -0,0 +1,19 @@
+package models
+
+// Alias is a model name of the proxy, like chat-default, that is sent to a saved provider and model with default
+// generation parameters. The model behind an alias can be switched without changing the clients.
+type Alias struct {
+	BaseModel
+	Name       string `gorm:"uniqueIndex" json:"name"`
+	ProviderID string `json:"provider_id"`
+	Model      string `json:"model"`
+	// Params are sent with the requests that don't set them
+	Params GenerationParams `gorm:"serializer:json" json:"params"`
+}
+
+type AliasCreate struct {
+	Name       string           `json:"name"`
+	ProviderID string           `json:"provider_id"`
+	Model      string           `json:"model"`
+	Params     GenerationParams `json:"params"`
+}
@@ -0,0 +1,71 @@
+package models
+
+const (
+	BudgetPeriodDaily   = "daily"
+	BudgetPeriodMonthly = "monthly"
+
+	BudgetScopeProvider = "provider"
+	BudgetScopeClient   = "client"
+)
+
+// Budget caps the spend in dollars per day and per month, a zero budget has no cap.
+type Budget struct {
+	DailyBudget   float64 `json:"daily_budget"`
+	MonthlyBudget float64 `json:"monthly_budget"`
+	// BudgetWarning is the percent of a budget that is spent when a warning is shown
+	BudgetWarning float64 `gorm:"default:80" json:"budget_warning"`
+}
+
+// BudgetUpdate changes the budgets that are set, a nil value is left as it is.
+type BudgetUpdate struct {
+	DailyBudget   *float64 `json:"daily_budget"`
+	MonthlyBudget *float64 `json:"monthly_budget"`
+	BudgetWarning *float64 `json:"budget_warning"`
+}
+
+// Apply sets the values of the update on the budget.
+func (u BudgetUpdate) Apply(budget *Budget) {
+	if u.DailyBudget != nil {
+		budget.DailyBudget = *u.DailyBudget
+	}
+	if u.MonthlyBudget != nil {
+		budget.MonthlyBudget = *u.MonthlyBudget
+	}
+	if u.BudgetWarning != nil {
+		budget.BudgetWarning = *u.BudgetWarning
+	}
+}
+
+// ClientBudget caps the spend of a calling client, which is told apart by the app it sends with its requests.
+type ClientBudget struct {
+	BaseModel
+	App string `gorm:"uniqueIndex" json:"app"`
+	Budget
+	// Budgets is the spend of the budgets of the client
+	Budgets []BudgetStatus `gorm:"-" json:"budgets"`
+}
+
+type ClientBudgetCreate struct {
+	App string `json:"app"`
+	BudgetUpdate
+}
+
+// BudgetStatus is the spend of a budget in its current period.
+type BudgetStatus struct {
+	Scope  string  `json:"scope"`
+	Name   string  `json:"name"`
+	Period string  `json:"period"`
+	Spent  float64 `json:"spent"`
+	Limit  float64 `json:"limit"`
+	// Warning is set once the spend reaches the warning percent of the budget
+	Warning  bool `json:"warning"`
+	Exceeded bool `json:"exceeded"`
+}
+
+// Percent returns how much of the budget is spent.
+func (b BudgetStatus) Percent() float64 {
+	if b.Limit == 0 {
+		return 0
+	}
+	return b.Spent / b.Limit * 100
+}
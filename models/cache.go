@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// ResponseCache serves the proxied requests of a model name from the logged response of an identical request,
+// as long as that response is younger than the time to live. With a similarity threshold, it also serves the
+// response of a request whose last user message is similar enough, with the same parameters and system prompt.
+type ResponseCache struct {
+	BaseModel
+	// Model is the requested model name, like an alias or a provider/model name
+	Model      string `gorm:"uniqueIndex" json:"model"`
+	TTLSeconds int    `json:"ttlSeconds"`
+	// SimilarityThreshold is the cosine similarity of the embeddings from which a request is a hit, 0 turns off
+	// the semantic cache
+	SimilarityThreshold float64    `json:"similarityThreshold"`
+	Stats               CacheStats `gorm:"-" json:"stats"`
+}
+
+func (c *ResponseCache) TTL() time.Duration {
+	return time.Duration(c.TTLSeconds) * time.Second
+}
+
+// CacheStats counts the proxied requests of a response cache.
+type CacheStats struct {
+	Hits int64 `json:"hits"`
+	// SemanticHits are the hits that were answered with the response of a similar request
+	SemanticHits int64   `json:"semanticHits"`
+	Misses       int64   `json:"misses"`
+	SavedCost    float64 `json:"savedCost"`
+}
+
+// HitPercent is the percent of the requests that were answered from the cache.
+func (s CacheStats) HitPercent() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses) * 100
+}
+
+type ResponseCacheCreate struct {
+	Model               string  `json:"model"`
+	TTLSeconds          int     `json:"ttlSeconds"`
+	SimilarityThreshold float64 `json:"similarityThreshold"`
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/sashabaranov/go-openai"
 	"gorm.io/datatypes"
 )
@@ -22,25 +25,154 @@ type Conversation struct {
 	IsTest     bool
 	TestModels datatypes.JSONSlice[TestModels]
 	TestCount  int
+	Tags       datatypes.JSONSlice[string] `json:"tags"`
+	// Scorer is the name of the scorer used to grade the test messages
+	Scorer       string `gorm:"default:cosine"`
+	ScorerConfig string
+	// JudgeModel and JudgePromptID configure the llm_judge scorer
+	JudgeModel    string
+	JudgePromptID string
+	// TestPromptIDs are the prompt versions used as the system prompt of the test, each one is run against every test model
+	TestPromptIDs datatypes.JSONSlice[string] `json:"test_prompt_ids"`
+	// PromptScores are the scores of the test models for each prompt version
+	PromptScores []PromptScore `gorm:"-" json:"prompt_scores,omitempty"`
+	// TestMode is either replay, where a single assistant turn is generated for the recorded history,
+	// or simulate, where a user simulator model converses with the tested model
+	TestMode string `gorm:"default:replay" json:"test_mode"`
+	// SimulatorModel, SimulatorPromptID and SimulatorTurns configure the user simulator
+	SimulatorModel    string `json:"simulator_model"`
+	SimulatorPromptID string `json:"simulator_prompt_id"`
+	SimulatorTurns    int    `json:"simulator_turns"`
+	// ImportKey identifies the messages of an imported test, so importing the same dataset again skips it
+	ImportKey string `gorm:"index" json:"import_key,omitempty"`
+	// ThreadHash is the hash of the messages of a proxied conversation, the next request of the chat starts with them
+	ThreadHash string `gorm:"index" json:"-"`
+	// User, App and Metadata are sent with proxied requests to tell the traffic of apps and their users apart
+	User     string            `gorm:"index" json:"user,omitempty"`
+	App      string            `gorm:"index" json:"app,omitempty"`
+	Metadata datatypes.JSONMap `json:"metadata,omitempty"`
+	// SplitModel and SplitArm are the traffic split of the requested model that the conversation was assigned to,
+	// and its arm, control or candidate
+	SplitModel string `gorm:"index" json:"split_model,omitempty"`
+	SplitArm   string `json:"split_arm,omitempty"`
+	// Tools are the definitions of the tools of the last proxied request, tests send them again
+	Tools datatypes.JSONSlice[openai.Tool] `json:"tools,omitempty"`
+	// Cost is the price in dollars of the logged responses of the conversation
+	Cost float64 `gorm:"-" json:"cost"`
+}
+
+const (
+	TestModeReplay   = "replay"
+	TestModeSimulate = "simulate"
+)
+
+type SimulatorUpdate struct {
+	TestMode          string
+	SimulatorModel    string
+	SimulatorPromptID string
+	SimulatorTurns    int
+}
+
+type ScorerUpdate struct {
+	Scorer        string
+	ScorerConfig  string
+	JudgeModel    string
+	JudgePromptID string
 }
 
 type TestModels struct {
+	// ID tells apart entries of the same model with different generation parameters.
+	// Entries added before the parameters existed don't have one and are told apart by Model.
+	ID       string
 	Provider string
 	Model    string
 	Score    float64
+	// Cost is the price in dollars of the responses of the model
+	Cost float64
+	// ScoredCount is the number of results of the model that were scored in the run
+	ScoredCount int `json:",omitempty"`
+	GenerationParams
+}
+
+// Key identifies the entry in the results of a test.
+func (t TestModels) Key() string {
+	if t.ID != "" {
+		return t.ID
+	}
+	return t.Model
+}
+
+// GenerationParams are sent with every completion request of a tested model, unset values use the provider default.
+type GenerationParams struct {
+	Temperature *float32 `json:",omitempty"`
+	TopP        *float32 `json:",omitempty"`
+	MaxTokens   int      `json:",omitempty"`
+	Seed        *int     `json:",omitempty"`
+	Stop        []string `json:",omitempty"`
+}
+
+// String describes the parameters that are set, for example "temperature=0.7, max_tokens=200".
+func (p GenerationParams) String() string {
+	params := []string{}
+	if p.Temperature != nil {
+		params = append(params, fmt.Sprintf("temperature=%g", *p.Temperature))
+	}
+	if p.TopP != nil {
+		params = append(params, fmt.Sprintf("top_p=%g", *p.TopP))
+	}
+	if p.MaxTokens > 0 {
+		params = append(params, fmt.Sprintf("max_tokens=%d", p.MaxTokens))
+	}
+	if p.Seed != nil {
+		params = append(params, fmt.Sprintf("seed=%d", *p.Seed))
+	}
+	if len(p.Stop) > 0 {
+		params = append(params, fmt.Sprintf("stop=%q", p.Stop))
+	}
+	return strings.Join(params, ", ")
 }
 
 type ChatCompletionMessage struct {
-	Role    string
-	Content string
+	Role       string
+	Content    string
+	Name       string
+	ToolCalls  []openai.ToolCall
+	ToolCallID string
+}
+
+// NewChatCompletionMessage keeps the tool calls of the message, along with its role and content.
+func NewChatCompletionMessage(message openai.ChatCompletionMessage) ChatCompletionMessage {
+	return ChatCompletionMessage{
+		Role:       message.Role,
+		Content:    message.Content,
+		Name:       message.Name,
+		ToolCalls:  message.ToolCalls,
+		ToolCallID: message.ToolCallID,
+	}
 }
 
 type ConversationCreate struct {
+	ID          string `json:"-"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	LLMID       string
 	IsTest      bool
+	Tags        []string `json:"tags"`
 	Messages    []openai.ChatCompletionMessage
+	Tools       []openai.Tool     `json:"tools"`
+	ImportKey   string            `json:"-"`
+	User        string            `json:"user"`
+	App         string            `json:"app"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+// ConversationFilter narrows down the list of logged conversations, empty fields match every conversation.
+type ConversationFilter struct {
+	// Tags matches the conversations that have at least one of the tags
+	Tags     []string
+	User     string
+	App      string
+	Metadata map[string]string
 }
 
 type ConversationUpdate struct {
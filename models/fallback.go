@@ -0,0 +1,19 @@
+package models
+
+import "gorm.io/datatypes"
+
+// FallbackChain lists the models that answer the proxied requests of a model when it fails, like during
+// an outage of its provider. The fallbacks are tried in order.
+type FallbackChain struct {
+	BaseModel
+	// Model is the model name that the clients request, like openrouter/x
+	Model string `gorm:"uniqueIndex" json:"model"`
+	// Fallbacks are provider/model names, like local/x or openai/gpt-4o-mini
+	Fallbacks datatypes.JSONSlice[string] `json:"fallbacks"`
+}
+
+type FallbackChainCreate struct {
+	Model string `json:"model"`
+	// Fallbacks can also be given as one comma separated list, as sent by the form of the web UI
+	Fallbacks []string `json:"fallbacks"`
+}
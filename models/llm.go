@@ -9,6 +9,14 @@ type LLM struct {
 	DeletedAt  *time.Time `json:"-"`
 	ProviderID string
 	Provider   Provider
+	// InputPrice and OutputPrice are the prices in dollars per million tokens
+	InputPrice  float64 `json:"input_price"`
+	OutputPrice float64 `json:"output_price"`
+}
+
+// Cost returns the price in dollars of a response with the token counts.
+func (l *LLM) Cost(inputTokens, outputTokens int) float64 {
+	return (float64(inputTokens)*l.InputPrice + float64(outputTokens)*l.OutputPrice) / 1_000_000
 }
 
 type LLMCreate struct {
@@ -19,5 +27,17 @@ type LLMCreate struct {
 
 type LLMUpdate struct {
 	// TODO add ressources
-	ID string `json:"id"`
+	ID          string  `json:"id"`
+	ProviderID  string  `json:"provider_id" form:"providerID"`
+	InputPrice  float64 `json:"input_price" form:"inputPrice"`
+	OutputPrice float64 `json:"output_price" form:"outputPrice"`
+}
+
+// LLMPrice is an entry of a price sheet, the prices are in dollars per million tokens.
+// An entry without a provider sets the price of the model at every provider.
+type LLMPrice struct {
+	Provider    string  `json:"provider"`
+	Model       string  `json:"model"`
+	InputPrice  float64 `json:"input"`
+	OutputPrice float64 `json:"output"`
 }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"github.com/sashabaranov/go-openai"
 	"gorm.io/datatypes"
 )
 
@@ -11,15 +12,37 @@ type Message struct {
 	BaseModel
 	Role                string `example:"user" json:"role"`
 	Content             string `example:"Hello, world!" json:"content"`
+	// Name is the name of the author of the message, when the request gave one
+	Name string `json:"name,omitempty"`
+	// ToolCalls are the tools that an assistant message calls, with their arguments
+	ToolCalls datatypes.JSONSlice[openai.ToolCall] `json:"tool_calls,omitempty"`
+	// ToolCallID is the tool call that a tool message is the result of
+	ToolCallID string `json:"tool_call_id,omitempty"`
 	MessageIndex        int
 	ConversationID      string
 	LLMID               string
+	ProviderID          string `gorm:"index"` // The provider that generated the message, its cost counts towards the budgets of the provider
 	ConversationVersion int `gorm:"default:0"`
 	TestMessageID       string
+	TestRunID           string // Set on the results of a test run, and on the messages of the transcripts of simulated runs
+	TestModelID         string // The tested model entry that generated the message
+	PromptID            string // The prompt version used as the system prompt of the test message
+	TranscriptVersion   int    // The version of the test holding the transcript of a simulated test message
 	TestMessages        []*Message `gorm:"foreignKey:TestMessageID" json:"-"` //
 	Metadata            *MessageMetadata
 	Score               float64 `gorm:"-"`
 	Count               int `gorm:"-"`
+	Settings            string `gorm:"-"` // The generation parameters of the tested model entry
+	PromptLabel         string `gorm:"-"`
+	// Transcript is the simulated conversation until it is saved
+	Transcript []*Message `gorm:"-" json:"-"`
+	// TestMessageGroups holds the test messages grouped by prompt version when the test has prompt versions
+	TestMessageGroups []TestMessageGroup `gorm:"-" json:"-"`
+}
+
+type TestMessageGroup struct {
+	PromptLabel string
+	Messages    []*Message
 }
 
 type MessageUpdate struct {
@@ -30,3 +53,22 @@ type MessageUpdate struct {
 type MessageCreate struct {
 	ID string `json:"id"`
 }
+
+// ChatCompletionMessage returns the message in the OpenAI format, as it is sent to a model.
+func (m *Message) ChatCompletionMessage() openai.ChatCompletionMessage {
+	return openai.ChatCompletionMessage{
+		Role:       m.Role,
+		Content:    m.Content,
+		Name:       m.Name,
+		ToolCalls:  m.ToolCalls,
+		ToolCallID: m.ToolCallID,
+	}
+}
+
+// TestModelKey returns the key of the tested model entry that generated the message.
+func (m *Message) TestModelKey() string {
+	if m.TestModelID != "" {
+		return m.TestModelID
+	}
+	return m.LLMID
+}
@@ -11,7 +11,36 @@ type MessageMetadata struct {
 	EndLatencyMs     int
 	OutputTokenCount int
 	InputTokenCount  int
-	Embedding        datatypes.JSONSlice[float32]
+	// EstimatedTokens is set when the provider didn't return the token counts, and they were estimated
+	EstimatedTokens bool
+	// Cost is the price in dollars of the message, from the prices of its model
+	Cost float64
+	// Alias is the alias of the proxy that named the model of the response
+	Alias string
+	// SplitArm is the arm of the traffic split whose model answered, a candidate that fell back to the requested
+	// model is answered by the control arm
+	SplitArm string
+	// FallbackFrom is the requested model when the response came from a model of its fallback chain
+	FallbackFrom string
+	// FallbackErrors are the errors of the models that were tried before the one that answered
+	FallbackErrors datatypes.JSONSlice[string]
+	// CacheModel is the model name of the response cache that the request went through
+	CacheModel string `gorm:"index"`
+	// CacheKey is the hash of the request when its model has a response cache, identical requests find the response by it
+	CacheKey string `gorm:"index"`
+	// CacheScope is the hash of the model, the parameters and the system prompt, similar requests find the response by it
+	CacheScope string `gorm:"index"`
+	// CachedFrom is the ID of the logged response that was served from the cache, the message then costs nothing
+	// and SavedCost is what the request would have cost
+	CachedFrom string
+	SavedCost  float64
+	// Similarity is the cosine similarity of the last user message to the one of the cached response, it is only
+	// set when the response came from a similar request
+	Similarity     float64
+	Embedding      datatypes.JSONSlice[float32]
+	Scorer         string
+	Score          float64
+	ScoreRationale string
 }
 
 type MessageMetadataCreate struct {
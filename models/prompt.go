@@ -1,5 +1,7 @@
 package models
 
+import "fmt"
+
 type Prompt struct {
 	BaseModel
 	Content      string `json:"content"`
@@ -8,9 +10,19 @@ type Prompt struct {
 	Version      int    `json:"version"`
 }
 
+// Label names the prompt along with its version, older versions are named after the prompt they were copied from.
+func (p Prompt) Label() string {
+	name := p.ID
+	if p.BasePromptID != "" {
+		name = p.BasePromptID
+	}
+	return fmt.Sprintf("%s v%d", name, p.Version)
+}
+
 type PromptCreate struct {
-	// TODO add ressources
-	ID string `json:"id"`
+	ID      string `json:"id"`
+	Content string `json:"content"`
+	AgentID string `json:"agent_id"`
 }
 
 type PromptUpdate struct {
@@ -10,6 +10,9 @@ type Provider struct {
 	Models          []*LLM `json:"-"`
 	Interval        int
 	Unit            string
+	Budget
+	// Budgets is the spend of the budgets of the provider, when they are loaded
+	Budgets []BudgetStatus `gorm:"-" json:"-"`
 }
 
 type ProviderCreate struct {
@@ -21,6 +24,7 @@ type ProviderCreate struct {
 	Requests int
 	Interval int
 	Unit     string
+	BudgetUpdate
 }
 
 type ProviderUpdate struct {
@@ -30,4 +34,5 @@ type ProviderUpdate struct {
 	Requests int
 	Interval int
 	Unit     string
+	BudgetUpdate
 }
@@ -0,0 +1,18 @@
+package models
+
+import "github.com/sashabaranov/go-openai"
+
+// ProxyChatCompletionRequest is the body of a proxied chat completion. The metadata of the request
+// is logged with the conversation, it isn't sent to the provider.
+type ProxyChatCompletionRequest struct {
+	openai.ChatCompletionRequest
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// The openai client doesn't have the stream options, they are read here
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	// The openai request leaves out a zero temperature, it is read here to tell it from a missing one
+	Temperature *float32 `json:"temperature,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "gorm.io/datatypes"
+
+// Shadow mirrors a percent of the proxied requests of a model name to shadow models in the background. Their
+// outputs are saved as test messages of the logged response, the client only gets the response of the model.
+type Shadow struct {
+	BaseModel
+	// Model is the requested model name, like an alias or a provider/model name
+	Model string `gorm:"uniqueIndex" json:"model"`
+	// Models are the model names the requests are mirrored to
+	Models  datatypes.JSONSlice[string] `json:"models"`
+	Percent float64                     `json:"percent"`
+}
+
+type ShadowCreate struct {
+	Model string `json:"model"`
+	// Models can also be given as one comma separated list, as sent by the form of the web UI
+	Models  []string `json:"models"`
+	Percent float64  `json:"percent"`
+}
@@ -0,0 +1,60 @@
+package models
+
+const (
+	SplitArmControl   = "control"
+	SplitArmCandidate = "candidate"
+)
+
+// TrafficSplit sends a percent of the proxied requests of a model name to a candidate model, the rest go to the
+// requested model. Users, or conversations without a user, keep the arm they were assigned to.
+type TrafficSplit struct {
+	BaseModel
+	// Model is the requested model name, like an alias or a provider/model name
+	Model string `gorm:"uniqueIndex" json:"model"`
+	// Candidate is the model name that gets the percent of the requests
+	Candidate string  `json:"candidate"`
+	Percent   float64 `json:"percent"`
+	// Arms compares the logged responses of the arms, when they are loaded
+	Arms []SplitArmStats `gorm:"-" json:"arms,omitempty"`
+}
+
+type TrafficSplitCreate struct {
+	Model     string  `json:"model"`
+	Candidate string  `json:"candidate"`
+	Percent   float64 `json:"percent"`
+}
+
+// SplitArmStats are the logged responses of the conversations that were assigned to an arm of a traffic split.
+type SplitArmStats struct {
+	Arm           string  `json:"arm"`
+	Model         string  `json:"model"`
+	Conversations int     `json:"conversations"`
+	Responses     int     `json:"responses"`
+	AvgLatencyMs  float64 `json:"avg_latency_ms"`
+	Cost          float64 `json:"cost"`
+	// AvgScore is the average of the responses that were scored, and Scored is their count
+	AvgScore float64 `json:"avg_score"`
+	Scored   int     `json:"scored"`
+}
+
+// ScorePercent returns the average score as a percentage.
+func (s SplitArmStats) ScorePercent() float64 {
+	return s.AvgScore * 100
+}
+
+// AvgCost returns the cost per response.
+func (s SplitArmStats) AvgCost() float64 {
+	if s.Responses == 0 {
+		return 0
+	}
+	return s.Cost / float64(s.Responses)
+}
+
+// MessageScore is a score of a logged response sent after the fact, like the feedback of its user.
+type MessageScore struct {
+	// Score is between 0 and 1, like the scores of the scorers
+	Score float64 `json:"score"`
+	// Scorer names the source of the score, it defaults to feedback
+	Scorer         string `json:"scorer"`
+	ScoreRationale string `json:"score_rationale"`
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+const (
+	TestRunStatusRunning   = "running"
+	TestRunStatusCompleted = "completed"
+	// TestRunStatusPartial is a completed run where some of the calls failed
+	TestRunStatusPartial   = "partial"
+	TestRunStatusFailed    = "failed"
+	TestRunStatusCancelled = "cancelled"
+)
+
+type TestRun struct {
+	BaseModel
+	ConversationID      string `json:"conversation_id"`
+	ConversationVersion int    `json:"conversation_version"`
+	// TestMessageID is set when only a single message was tested
+	TestMessageID string     `json:"test_message_id"`
+	StartedAt     time.Time  `json:"started_at"`
+	EndedAt       *time.Time `json:"ended_at"`
+	RunCount      int        `json:"run_count"`
+	TestMode      string     `json:"test_mode"`
+	Status        string     `json:"status"`
+	// Models holds the tested models along with their average score for this run
+	Models      datatypes.JSONSlice[TestModels]   `json:"models"`
+	Scorer      string                            `json:"scorer"`
+	Score       float64                           `json:"score"`
+	Cost        float64                           `json:"cost"`
+	ResultCount int                               `json:"result_count"`
+	ErrorCount  int                               `json:"error_count"`
+	Errors      datatypes.JSONSlice[TestRunError] `json:"errors"`
+	// PromptScores are set when the test was run with prompt versions
+	PromptScores datatypes.JSONSlice[PromptScore] `json:"prompt_scores"`
+	Messages     []*Message                       `json:"messages,omitempty"`
+}
+
+// PromptScore holds the scores of the tested models for a single prompt version.
+type PromptScore struct {
+	PromptID string       `json:"prompt_id"`
+	Label    string       `json:"label"`
+	Score    float64      `json:"score"`
+	Models   []TestModels `json:"models"`
+}
+
+// TestRunError is a provider call that failed during a run.
+type TestRunError struct {
+	ProviderID    string `json:"provider_id"`
+	LLMID         string `json:"llm_id"`
+	TestModelID   string `json:"test_model_id,omitempty"`
+	PromptID      string `json:"prompt_id,omitempty"`
+	TestMessageID string `json:"test_message_id"`
+	StatusCode    int    `json:"status_code"`
+	Message       string `json:"message"`
+	RetryCount    int    `json:"retry_count"`
+}
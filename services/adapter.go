@@ -0,0 +1,287 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+// ProviderTypeOpenAI is the provider type of OpenAI compatible APIs
+const ProviderTypeOpenAI = "llm"
+
+// llmProviderTypes are the provider types that have an adapter.
+var llmProviderTypes = []string{ProviderTypeOpenAI, ProviderTypeAnthropic, ProviderTypeGemini, ProviderTypeOllama}
+
+// ChatCompletionStream is a streamed chat completion, in the OpenAI format whatever the provider.
+type ChatCompletionStream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close()
+}
+
+// providerAdapter speaks the API of a provider. The rest of the service only uses the OpenAI types,
+// so adapters for other protocols translate the requests and responses.
+type providerAdapter interface {
+	CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (ChatCompletionStream, error)
+	CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequest) (openai.EmbeddingResponse, error)
+	// ListModels returns the IDs of the models of the provider
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+func newProviderAdapter(provider *models.Provider, apiKey string) providerAdapter {
+	switch provider.Type {
+	case ProviderTypeAnthropic:
+		return newAnthropicClient(apiKey, provider.BaseUrl)
+	case ProviderTypeGemini:
+		return newGeminiClient(apiKey, provider.BaseUrl)
+	case ProviderTypeOllama:
+		return newOllamaClient(apiKey, provider.BaseUrl)
+	default:
+		config := openai.DefaultConfig(apiKey)
+		if provider.BaseUrl != "" {
+			config.BaseURL = provider.BaseUrl
+		}
+		config.HTTPClient = &http.Client{Transport: &temperatureTransport{base: &usageTransport{base: http.DefaultTransport}}}
+		return &openaiAdapter{client: openai.NewClientWithConfig(config)}
+	}
+}
+
+// zeroTemperatureKey marks the requests made with a context as asking for a zero temperature.
+type zeroTemperatureKey struct{}
+
+// withTemperature has the adapters send the temperature of the requests made with the context. Only a zero
+// temperature needs it, the openai request leaves it out and the providers would use their default instead.
+func withTemperature(ctx context.Context, temperature *float32) context.Context {
+	if temperature == nil || *temperature != 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, zeroTemperatureKey{}, true)
+}
+
+// chatTemperature returns the temperature to send with the request, nil when it is left out.
+func chatTemperature(ctx context.Context, req openai.ChatCompletionRequest) *float32 {
+	if req.Temperature != 0 {
+		return &req.Temperature
+	}
+	if zero, _ := ctx.Value(zeroTemperatureKey{}).(bool); zero {
+		return ptr(float32(0))
+	}
+	return nil
+}
+
+// temperatureTransport adds the zero temperature of the context to the chat requests, the openai client
+// leaves it out.
+type temperatureTransport struct {
+	base http.RoundTripper
+}
+
+func (t *temperatureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if zero, _ := req.Context().Value(zeroTemperatureKey{}).(bool); !zero || req.Body == nil {
+		return t.base.RoundTrip(req)
+	}
+	req, err := withBodyFields(req, func(fields map[string]json.RawMessage) {
+		// The embeddings of the context don't have a temperature
+		if _, ok := fields["messages"]; ok {
+			fields["temperature"] = json.RawMessage("0")
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// withBodyFields returns a copy of the request with the fields of its JSON body changed. A body that isn't a JSON
+// object is sent as it is.
+func withBodyFields(req *http.Request, change func(fields map[string]json.RawMessage)) (*http.Request, error) {
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if json.Unmarshal(body, &fields) == nil {
+		change(fields)
+		if changed, err := json.Marshal(fields); err == nil {
+			body = changed
+		}
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	return req, nil
+}
+
+// openaiAdapter passes the requests straight to an OpenAI compatible API.
+type openaiAdapter struct {
+	client *openai.Client
+}
+
+func (a *openaiAdapter) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return a.client.CreateChatCompletion(ctx, req)
+}
+
+func (a *openaiAdapter) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (ChatCompletionStream, error) {
+	ctx, record := withStreamUsage(ctx)
+	stream, err := a.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &openaiStream{ChatCompletionStream: stream, record: record}, nil
+}
+
+func (a *openaiAdapter) CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequest) (openai.EmbeddingResponse, error) {
+	return a.client.CreateEmbeddings(ctx, req)
+}
+
+func (a *openaiAdapter) ListModels(ctx context.Context) ([]string, error) {
+	list, err := a.client.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(list.Models))
+	for i, model := range list.Models {
+		ids[i] = model.ID
+	}
+	return ids, nil
+}
+
+// sendRequest sends the request to the provider. A failed status is returned as an openai error,
+// so the status code is handled like the other providers.
+func sendRequest(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	apiErr := &openai.APIError{HTTPStatusCode: resp.StatusCode, Message: resp.Status}
+	errorBody := struct {
+		Error json.RawMessage `json:"error"`
+	}{}
+	if json.NewDecoder(resp.Body).Decode(&errorBody) != nil || len(errorBody.Error) == 0 {
+		return nil, apiErr
+	}
+	// The error is either a message, or an object with the message and its type
+	message := ""
+	if json.Unmarshal(errorBody.Error, &message) == nil && message != "" {
+		apiErr.Message = message
+		return nil, apiErr
+	}
+	errorObject := struct {
+		Type    string `json:"type"`
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}{}
+	if json.Unmarshal(errorBody.Error, &errorObject) == nil && errorObject.Message != "" {
+		apiErr.Message = errorObject.Message
+		apiErr.Type = errorObject.Type
+		if apiErr.Type == "" {
+			apiErr.Type = errorObject.Status
+		}
+	}
+	return nil, apiErr
+}
+
+// newJSONRequest makes a request with the body encoded as JSON.
+func newJSONRequest(ctx context.Context, method, url string, body any) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// readEventData reads the data of the next server sent event.
+func readEventData(reader *bufio.Reader) ([]byte, error) {
+	data := []byte{}
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF && len(data) > 0 {
+				return data, nil
+			}
+			return nil, err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			if len(data) > 0 {
+				return data, nil
+			}
+			continue
+		}
+		if value, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			data = append(data, bytes.TrimSpace(value)...)
+		}
+	}
+}
+
+// embeddingInputs reads the input of an embedding request, which is a string or a list of strings.
+func embeddingInputs(input any) ([]string, error) {
+	switch input := input.(type) {
+	case string:
+		return []string{input}, nil
+	case []string:
+		return input, nil
+	case []any:
+		inputs := make([]string, len(input))
+		for i, value := range input {
+			text, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("embedding input must be text")
+			}
+			inputs[i] = text
+		}
+		return inputs, nil
+	default:
+		return nil, fmt.Errorf("embedding input must be text")
+	}
+}
+
+// readToolChoice reads the tool_choice of a request, which is "auto", "required", "none",
+// or an object naming a function. The mode is "function" when a function is named.
+func readToolChoice(toolChoice any) (mode, function string) {
+	switch choice := toolChoice.(type) {
+	case string:
+		return choice, ""
+	case openai.ToolChoice:
+		return "function", choice.Function.Name
+	case *openai.ToolChoice:
+		return "function", choice.Function.Name
+	case map[string]any:
+		if function, ok := choice["function"].(map[string]any); ok {
+			name, _ := function["name"].(string)
+			return "function", name
+		}
+	}
+	return "", ""
+}
+
+// splitDataURL returns the media type and the base64 data of a data URL.
+func splitDataURL(url string) (mediaType, data string, ok bool) {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, ";base64,")
+}
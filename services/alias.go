@@ -0,0 +1,118 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+// resolveProxyTarget finds the provider and the model of a model name of the proxy, which is an alias,
+// a provider/model name or a saved model. Names aren't looked up as aliases when the headers name the provider.
+func (s *Service) resolveProxyTarget(name string, options ProxyOptions) proxyTarget {
+	target := proxyTarget{Name: name}
+	if options.ProviderID == "" {
+		alias := &models.Alias{}
+		tx := s.Db.Where("name = ?", name).Limit(1).Find(alias)
+		if tx.Error != nil {
+			target.Err = tx.Error
+			return target
+		}
+		if tx.RowsAffected > 0 {
+			target.ModelID = alias.Model
+			target.ProviderID = alias.ProviderID
+			target.Alias = alias.Name
+			target.Params = alias.Params
+			return target
+		}
+	}
+	target.ModelID, target.ProviderID, target.Err = s.resolveProxyModel(name, options)
+	return target
+}
+
+// clientTemperature returns the temperature the client asked for, nil when the request leaves it out.
+func clientTemperature(req openai.ChatCompletionRequest, options ProxyOptions) *float32 {
+	if options.Temperature != nil {
+		return options.Temperature
+	}
+	if req.Temperature != 0 {
+		return &req.Temperature
+	}
+	return nil
+}
+
+// requestTemperature returns the temperature the request is answered with, the one of the client or else the
+// default of the alias. It is nil when neither sets one, the provider then uses its own default.
+func requestTemperature(temperature *float32, params models.GenerationParams) *float32 {
+	if temperature != nil {
+		return temperature
+	}
+	return params.Temperature
+}
+
+// withDefaultParams sets the parameters of the alias that the request doesn't set. The temperature is the one of
+// the client, a zero temperature is sent by the context of the request with withTemperature.
+func withDefaultParams(req openai.ChatCompletionRequest, temperature *float32, params models.GenerationParams) openai.ChatCompletionRequest {
+	if temperature := requestTemperature(temperature, params); temperature != nil {
+		req.Temperature = *temperature
+	}
+	if req.TopP == 0 && params.TopP != nil {
+		req.TopP = *params.TopP
+	}
+	if req.MaxTokens == 0 {
+		req.MaxTokens = params.MaxTokens
+	}
+	if req.Seed == nil {
+		req.Seed = params.Seed
+	}
+	if len(req.Stop) == 0 {
+		req.Stop = params.Stop
+	}
+	return req
+}
+
+func (s *Service) GetAliases() ([]*models.Alias, error) {
+	aliases := []*models.Alias{}
+	tx := s.Db.Order("name ASC").Find(&aliases)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return aliases, nil
+}
+
+// SetAlias creates the alias, or points it to another model when it exists.
+func (s *Service) SetAlias(input models.AliasCreate) (*models.Alias, error) {
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return nil, errors.New("the name of the alias is required")
+	}
+	if input.Model == "" {
+		return nil, errors.New("the model of the alias is required")
+	}
+	if _, ok := s.llmProviders[input.ProviderID]; !ok {
+		return nil, fmt.Errorf("provider not found: %s", input.ProviderID)
+	}
+
+	alias := &models.Alias{}
+	tx := s.Db.Where("name = ?", name).Limit(1).Find(alias)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	alias.Name = name
+	alias.ProviderID = input.ProviderID
+	alias.Model = input.Model
+	alias.Params = input.Params
+	tx = s.Db.Save(alias)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return alias, nil
+}
+
+func (s *Service) DeleteAlias(id string) error {
+	// The name of a deleted alias can be given to a new one
+	tx := s.Db.Unscoped().Where("id = ?", id).Delete(&models.Alias{})
+	return tx.Error
+}
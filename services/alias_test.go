@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+// newRecordingProxyService has a fake provider that keeps the requests it gets.
+func newRecordingProxyService(t *testing.T) (*Service, *httptest.Server, *[]openai.ChatCompletionRequest) {
+	requests := []openai.ChatCompletionRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := openai.ChatCompletionRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: "assistant", Content: "Reply"}}},
+		})
+	}))
+	s := New(":memory:", "../.env")
+	provider := &models.Provider{BaseModel: models.BaseModel{ID: "fake"}, BaseUrl: server.URL, Requests: 100}
+	s.Db.Create(provider)
+	s.llmProviders["fake"] = newLLMProvider(provider, "key")
+	return s, server, &requests
+}
+
+func TestProxyAlias(t *testing.T) {
+	s, server, requests := newRecordingProxyService(t)
+	defer server.Close()
+
+	_, err := s.SetAlias(models.AliasCreate{ProviderID: "fake", Model: "model"})
+	assert.Error(t, err)
+	_, err = s.SetAlias(models.AliasCreate{Name: "chat-default", ProviderID: "unknown", Model: "model"})
+	assert.ErrorContains(t, err, "provider not found")
+
+	temperature := float32(0.2)
+	_, err = s.SetAlias(models.AliasCreate{Name: "chat-default", ProviderID: "fake", Model: "model", Params: models.GenerationParams{Temperature: &temperature, MaxTokens: 50}})
+	assert.Nil(t, err)
+
+	messages := []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}
+	_, route, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "chat-default", Messages: messages}, ProxyOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "model", (*requests)[0].Model)
+	assert.Equal(t, float32(0.2), (*requests)[0].Temperature)
+	assert.Equal(t, 50, (*requests)[0].MaxTokens)
+	assert.Equal(t, "chat-default", route.Alias)
+
+	message := &models.Message{Role: "assistant", Content: "Reply", Metadata: &models.MessageMetadata{}}
+	assert.Nil(t, s.AddProxyResponse(route, message))
+	assert.Equal(t, "model", message.LLMID)
+	assert.Equal(t, "fake", message.ProviderID)
+	assert.Equal(t, "chat-default", message.Metadata.Alias)
+
+	// The parameters of the request win over the defaults of the alias
+	_, _, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "chat-default", MaxTokens: 10, Messages: messages}, ProxyOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 10, (*requests)[1].MaxTokens)
+
+	// Switching the model behind the alias
+	alias, err := s.SetAlias(models.AliasCreate{Name: "chat-default", ProviderID: "fake", Model: "cheaper-model"})
+	assert.Nil(t, err)
+	_, _, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "chat-default", Messages: messages}, ProxyOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "cheaper-model", (*requests)[2].Model)
+	assert.Equal(t, float32(0), (*requests)[2].Temperature)
+
+	aliases, err := s.GetAliases()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(aliases))
+
+	assert.Nil(t, s.DeleteAlias(alias.ID))
+	_, _, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "chat-default", Messages: messages}, ProxyOptions{})
+	assert.ErrorContains(t, err, "model not found")
+}
+
+func TestProxyZeroTemperature(t *testing.T) {
+	temperatures := []json.RawMessage{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fields := map[string]json.RawMessage{}
+		json.Unmarshal(body, &fields)
+		temperatures = append(temperatures, fields["temperature"])
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: "assistant", Content: "Reply"}}},
+		})
+	}))
+	defer server.Close()
+	s := New(":memory:", "../.env")
+	provider := &models.Provider{BaseModel: models.BaseModel{ID: "fake"}, BaseUrl: server.URL, Requests: 100}
+	s.Db.Create(provider)
+	s.llmProviders["fake"] = newLLMProvider(provider, "key")
+
+	sampled := float32(0.2)
+	_, err := s.SetAlias(models.AliasCreate{Name: "sampled", ProviderID: "fake", Model: "model", Params: models.GenerationParams{Temperature: &sampled}})
+	assert.Nil(t, err)
+	_, err = s.SetAlias(models.AliasCreate{Name: "greedy", ProviderID: "fake", Model: "model", Params: models.GenerationParams{Temperature: ptr(float32(0))}})
+	assert.Nil(t, err)
+
+	messages := []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}
+	send := func(model string, options ProxyOptions) {
+		_, _, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: model, Messages: messages}, options)
+		assert.Nil(t, err)
+	}
+	send("sampled", ProxyOptions{Temperature: ptr(float32(0))})
+	send("greedy", ProxyOptions{})
+	send("fake/model", ProxyOptions{})
+	assert.Equal(t, json.RawMessage("0"), temperatures[0], "Expect the zero temperature of the client to win over the alias")
+	assert.Equal(t, json.RawMessage("0"), temperatures[1], "Expect the zero temperature of the alias to be sent")
+	assert.Nil(t, temperatures[2], "Expect a missing temperature to stay missing")
+}
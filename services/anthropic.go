@@ -0,0 +1,441 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// ProviderTypeAnthropic is the provider type of the Anthropic Messages API
+	ProviderTypeAnthropic = "anthropic"
+	anthropicVersion      = "2023-06-01"
+	// anthropicMaxTokens is used when the request doesn't set max_tokens, which the Messages API requires
+	anthropicMaxTokens = 4096
+)
+
+// anthropicClient translates OpenAI chat completion requests to and from the Anthropic Messages API.
+type anthropicClient struct {
+	apiKey     string
+	baseURL    string
+	httpClient *http.Client
+}
+
+func newAnthropicClient(apiKey, baseURL string) *anthropicClient {
+	if baseURL == "" {
+		baseURL = "https://api.anthropic.com/v1"
+	}
+	return &anthropicClient{apiKey: apiKey, baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: &http.Client{}}
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float32           `json:"temperature,omitempty"`
+	TopP          *float32           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Tools         []anthropicTool    `json:"tools,omitempty"`
+	ToolChoice    map[string]any     `json:"tool_choice,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []anthropicContentBlock `json:"content"`
+}
+
+type anthropicContentBlock struct {
+	Type string `json:"type"`
+	// text
+	Text string `json:"text,omitempty"`
+	// image
+	Source *anthropicImageSource `json:"source,omitempty"`
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+	// tool_result
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type anthropicResponse struct {
+	ID         string                  `json:"id"`
+	Model      string                  `json:"model"`
+	Content    []anthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      anthropicUsage          `json:"usage"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// newAnthropicRequest moves the system messages to the system prompt, turns tool calls and results into content blocks
+// and merges consecutive messages of the same role, since the Messages API requires the roles to alternate.
+func newAnthropicRequest(req openai.ChatCompletionRequest) (anthropicRequest, error) {
+	request := anthropicRequest{
+		Model:         req.Model,
+		MaxTokens:     req.MaxTokens,
+		StopSequences: req.Stop,
+		Stream:        req.Stream,
+	}
+	if request.MaxTokens == 0 {
+		request.MaxTokens = anthropicMaxTokens
+	}
+	// Left out when zero, like the openai client does
+	if req.Temperature != 0 {
+		request.Temperature = ptr(req.Temperature)
+	}
+	if req.TopP != 0 {
+		request.TopP = ptr(req.TopP)
+	}
+
+	system := []string{}
+	for _, message := range req.Messages {
+		role := message.Role
+		blocks := []anthropicContentBlock{}
+		switch message.Role {
+		case openai.ChatMessageRoleSystem:
+			system = append(system, message.Content)
+			continue
+		case openai.ChatMessageRoleUser:
+			blocks = anthropicTextBlocks(message)
+		case openai.ChatMessageRoleAssistant:
+			blocks = anthropicTextBlocks(message)
+			for _, toolCall := range message.ToolCalls {
+				input := json.RawMessage(toolCall.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicContentBlock{Type: "tool_use", ID: toolCall.ID, Name: toolCall.Function.Name, Input: input})
+			}
+		case openai.ChatMessageRoleTool:
+			role = openai.ChatMessageRoleUser
+			blocks = append(blocks, anthropicContentBlock{Type: "tool_result", ToolUseID: message.ToolCallID, Content: message.Content})
+		default:
+			return request, fmt.Errorf("unsupported role for anthropic: %s", message.Role)
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+
+		last := len(request.Messages) - 1
+		if last >= 0 && request.Messages[last].Role == role {
+			request.Messages[last].Content = append(request.Messages[last].Content, blocks...)
+			continue
+		}
+		request.Messages = append(request.Messages, anthropicMessage{Role: role, Content: blocks})
+	}
+	request.System = strings.Join(system, "\n\n")
+
+	for _, tool := range req.Tools {
+		schema := tool.Function.Parameters
+		if schema == nil {
+			schema = map[string]any{"type": "object"}
+		}
+		request.Tools = append(request.Tools, anthropicTool{Name: tool.Function.Name, Description: tool.Function.Description, InputSchema: schema})
+	}
+	request.ToolChoice = newAnthropicToolChoice(req.ToolChoice)
+	return request, nil
+}
+
+func anthropicTextBlocks(message openai.ChatCompletionMessage) []anthropicContentBlock {
+	if len(message.MultiContent) == 0 {
+		if message.Content == "" {
+			return []anthropicContentBlock{}
+		}
+		return []anthropicContentBlock{{Type: "text", Text: message.Content}}
+	}
+	blocks := []anthropicContentBlock{}
+	for _, part := range message.MultiContent {
+		switch {
+		case part.Type == openai.ChatMessagePartTypeText:
+			blocks = append(blocks, anthropicContentBlock{Type: "text", Text: part.Text})
+		case part.ImageURL != nil:
+			blocks = append(blocks, anthropicContentBlock{Type: "image", Source: newAnthropicImageSource(part.ImageURL.URL)})
+		}
+	}
+	return blocks
+}
+
+// newAnthropicImageSource reads data URLs as base64 images and passes other URLs on.
+func newAnthropicImageSource(url string) *anthropicImageSource {
+	if mediaType, data, ok := splitDataURL(url); ok {
+		return &anthropicImageSource{Type: "base64", MediaType: mediaType, Data: data}
+	}
+	return &anthropicImageSource{Type: "url", URL: url}
+}
+
+func newAnthropicToolChoice(toolChoice any) map[string]any {
+	mode, function := readToolChoice(toolChoice)
+	switch mode {
+	case "auto", "none":
+		return map[string]any{"type": mode}
+	case "required":
+		return map[string]any{"type": "any"}
+	case "function":
+		return map[string]any{"type": "tool", "name": function}
+	}
+	return nil
+}
+
+func anthropicFinishReason(stopReason string) openai.FinishReason {
+	switch stopReason {
+	case "end_turn", "stop_sequence":
+		return openai.FinishReasonStop
+	case "max_tokens":
+		return openai.FinishReasonLength
+	case "tool_use":
+		return openai.FinishReasonToolCalls
+	default:
+		return openai.FinishReasonNull
+	}
+}
+
+func newAnthropicUsage(usage anthropicUsage) openai.Usage {
+	return openai.Usage{
+		PromptTokens:     usage.InputTokens,
+		CompletionTokens: usage.OutputTokens,
+		TotalTokens:      usage.InputTokens + usage.OutputTokens,
+	}
+}
+
+func (c *anthropicClient) send(ctx context.Context, req openai.ChatCompletionRequest) (*http.Response, error) {
+	request, err := newAnthropicRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	request.Temperature = chatTemperature(ctx, req)
+	return c.do(ctx, http.MethodPost, "/messages", request)
+}
+
+func (c *anthropicClient) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	httpReq, err := newJSONRequest(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("x-api-key", c.apiKey)
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+	return sendRequest(c.httpClient, httpReq)
+}
+
+// CreateEmbeddings fails, the Anthropic API has no embeddings.
+func (c *anthropicClient) CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequest) (openai.EmbeddingResponse, error) {
+	return openai.EmbeddingResponse{}, fmt.Errorf("anthropic providers don't support embeddings")
+}
+
+// ListModels returns the IDs of the models, going through every page of the list.
+func (c *anthropicClient) ListModels(ctx context.Context) ([]string, error) {
+	ids := []string{}
+	afterID := ""
+	for {
+		path := "/models?limit=1000"
+		if afterID != "" {
+			path += "&after_id=" + afterID
+		}
+		resp, err := c.do(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		page := struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+			HasMore bool   `json:"has_more"`
+			LastID  string `json:"last_id"`
+		}{}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode anthropic models: %w", err)
+		}
+		for _, model := range page.Data {
+			ids = append(ids, model.ID)
+		}
+		if !page.HasMore || page.LastID == "" {
+			return ids, nil
+		}
+		afterID = page.LastID
+	}
+}
+
+func (c *anthropicClient) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	req.Stream = false
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	anthropicResp := anthropicResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&anthropicResp); err != nil {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("failed to decode anthropic response: %w", err)
+	}
+
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}
+	for _, block := range anthropicResp.Content {
+		switch block.Type {
+		case "text":
+			message.Content += block.Text
+		case "tool_use":
+			message.ToolCalls = append(message.ToolCalls, openai.ToolCall{
+				ID:       block.ID,
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: block.Name, Arguments: string(block.Input)},
+			})
+		}
+	}
+	return openai.ChatCompletionResponse{
+		ID:      anthropicResp.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   anthropicResp.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      message,
+			FinishReason: anthropicFinishReason(anthropicResp.StopReason),
+		}},
+		Usage: newAnthropicUsage(anthropicResp.Usage),
+	}, nil
+}
+
+func (c *anthropicClient) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (ChatCompletionStream, error) {
+	req.Stream = true
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &anthropicStream{body: resp.Body, reader: bufio.NewReader(resp.Body), created: time.Now().Unix(), toolIndex: map[int]int{}}, nil
+}
+
+// anthropicStream turns the server sent events of the Messages API into OpenAI chat completion chunks.
+type anthropicStream struct {
+	body    io.ReadCloser
+	reader  *bufio.Reader
+	id      string
+	model   string
+	created int64
+	usage   anthropicUsage
+	// toolIndex maps the content block index to the index of the tool call
+	toolIndex map[int]int
+}
+
+type anthropicStreamEvent struct {
+	Type         string                `json:"type"`
+	Index        int                   `json:"index"`
+	Message      anthropicResponse     `json:"message"`
+	ContentBlock anthropicContentBlock `json:"content_block"`
+	Delta        anthropicStreamDelta  `json:"delta"`
+	Usage        anthropicUsage        `json:"usage"`
+	Error        anthropicError        `json:"error"`
+}
+
+type anthropicStreamDelta struct {
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	PartialJSON string `json:"partial_json"`
+	StopReason  string `json:"stop_reason"`
+}
+
+func (s *anthropicStream) Close() {
+	s.body.Close()
+}
+
+// Usage returns the tokens used so far, the output tokens are only known at the end of the stream.
+func (s *anthropicStream) Usage() openai.Usage {
+	return newAnthropicUsage(s.usage)
+}
+
+// Recv returns the next chunk, or io.EOF once the message is done.
+func (s *anthropicStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	for {
+		data, err := readEventData(s.reader)
+		if err != nil {
+			return openai.ChatCompletionStreamResponse{}, err
+		}
+		event := anthropicStreamEvent{}
+		if err := json.Unmarshal(data, &event); err != nil {
+			return openai.ChatCompletionStreamResponse{}, fmt.Errorf("failed to decode anthropic event: %w", err)
+		}
+
+		delta := openai.ChatCompletionStreamChoiceDelta{}
+		finishReason := openai.FinishReason("")
+		switch event.Type {
+		case "message_start":
+			s.id = event.Message.ID
+			s.model = event.Message.Model
+			s.usage.InputTokens = event.Message.Usage.InputTokens
+			delta.Role = openai.ChatMessageRoleAssistant
+		case "content_block_start":
+			if event.ContentBlock.Type != "tool_use" {
+				continue
+			}
+			index := len(s.toolIndex)
+			s.toolIndex[event.Index] = index
+			delta.ToolCalls = []openai.ToolCall{{
+				Index:    ptr(index),
+				ID:       event.ContentBlock.ID,
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: event.ContentBlock.Name},
+			}}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				delta.Content = event.Delta.Text
+			case "input_json_delta":
+				delta.ToolCalls = []openai.ToolCall{{
+					Index:    ptr(s.toolIndex[event.Index]),
+					Function: openai.FunctionCall{Arguments: event.Delta.PartialJSON},
+				}}
+			default:
+				continue
+			}
+		case "message_delta":
+			s.usage.OutputTokens = event.Usage.OutputTokens
+			finishReason = anthropicFinishReason(event.Delta.StopReason)
+		case "message_stop":
+			return openai.ChatCompletionStreamResponse{}, io.EOF
+		case "error":
+			return openai.ChatCompletionStreamResponse{}, &openai.APIError{Type: event.Error.Type, Message: event.Error.Message}
+		default:
+			// ping and content_block_stop
+			continue
+		}
+
+		return openai.ChatCompletionStreamResponse{
+			ID:      s.id,
+			Object:  "chat.completion.chunk",
+			Created: s.created,
+			Model:   s.model,
+			Choices: []openai.ChatCompletionStreamChoice{{Delta: delta, FinishReason: finishReason}},
+		}, nil
+	}
+}
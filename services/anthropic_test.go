@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestNewAnthropicRequest(t *testing.T) {
+	request, err := newAnthropicRequest(openai.ChatCompletionRequest{
+		Model: "claude",
+		Messages: []openai.ChatCompletionMessage{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "What's the weather in Paris?"},
+			{Role: "assistant", ToolCalls: []openai.ToolCall{{ID: "call_1", Type: "function", Function: openai.FunctionCall{Name: "weather", Arguments: `{"city":"Paris"}`}}}},
+			{Role: "tool", ToolCallID: "call_1", Content: "Sunny"},
+			{Role: "user", Content: "Thanks"},
+		},
+		Tools:      []openai.Tool{{Type: "function", Function: openai.FunctionDefinition{Name: "weather", Parameters: map[string]any{"type": "object"}}}},
+		ToolChoice: "required",
+		Stop:       []string{"END"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "Be brief.", request.System)
+	assert.Equal(t, anthropicMaxTokens, request.MaxTokens)
+	assert.Equal(t, []string{"END"}, request.StopSequences)
+	assert.Equal(t, map[string]any{"type": "any"}, request.ToolChoice)
+	assert.Equal(t, "weather", request.Tools[0].Name)
+
+	assert.Equal(t, 3, len(request.Messages), "Expect the tool result and the next user message to be merged")
+	assert.Equal(t, "tool_use", request.Messages[1].Content[0].Type)
+	assert.JSONEq(t, `{"city":"Paris"}`, string(request.Messages[1].Content[0].Input))
+	assert.Equal(t, "user", request.Messages[2].Role)
+	assert.Equal(t, "tool_result", request.Messages[2].Content[0].Type)
+	assert.Equal(t, "call_1", request.Messages[2].Content[0].ToolUseID)
+	assert.Equal(t, "Thanks", request.Messages[2].Content[1].Text)
+
+	_, err = newAnthropicRequest(openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: "function", Content: "x"}}})
+	assert.Error(t, err)
+}
+
+func newFakeAnthropicServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "key" || r.Header.Get("anthropic-version") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`)
+			return
+		}
+		if r.URL.Path == "/models" {
+			fmt.Fprint(w, `{"data":[{"id":"claude-a"},{"id":"claude-b"}],"has_more":false}`)
+			return
+		}
+		request := anthropicRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Model == "overloaded" {
+			w.WriteHeader(529)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+			return
+		}
+		if !request.Stream {
+			fmt.Fprint(w, `{"id":"msg_1","model":"claude-a","content":[{"type":"text","text":"Let me check."},{"type":"tool_use","id":"toolu_1","name":"weather","input":{"city":"Paris"}}],"stop_reason":"tool_use","usage":{"input_tokens":12,"output_tokens":7}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`{"type":"message_start","message":{"id":"msg_2","model":"claude-a","usage":{"input_tokens":12}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"ping"}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"lo"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_2","name":"weather"}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}`,
+			`{"type":"message_stop"}`,
+		}
+		for _, event := range events {
+			fmt.Fprintf(w, "event: x\ndata: %s\n\n", event)
+		}
+	}))
+}
+
+func TestAnthropicClient(t *testing.T) {
+	server := newFakeAnthropicServer(t)
+	defer server.Close()
+	client := newAnthropicClient("key", server.URL)
+
+	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:    "claude-a",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Weather?"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "Let me check.", resp.Choices[0].Message.Content)
+	assert.Equal(t, openai.FinishReasonToolCalls, resp.Choices[0].FinishReason)
+	assert.Equal(t, "weather", resp.Choices[0].Message.ToolCalls[0].Function.Name)
+	assert.JSONEq(t, `{"city":"Paris"}`, resp.Choices[0].Message.ToolCalls[0].Function.Arguments)
+	assert.Equal(t, openai.Usage{PromptTokens: 12, CompletionTokens: 7, TotalTokens: 19}, resp.Usage)
+
+	stream, err := client.CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{
+		Model:    "claude-a",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}},
+	})
+	assert.Nil(t, err)
+	defer stream.Close()
+	content := ""
+	arguments := ""
+	finishReason := openai.FinishReason("")
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, "msg_2", chunk.ID)
+		content += chunk.Choices[0].Delta.Content
+		for _, toolCall := range chunk.Choices[0].Delta.ToolCalls {
+			assert.Equal(t, 0, *toolCall.Index)
+			arguments += toolCall.Function.Arguments
+		}
+		if chunk.Choices[0].FinishReason != "" {
+			finishReason = chunk.Choices[0].FinishReason
+		}
+	}
+	assert.Equal(t, "Hello", content)
+	assert.Equal(t, `{"city":"Paris"}`, arguments)
+	assert.Equal(t, openai.FinishReasonStop, finishReason)
+	assert.Equal(t, 5, stream.(*anthropicStream).Usage().CompletionTokens)
+
+	_, err = client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: "overloaded"})
+	assert.Equal(t, 529, errorStatusCode(err))
+	assert.True(t, isRetryableError(err))
+
+	ids, err := client.ListModels(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"claude-a", "claude-b"}, ids)
+
+	_, err = newAnthropicClient("wrong", server.URL).CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{})
+	assert.ErrorContains(t, err, "invalid x-api-key")
+}
+
+func TestProxyAnthropic(t *testing.T) {
+	server := newFakeAnthropicServer(t)
+	defer server.Close()
+
+	s := New(":memory:", "../.env")
+	provider := &models.Provider{BaseModel: models.BaseModel{ID: "anthropic"}, Type: ProviderTypeAnthropic, BaseUrl: server.URL, Requests: 100}
+	s.llmProviders["anthropic"] = newLLMProvider(provider, "key")
+
+	resp, route, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{
+		Model:    "claude-a",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Weather?"}},
+	}, ProxyOptions{ProviderID: "anthropic"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Let me check.", resp.Choices[0].Message.Content)
+	assert.NotEmpty(t, route.Conversation.ID)
+
+	_, _, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "claude-a"}, ProxyOptions{ProviderID: "unknown"})
+	assert.ErrorContains(t, err, "provider not found")
+}
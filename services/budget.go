@@ -0,0 +1,279 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/y2a-labs/evaluate/models"
+	"gorm.io/gorm"
+)
+
+// budgetExceededCode is the code of the error returned when a budget is spent, like the errors of the OpenAI API.
+const budgetExceededCode = "budget_exceeded"
+
+// newBudgetError is the 429 error of a request that would go over the budget.
+func newBudgetError(status models.BudgetStatus) error {
+	return &openai.APIError{
+		Code:           budgetExceededCode,
+		Type:           "insufficient_quota",
+		HTTPStatusCode: http.StatusTooManyRequests,
+		Message: fmt.Sprintf("the %s budget of the %s %s is exceeded: $%.4f of $%.4f spent",
+			status.Period, status.Scope, status.Name, status.Spent, status.Limit),
+	}
+}
+
+// IsBudgetError reports whether the request was refused because a budget is spent.
+func IsBudgetError(err error) bool {
+	apiErr := &openai.APIError{}
+	return errors.As(err, &apiErr) && apiErr.Code == budgetExceededCode
+}
+
+// budgetPeriodStart returns the start of the day or the month of the time.
+func budgetPeriodStart(period string, now time.Time) time.Time {
+	if period == models.BudgetPeriodMonthly {
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+// spendQuery adds up the cost of the messages, callers narrow it down to a provider or to a client.
+func (s *Service) spendQuery() *gorm.DB {
+	return s.Db.Model(&models.Message{}).
+		Select("COALESCE(SUM(message_metadata.cost), 0)").
+		Joins("JOIN message_metadata ON message_metadata.message_id = messages.id AND message_metadata.deleted_at IS NULL")
+}
+
+func (s *Service) providerSpend(providerID string, since time.Time) (float64, error) {
+	spent := 0.0
+	tx := s.spendQuery().Where("messages.provider_id = ? AND messages.created_at >= ?", providerID, since).Scan(&spent)
+	return spent, tx.Error
+}
+
+func (s *Service) clientSpend(app string, since time.Time) (float64, error) {
+	spent := 0.0
+	tx := s.spendQuery().
+		Joins("JOIN conversations ON conversations.id = messages.conversation_id").
+		Where("conversations.app = ? AND messages.created_at >= ?", app, since).
+		Scan(&spent)
+	return spent, tx.Error
+}
+
+// budgetStatuses returns the spend of the daily and monthly budgets that are set.
+func budgetStatuses(scope, name string, budget models.Budget, spend func(since time.Time) (float64, error)) ([]models.BudgetStatus, error) {
+	now := time.Now()
+	statuses := []models.BudgetStatus{}
+	for _, period := range []string{models.BudgetPeriodDaily, models.BudgetPeriodMonthly} {
+		limit := budget.DailyBudget
+		if period == models.BudgetPeriodMonthly {
+			limit = budget.MonthlyBudget
+		}
+		if limit <= 0 {
+			continue
+		}
+		spent, err := spend(budgetPeriodStart(period, now))
+		if err != nil {
+			return nil, err
+		}
+		status := models.BudgetStatus{Scope: scope, Name: name, Period: period, Spent: spent, Limit: limit}
+		status.Exceeded = spent >= limit
+		status.Warning = budget.BudgetWarning > 0 && status.Percent() >= budget.BudgetWarning
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (s *Service) providerBudgetStatuses(provider *models.Provider) ([]models.BudgetStatus, error) {
+	return budgetStatuses(models.BudgetScopeProvider, provider.ID, provider.Budget, func(since time.Time) (float64, error) {
+		return s.providerSpend(provider.ID, since)
+	})
+}
+
+func (s *Service) clientBudgetStatuses(budget *models.ClientBudget) ([]models.BudgetStatus, error) {
+	return budgetStatuses(models.BudgetScopeClient, budget.App, budget.Budget, func(since time.Time) (float64, error) {
+		return s.clientSpend(budget.App, since)
+	})
+}
+
+// checkBudget returns a 429 error when the budget of the provider, or of the calling client, is spent.
+func (s *Service) checkBudget(providerID, app string) error {
+	if err := s.checkClientBudget(app); err != nil {
+		return err
+	}
+	return s.checkProviderBudget(providerID)
+}
+
+// checkProviderBudget returns a 429 error when a budget of the provider is spent.
+func (s *Service) checkProviderBudget(providerID string) error {
+	provider := &models.Provider{}
+	tx := s.Db.Where("id = ?", providerID).Limit(1).Find(provider)
+	if tx.Error != nil || tx.RowsAffected == 0 {
+		return tx.Error
+	}
+	statuses, err := s.providerBudgetStatuses(provider)
+	if err != nil {
+		return err
+	}
+	return exceededBudgetError(statuses)
+}
+
+// checkClientBudget returns a 429 error when a budget of the client that sends the app is spent.
+func (s *Service) checkClientBudget(app string) error {
+	if app == "" {
+		return nil
+	}
+	budget := &models.ClientBudget{}
+	tx := s.Db.Where("app = ?", app).Limit(1).Find(budget)
+	if tx.Error != nil || tx.RowsAffected == 0 {
+		return tx.Error
+	}
+	statuses, err := s.clientBudgetStatuses(budget)
+	if err != nil {
+		return err
+	}
+	return exceededBudgetError(statuses)
+}
+
+// testBudget checks the budget of a provider before every call of a test run. The results of the run are saved in
+// batches, so the cost of its results so far is added to the logged spend instead of being read back.
+type testBudget struct {
+	s         *Service
+	testRunID string
+	mu        sync.Mutex
+	spent     map[string]float64
+}
+
+func (s *Service) newTestBudget(testRunID string) *testBudget {
+	return &testBudget{s: s, testRunID: testRunID, spent: map[string]float64{}}
+}
+
+// check returns a 429 error when a budget of the provider is spent.
+func (b *testBudget) check(providerID string) error {
+	provider := &models.Provider{}
+	tx := b.s.Db.Where("id = ?", providerID).Limit(1).Find(provider)
+	if tx.Error != nil || tx.RowsAffected == 0 {
+		return tx.Error
+	}
+	b.mu.Lock()
+	runSpent := b.spent[providerID]
+	b.mu.Unlock()
+	statuses, err := budgetStatuses(models.BudgetScopeProvider, provider.ID, provider.Budget, func(since time.Time) (float64, error) {
+		spent := 0.0
+		query := b.s.spendQuery().Where("messages.provider_id = ? AND messages.created_at >= ?", providerID, since)
+		if b.testRunID != "" {
+			query = query.Where("COALESCE(messages.test_run_id, '') <> ?", b.testRunID)
+		}
+		tx := query.Scan(&spent)
+		return spent + runSpent, tx.Error
+	})
+	if err != nil {
+		return err
+	}
+	return exceededBudgetError(statuses)
+}
+
+// add counts the cost of a result of the run.
+func (b *testBudget) add(providerID string, cost float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.spent[providerID] += cost
+}
+
+func exceededBudgetError(statuses []models.BudgetStatus) error {
+	for _, status := range statuses {
+		if status.Exceeded {
+			return newBudgetError(status)
+		}
+	}
+	return nil
+}
+
+// SetProviderBudgets loads the spend of the budgets of the providers.
+func (s *Service) SetProviderBudgets(providers []*models.Provider) error {
+	for _, provider := range providers {
+		statuses, err := s.providerBudgetStatuses(provider)
+		if err != nil {
+			return err
+		}
+		provider.Budgets = statuses
+	}
+	return nil
+}
+
+// GetBudgetWarnings returns the budgets of the providers and the clients that reached their warning percent.
+func (s *Service) GetBudgetWarnings() ([]models.BudgetStatus, error) {
+	providers, err := s.GetAllProviders()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SetProviderBudgets(providers); err != nil {
+		return nil, err
+	}
+	statuses := []models.BudgetStatus{}
+	for _, provider := range providers {
+		statuses = append(statuses, provider.Budgets...)
+	}
+
+	clientBudgets, err := s.GetClientBudgets()
+	if err != nil {
+		return nil, err
+	}
+	for _, budget := range clientBudgets {
+		statuses = append(statuses, budget.Budgets...)
+	}
+
+	warnings := []models.BudgetStatus{}
+	for _, status := range statuses {
+		if status.Warning || status.Exceeded {
+			warnings = append(warnings, status)
+		}
+	}
+	return warnings, nil
+}
+
+// GetClientBudgets returns the budgets of the clients along with their spend.
+func (s *Service) GetClientBudgets() ([]*models.ClientBudget, error) {
+	budgets := []*models.ClientBudget{}
+	tx := s.Db.Order("app ASC").Find(&budgets)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	for _, budget := range budgets {
+		statuses, err := s.clientBudgetStatuses(budget)
+		if err != nil {
+			return nil, err
+		}
+		budget.Budgets = statuses
+	}
+	return budgets, nil
+}
+
+// SetClientBudget creates or updates the budget of the client that sends the app.
+func (s *Service) SetClientBudget(input models.ClientBudgetCreate) (*models.ClientBudget, error) {
+	if input.App == "" {
+		return nil, errors.New("the app of the client is required")
+	}
+	budget := &models.ClientBudget{}
+	tx := s.Db.Where("app = ?", input.App).Limit(1).Find(budget)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		budget = &models.ClientBudget{App: input.App, Budget: models.Budget{BudgetWarning: 80}}
+	}
+	input.Apply(&budget.Budget)
+	tx = s.Db.Save(budget)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return budget, nil
+}
+
+func (s *Service) DeleteClientBudget(id string) error {
+	// The app of a deleted budget can be given a new one
+	tx := s.Db.Unscoped().Where("id = ?", id).Delete(&models.ClientBudget{})
+	return tx.Error
+}
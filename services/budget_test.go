@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+// spend logs a response of the fake provider that costs a dollar per million tokens.
+func spend(t *testing.T, s *Service, options ProxyOptions, tokens int) error {
+	messages := []openai.ChatCompletionMessage{{Role: "user", Content: time.Now().String()}}
+	_, route, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "model", Messages: messages}, options)
+	if err != nil {
+		return err
+	}
+	return s.AddProxyResponse(route, &models.Message{
+		Role:     "assistant",
+		Content:  "Reply",
+		Metadata: &models.MessageMetadata{InputTokenCount: tokens},
+	})
+}
+
+func TestBudgetPeriodStart(t *testing.T) {
+	now := time.Date(2024, 5, 17, 15, 4, 5, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC), budgetPeriodStart(models.BudgetPeriodDaily, now))
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), budgetPeriodStart(models.BudgetPeriodMonthly, now))
+}
+
+func TestProviderBudget(t *testing.T) {
+	s, server := newFakeProxyService(t)
+	defer server.Close()
+	s.Db.Create(&models.Provider{BaseModel: models.BaseModel{ID: "fake"}, Budget: models.Budget{DailyBudget: 1, BudgetWarning: 50}})
+	s.Db.Create(&models.LLM{BaseModel: models.BaseModel{ID: "model"}, ProviderID: "fake", InputPrice: 1})
+	options := ProxyOptions{ProviderID: "fake"}
+
+	assert.Nil(t, spend(t, s, options, 600_000))
+	warnings, err := s.GetBudgetWarnings()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(warnings))
+	assert.Equal(t, models.BudgetStatus{Scope: "provider", Name: "fake", Period: "daily", Spent: 0.6, Limit: 1, Warning: true}, warnings[0])
+
+	assert.Nil(t, spend(t, s, options, 400_000))
+	err = spend(t, s, options, 1)
+	assert.True(t, IsBudgetError(err))
+	assert.Equal(t, http.StatusTooManyRequests, errorStatusCode(err))
+	assert.False(t, isRetryableError(err), "Expect a spent budget to not be retried")
+	assert.ErrorContains(t, err, "daily budget of the provider fake")
+
+	// The test models of the provider fail without being called
+	resultsChan, count, err := s.runTest(&RunTestInput{
+		Context: context.Background(),
+		Conversation: &models.Conversation{Messages: []*models.Message{
+			{Role: "user", Content: "Hello"},
+			{Role: "assistant", Content: "Hi"},
+		}},
+		TestIndexes: []int{1},
+		RunCount:    2,
+		TestModels:  []models.TestModels{{Provider: "fake", Model: "model"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	for result := range resultsChan {
+		assert.True(t, IsBudgetError(result.Err))
+	}
+
+	_, err = s.UpdateProvider("fake", models.ProviderUpdate{BudgetUpdate: models.BudgetUpdate{DailyBudget: new(float64)}})
+	assert.Nil(t, err)
+	assert.Nil(t, spend(t, s, options, 1), "Expect a provider without a budget to not be capped")
+}
+
+func TestTestBudget(t *testing.T) {
+	s, server := newFakeProxyService(t)
+	defer server.Close()
+	s.Db.Create(&models.Provider{BaseModel: models.BaseModel{ID: "fake"}, Budget: models.Budget{DailyBudget: 1}})
+	s.Db.Create(&models.LLM{BaseModel: models.BaseModel{ID: "model"}, ProviderID: "fake", InputPrice: 1})
+	assert.Nil(t, spend(t, s, ProxyOptions{ProviderID: "fake"}, 600_000))
+
+	// The results of the run are counted as they come in, before they are saved
+	budget := s.newTestBudget("run-1")
+	assert.Nil(t, budget.check("fake"))
+	budget.add("fake", 0.3)
+	assert.Nil(t, budget.check("fake"))
+	budget.add("fake", 0.1)
+	assert.True(t, IsBudgetError(budget.check("fake")), "Expect the calls of the run to spend the budget")
+
+	// And once saved they aren't counted twice
+	s.Db.Create(&models.Message{ProviderID: "fake", TestRunID: "run-1", Metadata: &models.MessageMetadata{Cost: 0.4}})
+	budget = s.newTestBudget("run-1")
+	budget.add("fake", 0.3)
+	assert.Nil(t, budget.check("fake"))
+}
+
+func TestClientBudget(t *testing.T) {
+	s, server := newFakeProxyService(t)
+	defer server.Close()
+	s.Db.Create(&models.LLM{BaseModel: models.BaseModel{ID: "model"}, ProviderID: "fake", InputPrice: 1})
+	monthly := 1.0
+	budget, err := s.SetClientBudget(models.ClientBudgetCreate{App: "chatbot", BudgetUpdate: models.BudgetUpdate{MonthlyBudget: &monthly}})
+	assert.Nil(t, err)
+	assert.Equal(t, 80.0, budget.BudgetWarning, "Expect the default warning percent")
+	_, err = s.SetClientBudget(models.ClientBudgetCreate{})
+	assert.Error(t, err)
+
+	assert.Nil(t, spend(t, s, ProxyOptions{ProviderID: "fake", App: "chatbot"}, 1_000_000))
+	err = spend(t, s, ProxyOptions{ProviderID: "fake", App: "chatbot"}, 1)
+	assert.True(t, IsBudgetError(err))
+	assert.ErrorContains(t, err, "monthly budget of the client chatbot")
+	assert.Nil(t, spend(t, s, ProxyOptions{ProviderID: "fake", App: "other"}, 1), "Expect the other clients to not be capped")
+
+	budgets, err := s.GetClientBudgets()
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, budgets[0].Budgets[0].Spent)
+	assert.True(t, budgets[0].Budgets[0].Exceeded)
+
+	assert.Nil(t, s.DeleteClientBudget(budget.ID))
+	_, err = s.SetClientBudget(models.ClientBudgetCreate{App: "chatbot"})
+	assert.Nil(t, err, "Expect the app of a deleted budget to get a new one")
+	assert.Nil(t, spend(t, s, ProxyOptions{ProviderID: "fake", App: "chatbot"}, 1))
+}
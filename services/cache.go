@@ -0,0 +1,398 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sashabaranov/go-openai"
+	"github.com/y2a-labs/evaluate/models"
+	"gorm.io/datatypes"
+)
+
+// cacheEntry is what a logged response is found by in the response cache of its model.
+type cacheEntry struct {
+	// CacheKey is the hash of the request, identical requests are answered with the response
+	CacheKey string
+	// CacheScope is the hash of the model, the parameters and the system prompt of the request. Within the scope,
+	// a request is answered with the response of a similar last user message
+	CacheScope string
+	// PromptEmbedding is the embedding of the last user message, it is only set when the cache is semantic
+	PromptEmbedding []float32
+}
+
+// responseCacheEntry returns the response cache of the model of the request and the entry of the request, the
+// cache is nil when there is none. Only requests that ask for one deterministic answer, with a zero temperature,
+// are cached.
+func (s *Service) responseCacheEntry(req openai.ChatCompletionRequest, options ProxyOptions) (*models.ResponseCache, cacheEntry, error) {
+	entry := cacheEntry{}
+	if req.N > 1 {
+		return nil, entry, nil
+	}
+	cache := &models.ResponseCache{}
+	tx := s.Db.Where("model = ?", req.Model).Limit(1).Find(cache)
+	if tx.Error != nil {
+		return nil, entry, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return nil, entry, nil
+	}
+	// The key is on the model behind the name, so pointing an alias to another model doesn't serve the old answers
+	target := s.resolveProxyTarget(req.Model, options)
+	if target.Err != nil {
+		return nil, entry, nil
+	}
+	// The defaults of the alias apply, and a missing temperature is the default of the provider, which isn't 0
+	temperature := clientTemperature(req, options)
+	if temperature := requestTemperature(temperature, target.Params); temperature == nil || *temperature != 0 {
+		return nil, entry, nil
+	}
+	req = withDefaultParams(req, temperature, target.Params)
+	var err error
+	entry.CacheKey, err = normalizedRequestKey(req, target)
+	if err != nil {
+		return nil, entry, err
+	}
+	system := []openai.ChatCompletionMessage{}
+	for _, message := range req.Messages {
+		if message.Role == openai.ChatMessageRoleSystem {
+			system = append(system, message)
+		}
+	}
+	req.Messages = system
+	entry.CacheScope, err = normalizedRequestKey(req, target)
+	if err != nil {
+		return nil, entry, err
+	}
+	return cache, entry, nil
+}
+
+// normalizedRequestKey hashes what changes the response of the request, a streamed request and its user don't.
+func normalizedRequestKey(req openai.ChatCompletionRequest, target proxyTarget) (string, error) {
+	req.Model = target.Name + "\x00" + target.ProviderID + "/" + target.ModelID
+	req.Stream = false
+	req.User = ""
+	messages := make([]openai.ChatCompletionMessage, len(req.Messages))
+	for i, message := range req.Messages {
+		message.Content = strings.TrimSpace(message.Content)
+		messages[i] = message
+	}
+	req.Messages = messages
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// findCachedResponse returns the latest logged response of the key that is younger than the time to live, or nil.
+// The responses that were served from the cache aren't used, so a response expires even when it keeps being hit.
+func (s *Service) findCachedResponse(key string, ttl time.Duration) (*models.Message, error) {
+	metadata := &models.MessageMetadata{}
+	tx := s.Db.Where("cache_key = ? AND cached_from = '' AND created_at >= ?", key, time.Now().Add(-ttl)).
+		Order("created_at DESC").Limit(1).Find(metadata)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return nil, nil
+	}
+	return s.getCachedMessage(metadata)
+}
+
+func (s *Service) getCachedMessage(metadata *models.MessageMetadata) (*models.Message, error) {
+	message := &models.Message{}
+	tx := s.Db.Where("id = ?", metadata.MessageID).Limit(1).Find(message)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return nil, nil
+	}
+	message.Metadata = metadata
+	return message, nil
+}
+
+// lastUserMessage returns the content of the last message of the request when it is from the user, the semantic
+// cache only compares requests that ask something.
+func lastUserMessage(messages []openai.ChatCompletionMessage) (string, bool) {
+	if len(messages) == 0 || messages[len(messages)-1].Role != openai.ChatMessageRoleUser {
+		return "", false
+	}
+	content := strings.TrimSpace(messages[len(messages)-1].Content)
+	return content, content != ""
+}
+
+// embedPrompt embeds the last user message of the request with the embedding model of the tests.
+func (s *Service) embedPrompt(ctx context.Context, messages []openai.ChatCompletionMessage) ([]float32, error) {
+	content, ok := lastUserMessage(messages)
+	if !ok {
+		return nil, nil
+	}
+	openaiProvider, ok := s.llmProviders["openai"]
+	if !ok || openaiProvider.client == nil {
+		return nil, fmt.Errorf("the semantic cache requires the openai provider")
+	}
+	return createEmbedding(ctx, openaiProvider.client, content)
+}
+
+// findSimilarResponse returns the response of the scope, younger than the time to live, whose last user message
+// is the most similar to the embedding, when the similarity reaches the threshold of the cache. The user messages
+// keep their embedding in their metadata.
+func (s *Service) findSimilarResponse(scope string, embedding []float32, cache *models.ResponseCache) (*models.Message, float64, error) {
+	candidates := []struct {
+		MessageID string
+		Embedding datatypes.JSONSlice[float32]
+	}{}
+	tx := s.Db.Table("message_metadata AS response_metadata").
+		Select("response_metadata.message_id, prompt_metadata.embedding").
+		Joins("JOIN messages AS responses ON responses.id = response_metadata.message_id AND responses.deleted_at IS NULL").
+		Joins(`JOIN messages AS prompts ON prompts.conversation_id = responses.conversation_id
+			AND prompts.message_index = responses.message_index - 1 AND prompts.role = ? AND prompts.deleted_at IS NULL`, openai.ChatMessageRoleUser).
+		Joins("JOIN message_metadata AS prompt_metadata ON prompt_metadata.message_id = prompts.id AND prompt_metadata.deleted_at IS NULL").
+		Where("response_metadata.cache_scope = ? AND response_metadata.cached_from = '' AND response_metadata.created_at >= ?", scope, time.Now().Add(-cache.TTL())).
+		Where("response_metadata.deleted_at IS NULL AND prompt_metadata.embedding IS NOT NULL").
+		Order("response_metadata.created_at DESC").
+		Scan(&candidates)
+	if tx.Error != nil {
+		return nil, 0, tx.Error
+	}
+	bestID := ""
+	best := 0.0
+	for _, candidate := range candidates {
+		similarity, err := s.CosineSimilarity(embedding, candidate.Embedding)
+		if err != nil {
+			// An embedding of another embedding model
+			continue
+		}
+		if similarity >= cache.SimilarityThreshold && similarity > best {
+			bestID = candidate.MessageID
+			best = similarity
+		}
+	}
+	if bestID == "" {
+		return nil, 0, nil
+	}
+	metadata := &models.MessageMetadata{}
+	tx = s.Db.Where("message_id = ?", bestID).Limit(1).Find(metadata)
+	if tx.Error != nil {
+		return nil, 0, tx.Error
+	}
+	cached, err := s.getCachedMessage(metadata)
+	return cached, best, err
+}
+
+// savePromptEmbedding keeps the embedding of the last user message of a logged response in the metadata of that
+// message, so later requests of the scope can be compared with it.
+func (s *Service) savePromptEmbedding(response *models.Message, embedding []float32) error {
+	prompt := &models.Message{}
+	tx := s.Db.Where("conversation_id = ? AND message_index = ? AND role = ?", response.ConversationID, response.MessageIndex-1, openai.ChatMessageRoleUser).
+		Order("conversation_version DESC").Limit(1).Find(prompt)
+	if tx.Error != nil || tx.RowsAffected == 0 {
+		return tx.Error
+	}
+	metadata := &models.MessageMetadata{}
+	tx = s.Db.Where("message_id = ?", prompt.ID).Limit(1).Find(metadata)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	metadata.MessageID = prompt.ID
+	metadata.Embedding = embedding
+	return s.Db.Save(metadata).Error
+}
+
+// lookupResponseCache returns the cache entry of the request, and the cached response with its route when there
+// is one. The identical request is looked up first, then the similar ones when the cache is semantic. A cached
+// response costs nothing, so it is served before the budgets, the traffic split and the fallbacks.
+func (s *Service) lookupResponseCache(ctx context.Context, req openai.ChatCompletionRequest, options ProxyOptions) (cacheEntry, *models.Message, *ProxyRoute, error) {
+	cache, entry, err := s.responseCacheEntry(req, options)
+	if err != nil || cache == nil {
+		return entry, nil, nil, err
+	}
+	if !options.NoCache {
+		cached, err := s.findCachedResponse(entry.CacheKey, cache.TTL())
+		if err != nil {
+			return entry, nil, nil, err
+		}
+		if cached != nil {
+			route, err := s.cachedRoute(req, cached, entry, 0, options)
+			return entry, cached, route, err
+		}
+	}
+	if cache.SimilarityThreshold == 0 {
+		return entry, nil, nil, nil
+	}
+
+	// The embedding is kept even when the cache is bypassed, so the fresh response can be found
+	entry.PromptEmbedding, err = s.embedPrompt(ctx, req.Messages)
+	if err != nil {
+		// The request is still answered, like a miss
+		log.Printf("failed to embed the prompt for the semantic cache of %s: %v", req.Model, err)
+		return entry, nil, nil, nil
+	}
+	if options.NoCache || entry.PromptEmbedding == nil {
+		return entry, nil, nil, nil
+	}
+	cached, similarity, err := s.findSimilarResponse(entry.CacheScope, entry.PromptEmbedding, cache)
+	if err != nil || cached == nil {
+		return entry, nil, nil, err
+	}
+	route, err := s.cachedRoute(req, cached, entry, similarity, options)
+	return entry, cached, route, err
+}
+
+// cachedRoute logs the request like one that was sent, with the model of the cached response as the one that
+// answered it.
+func (s *Service) cachedRoute(req openai.ChatCompletionRequest, cached *models.Message, entry cacheEntry, similarity float64, options ProxyOptions) (*ProxyRoute, error) {
+	conversation, err := s.threadConversation(req, cached.LLMID, options)
+	if err != nil {
+		return nil, err
+	}
+	return &ProxyRoute{
+		Conversation:   conversation,
+		ProviderID:     cached.ProviderID,
+		ModelID:        cached.LLMID,
+		RequestedModel: req.Model,
+		Alias:          cached.Metadata.Alias,
+		Request:        req,
+		cacheEntry:     entry,
+		CachedFrom:     cached.ID,
+		Similarity:     similarity,
+	}, nil
+}
+
+func cachedUsage(cached *models.Message) openai.Usage {
+	return openai.Usage{
+		PromptTokens:     cached.Metadata.InputTokenCount,
+		CompletionTokens: cached.Metadata.OutputTokenCount,
+		TotalTokens:      cached.Metadata.InputTokenCount + cached.Metadata.OutputTokenCount,
+	}
+}
+
+// cachedFinishReason tells the client to run the tools when the cached response calls some.
+func cachedFinishReason(cached *models.Message) openai.FinishReason {
+	if len(cached.ToolCalls) > 0 {
+		return openai.FinishReasonToolCalls
+	}
+	return openai.FinishReasonStop
+}
+
+// cachedChatCompletion answers with the cached response like the provider did.
+func cachedChatCompletion(cached *models.Message) *openai.ChatCompletionResponse {
+	return &openai.ChatCompletionResponse{
+		ID:      "chatcmpl-" + uuid.NewString(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   cached.LLMID,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: cached.Content, ToolCalls: cached.ToolCalls},
+			FinishReason: cachedFinishReason(cached),
+		}},
+		Usage: cachedUsage(cached),
+	}
+}
+
+// cachedStream streams the cached response in one chunk.
+type cachedStream struct {
+	cached *models.Message
+	id     string
+	sent   bool
+}
+
+func newCachedStream(cached *models.Message) *cachedStream {
+	return &cachedStream{cached: cached, id: "chatcmpl-" + uuid.NewString()}
+}
+
+func (c *cachedStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if c.sent {
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	c.sent = true
+	toolCalls := make([]openai.ToolCall, len(c.cached.ToolCalls))
+	for i, call := range c.cached.ToolCalls {
+		// Streamed tool calls are indexed
+		index := i
+		call.Index = &index
+		toolCalls[i] = call
+	}
+	return openai.ChatCompletionStreamResponse{
+		ID:      c.id,
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   c.cached.LLMID,
+		Choices: []openai.ChatCompletionStreamChoice{{
+			Delta:        openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant, Content: c.cached.Content, ToolCalls: toolCalls},
+			FinishReason: cachedFinishReason(c.cached),
+		}},
+	}, nil
+}
+
+func (c *cachedStream) Close() {}
+
+func (c *cachedStream) Usage() openai.Usage {
+	return cachedUsage(c.cached)
+}
+
+func (s *Service) GetResponseCaches() ([]*models.ResponseCache, error) {
+	caches := []*models.ResponseCache{}
+	tx := s.Db.Order("model ASC").Find(&caches)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	for _, cache := range caches {
+		tx = s.Db.Model(&models.MessageMetadata{}).
+			Select(`COUNT(CASE WHEN cached_from <> '' THEN 1 END) AS hits,
+				COUNT(CASE WHEN cached_from <> '' AND similarity > 0 THEN 1 END) AS semantic_hits,
+				COUNT(CASE WHEN cached_from = '' THEN 1 END) AS misses,
+				COALESCE(SUM(saved_cost), 0) AS saved_cost`).
+			Where("cache_model = ?", cache.Model).
+			Scan(&cache.Stats)
+		if tx.Error != nil {
+			return nil, tx.Error
+		}
+	}
+	return caches, nil
+}
+
+// SetResponseCache creates the response cache of the model, or changes its time to live and its threshold.
+func (s *Service) SetResponseCache(input models.ResponseCacheCreate) (*models.ResponseCache, error) {
+	model := strings.TrimSpace(input.Model)
+	if model == "" {
+		return nil, errors.New("the model of the response cache is required")
+	}
+	if input.TTLSeconds <= 0 {
+		return nil, errors.New("the time to live of the response cache must be above 0 seconds")
+	}
+	if input.SimilarityThreshold < 0 || input.SimilarityThreshold > 1 {
+		return nil, errors.New("the similarity threshold of the response cache must be between 0 and 1")
+	}
+
+	cache := &models.ResponseCache{}
+	tx := s.Db.Where("model = ?", model).Limit(1).Find(cache)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	cache.Model = model
+	cache.TTLSeconds = input.TTLSeconds
+	cache.SimilarityThreshold = input.SimilarityThreshold
+	tx = s.Db.Save(cache)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return cache, nil
+}
+
+func (s *Service) DeleteResponseCache(id string) error {
+	// The model of a deleted cache can be given a new one
+	tx := s.Db.Unscoped().Where("id = ?", id).Delete(&models.ResponseCache{})
+	return tx.Error
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestProxyResponseCache(t *testing.T) {
+	s, server, requests := newRecordingProxyService(t)
+	defer server.Close()
+	s.Db.Create(&models.LLM{BaseModel: models.BaseModel{ID: "model"}, ProviderID: "fake", InputPrice: 1, OutputPrice: 2})
+
+	_, err := s.SetResponseCache(models.ResponseCacheCreate{Model: "fake/model"})
+	assert.Error(t, err, "Expect a time to live")
+	_, err = s.SetResponseCache(models.ResponseCacheCreate{Model: "fake/model", TTLSeconds: 60})
+	assert.Nil(t, err)
+
+	// Only the requests with a zero temperature are cached
+	deterministic := ProxyOptions{Temperature: ptr(float32(0))}
+	messages := []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}
+	_, route, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages}, deterministic)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", route.CacheKey)
+	original := &models.Message{Role: "assistant", Content: "Reply", Metadata: &models.MessageMetadata{InputTokenCount: 1_000_000, OutputTokenCount: 500_000}}
+	assert.Nil(t, s.AddProxyResponse(route, original))
+	assert.Equal(t, 1, len(*requests))
+
+	// The same request is streamed from the cache, whitespace and the user don't change it
+	stream, cachedRoute, err := s.ProxyOpenaiStream(context.Background(), openai.ChatCompletionRequest{
+		Model: "fake/model", Stream: true, User: "user-1", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: " Hi\n"}},
+	}, deterministic)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(*requests), "Expect the response to come from the cache")
+	assert.Equal(t, original.ID, cachedRoute.CachedFrom)
+	chunk, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, "Reply", chunk.Choices[0].Delta.Content)
+	_, err = stream.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+	usage := StreamUsage(stream)
+	cached := &models.Message{Role: "assistant", Content: "Reply", Metadata: &models.MessageMetadata{InputTokenCount: usage.PromptTokens, OutputTokenCount: usage.CompletionTokens}}
+	assert.Nil(t, s.AddProxyResponse(cachedRoute, cached))
+	assert.Equal(t, original.ID, cached.Metadata.CachedFrom)
+	assert.Equal(t, 0.0, cached.Metadata.Cost)
+	assert.Equal(t, 2.0, cached.Metadata.SavedCost)
+	assert.Equal(t, "model", cached.LLMID)
+
+	response, _, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages}, deterministic)
+	assert.Nil(t, err)
+	assert.Equal(t, "Reply", response.Choices[0].Message.Content)
+	assert.Equal(t, 1_000_000, response.Usage.PromptTokens)
+	assert.Equal(t, 1, len(*requests))
+
+	// Sampled requests, the ones without a temperature and the bypass header are sent to the model
+	_, _, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Temperature: 0.7, Messages: messages}, ProxyOptions{Temperature: ptr(float32(0.7))})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(*requests))
+	_, route, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages}, ProxyOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(*requests))
+	assert.Equal(t, "", route.CacheKey)
+	_, route, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages}, ProxyOptions{NoCache: true, Temperature: deterministic.Temperature})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(*requests))
+	assert.Equal(t, "", route.CachedFrom)
+	assert.NotEqual(t, "", route.CacheKey, "Expect the fresh response to refresh the cache")
+
+	// The cached response expires
+	s.Db.Model(&models.MessageMetadata{}).Where("message_id = ?", original.ID).Update("created_at", time.Now().Add(-time.Hour))
+	_, _, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages}, deterministic)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(*requests))
+
+	caches, err := s.GetResponseCaches()
+	assert.Nil(t, err)
+	assert.Nil(t, s.DeleteResponseCache(caches[0].ID))
+	_, route, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages}, deterministic)
+	assert.Nil(t, err)
+	assert.Equal(t, "", route.CacheKey)
+
+	// The default temperature of an alias applies before the request is found to be deterministic
+	sampled := float32(0.7)
+	_, err = s.SetAlias(models.AliasCreate{Name: "sampled", ProviderID: "fake", Model: "model", Params: models.GenerationParams{Temperature: &sampled}})
+	assert.Nil(t, err)
+	_, err = s.SetResponseCache(models.ResponseCacheCreate{Model: "sampled", TTLSeconds: 60})
+	assert.Nil(t, err)
+	_, route, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "sampled", Messages: messages}, ProxyOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "", route.CacheKey)
+}
+
+func TestProxySemanticCache(t *testing.T) {
+	s, server, requests := newRecordingProxyService(t)
+	defer server.Close()
+	embeddings := map[string][]float32{
+		"What is the capital of France?": {1, 0, 0},
+		"What's the capital of France?":  {0.98, 0.2, 0},
+		"How tall is Everest?":           {0, 1, 0},
+	}
+	embeddingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := openai.EmbeddingRequestStrings{}
+		json.NewDecoder(r.Body).Decode(&request)
+		json.NewEncoder(w).Encode(openai.EmbeddingResponse{Data: []openai.Embedding{{Embedding: embeddings[request.Input[0]]}}})
+	}))
+	defer embeddingServer.Close()
+	s.llmProviders["openai"] = newLLMProvider(&models.Provider{BaseModel: models.BaseModel{ID: "openai"}, BaseUrl: embeddingServer.URL}, "key")
+
+	_, err := s.SetResponseCache(models.ResponseCacheCreate{Model: "fake/model", TTLSeconds: 60, SimilarityThreshold: 1.5})
+	assert.Error(t, err)
+	_, err = s.SetResponseCache(models.ResponseCacheCreate{Model: "fake/model", TTLSeconds: 60, SimilarityThreshold: 0.95})
+	assert.Nil(t, err)
+
+	ask := func(system, question string) (*ProxyRoute, *models.Message) {
+		messages := []openai.ChatCompletionMessage{{Role: "system", Content: system}, {Role: "user", Content: question}}
+		_, route, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages}, ProxyOptions{Temperature: ptr(float32(0))})
+		assert.Nil(t, err)
+		message := &models.Message{Role: "assistant", Content: "Reply", Metadata: &models.MessageMetadata{}}
+		assert.Nil(t, s.AddProxyResponse(route, message))
+		return route, message
+	}
+	_, original := ask("Be brief", "What is the capital of France?")
+	assert.Equal(t, 1, len(*requests))
+
+	similar, _ := ask("Be brief", "What's the capital of France?")
+	assert.Equal(t, 1, len(*requests), "Expect a similar question to be answered from the cache")
+	assert.InDelta(t, 0.98, similar.Similarity, 0.01)
+	assert.Equal(t, original.ID, similar.CachedFrom)
+
+	ask("Answer in French", "What's the capital of France?")
+	assert.Equal(t, 2, len(*requests), "Expect the cache to be scoped to the system prompt")
+	ask("Be brief", "How tall is Everest?")
+	assert.Equal(t, 3, len(*requests))
+
+	caches, err := s.GetResponseCaches()
+	assert.Nil(t, err)
+	assert.Equal(t, models.CacheStats{Hits: 1, SemanticHits: 1, Misses: 3}, caches[0].Stats)
+}
@@ -5,9 +5,12 @@ import (
 	"context"
 	"fmt"
 	"github.com/y2a-labs/evaluate/models"
+	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/sashabaranov/go-openai"
+	"gorm.io/datatypes"
 )
 
 func (s *Service) GetConversation(id string) (*models.Conversation, error) {
@@ -34,16 +37,39 @@ func (s *Service) GetConversationWithMessages(id string, selectedVersion int) (*
 		conversation.SelectedVersion = selectedVersion
 	}
 
+	// The transcripts of simulated test runs are versions of the test that only have their own messages
+	transcript, err := s.isTranscriptVersion(id, conversation.SelectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	if transcript && selectedVersion == -1 {
+		// The latest version is the latest of the test itself
+		tx = s.Db.Model(&models.Message{}).Select("COALESCE(MAX(conversation_version), 0)").
+			Where("conversation_id = ? AND test_message_id = '' AND COALESCE(test_run_id, '') = ''", id).
+			Scan(&conversation.SelectedVersion)
+		if tx.Error != nil {
+			return nil, tx.Error
+		}
+		transcript = false
+	}
+
 	// Adjusted raw SQL query to fetch the primary messages based on conversation version
 	sql := `
 WITH RankedMessages AS (
     SELECT m.*,
            ROW_NUMBER() OVER(PARTITION BY m.message_index ORDER BY m.conversation_version DESC) as Rank
     FROM messages m
-    WHERE m.conversation_id = ? AND m.conversation_version <= ? AND test_message_id = ''
+    WHERE m.conversation_id = ? AND m.conversation_version <= ? AND test_message_id = '' AND COALESCE(m.test_run_id, '') = ''
 )
 SELECT * FROM RankedMessages WHERE Rank = 1 AND role <> '' ORDER BY message_index ASC;
 `
+	if transcript {
+		sql = `
+SELECT * FROM messages
+WHERE conversation_id = ? AND conversation_version = ? AND test_message_id = '' AND COALESCE(test_run_id, '') <> ''
+ORDER BY message_index ASC;
+`
+	}
 
 	messages := []*models.Message{}
 	if err := s.Db.Raw(sql, id, conversation.SelectedVersion).Scan(&messages).Error; err != nil {
@@ -54,14 +80,35 @@ SELECT * FROM RankedMessages WHERE Rank = 1 AND role <> '' ORDER BY message_inde
 	return conversation, nil
 }
 
+// isTranscriptVersion reports whether the version of the conversation holds the transcript of a simulated test run.
+func (s *Service) isTranscriptVersion(id string, version int) (bool, error) {
+	count := int64(0)
+	tx := s.Db.Model(&models.Message{}).
+		Where("conversation_id = ? AND conversation_version = ? AND test_message_id = '' AND COALESCE(test_run_id, '') <> ''", id, version).
+		Count(&count)
+	return count > 0, tx.Error
+}
+
 func (s *Service) CreateConversation(input models.ConversationCreate) (*models.Conversation, error) {
 	conversation := &models.Conversation{
+		BaseModel:        models.BaseModel{ID: input.ID},
 		Name:             input.Name,
 		Description:      input.Description,
 		ModelID:          input.LLMID,
 		Version:          0,
 		IsTest:           input.IsTest,
+		Tags:             input.Tags,
+		ImportKey:        input.ImportKey,
+		User:             input.User,
+		App:              input.App,
 		LastMessageIndex: len(input.Messages),
+		Tools:            input.Tools,
+	}
+	if len(input.Metadata) > 0 {
+		conversation.Metadata = datatypes.JSONMap{}
+		for key, value := range input.Metadata {
+			conversation.Metadata[key] = value
+		}
 	}
 
 	if len(input.Messages) > 0 {
@@ -72,6 +119,9 @@ func (s *Service) CreateConversation(input models.ConversationCreate) (*models.C
 				BaseModel:           models.BaseModel{ID: uuid.NewString()},
 				Role:                message.Role,
 				Content:             message.Content,
+				Name:                message.Name,
+				ToolCalls:           message.ToolCalls,
+				ToolCallID:          message.ToolCallID,
 				MessageIndex:        i,
 				ConversationID:      conversation.ID,
 				ConversationVersion: 0,
@@ -88,15 +138,52 @@ func (s *Service) CreateConversation(input models.ConversationCreate) (*models.C
 	return conversation, nil
 }
 
-func (s *Service) GetAllConversations() ([]*models.Conversation, error) {
+func (s *Service) GetAllConversations(filter models.ConversationFilter) ([]*models.Conversation, error) {
 	conversations := []*models.Conversation{}
-	tx := s.Db.Limit(25).Order("created_at DESC").Where("is_test = ?", false).Find(&conversations)
+	query := s.Db.Limit(25).Order("created_at DESC").Where("is_test = ?", false)
+	if len(filter.Tags) > 0 {
+		query = query.Where("EXISTS (SELECT 1 FROM json_each(conversations.tags) WHERE json_each.value IN ?)", filter.Tags)
+	}
+	if filter.User != "" {
+		query = query.Where("conversations.user = ?", filter.User)
+	}
+	if filter.App != "" {
+		query = query.Where("conversations.app = ?", filter.App)
+	}
+	for key, value := range filter.Metadata {
+		query = query.Where("json_extract(conversations.metadata, ?) = ?", "$."+strconv.Quote(key), value)
+	}
+	tx := query.Find(&conversations)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
+	if err := s.SetConversationCosts(conversations...); err != nil {
+		return nil, err
+	}
 	return conversations, nil
 }
 
+// ParseConversationFilter reads the filter of the conversation list. The tags are comma separated,
+// and the metadata is a comma separated list of key=value pairs.
+func ParseConversationFilter(tags, user, app, metadata string) models.ConversationFilter {
+	filter := models.ConversationFilter{
+		Tags: ParseTags(tags),
+		User: strings.TrimSpace(user),
+		App:  strings.TrimSpace(app),
+	}
+	for _, pair := range ParseTags(metadata) {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		if filter.Metadata == nil {
+			filter.Metadata = map[string]string{}
+		}
+		filter.Metadata[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return filter
+}
+
 func (s *Service) UpdateConversation(id string, input models.ConversationUpdate) (*models.Conversation, error) {
 	conversation := &models.Conversation{BaseModel: models.BaseModel{ID: id}}
 	tx := s.Db.First(conversation)
@@ -126,28 +213,41 @@ func (s *Service) UpdateConversation(id string, input models.ConversationUpdate)
 func appendMessageEmbeddings(messages []*models.Message, s *Service) error {
 	texts := make([]string, len(messages))
 	for i, message := range messages {
-		texts[i] = message.Content
+		texts[i] = ResponseText(message.Content, message.ToolCalls)
+	}
+	openaiProvider, ok := s.llmProviders["openai"]
+	if !ok {
+		return fmt.Errorf("embeddings require the openai provider")
+	}
+	// Wait for permission before making the request
+	if s.limiter != nil {
+		if err := s.limiter.GetLimiter(openaiProvider.Provider).Wait(context.Background()); err != nil {
+			return fmt.Errorf("rate limiter wait error: %w", err)
+		}
 	}
 	// add the text embeddings
-	embeddings, err := s.llmProviders["openai"].client.CreateEmbeddings(context.Background(), openai.EmbeddingRequestStrings{
+	embeddings, err := openaiProvider.client.CreateEmbeddings(context.Background(), openai.EmbeddingRequestStrings{
 		Model: "text-embedding-3-small",
 		Input: texts,
 	})
 	if err != nil {
 		return err
 	}
+	if len(embeddings.Data) != len(messages) {
+		return fmt.Errorf("expected %d embeddings, got %d", len(messages), len(embeddings.Data))
+	}
 	// add the embeddings to the messages
 	messageMetadata := make([]*models.MessageMetadata, len(messages))
 	for i, message := range messages {
 		messageMetadata[i] = &models.MessageMetadata{
 			MessageID: message.ID,
-			Embedding: embeddings.Data[0].Embedding,
+			Embedding: embeddings.Data[i].Embedding,
 		}
 	}
 	// Update the messages in the database
 	tx := s.Db.Create(messageMetadata)
 	if tx.Error != nil {
-		fmt.Println(tx.Error)
+		return tx.Error
 	}
 	return nil
 }
@@ -162,6 +262,9 @@ func (s *Service) AddMessagesToConversation(conversation *models.Conversation, i
 			BaseModel:           models.BaseModel{ID: uuid.NewString()},
 			Role:                message.Role,
 			Content:             message.Content,
+			Name:                message.Name,
+			ToolCalls:           message.ToolCalls,
+			ToolCallID:          message.ToolCallID,
 			MessageIndex:        conversation.LastMessageIndex + 1,
 			ConversationID:      conversation.ID,
 			ConversationVersion: conversation.Version,
@@ -198,7 +301,7 @@ func (s *Service) DeleteConversation(id string) (*models.Conversation, error) {
 type ConversationManager interface {
 	GetConversation(id string) (*models.Conversation, error)
 	CreateConversation(*models.ConversationCreate) (*models.Conversation, error)
-	GetAllConversations() ([]*models.Conversation, error)
+	GetAllConversations(filter models.ConversationFilter) ([]*models.Conversation, error)
 	UpdateConversation(id string, input models.ConversationUpdate) (*models.Conversation, error)
 	DeleteConversation(id string) (any, error)
 	AddMessagesToConversation(conversationId string, input models.ConversationUpdate) ([]models.Message, error)
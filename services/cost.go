@@ -0,0 +1,104 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/y2a-labs/evaluate/models"
+)
+
+type PriceImportResult struct {
+	Updated int `json:"updated"`
+	// Skipped are the models of the price sheet that aren't saved at any provider
+	Skipped []string `json:"skipped"`
+}
+
+// ImportPrices sets the prices of the saved models from a price sheet, a JSON array of
+// {"provider", "model", "input", "output"} with the prices in dollars per million tokens.
+func (s *Service) ImportPrices(data io.Reader) (*PriceImportResult, error) {
+	prices := []models.LLMPrice{}
+	if err := json.NewDecoder(data).Decode(&prices); err != nil {
+		return nil, fmt.Errorf("invalid price sheet: %w", err)
+	}
+
+	result := &PriceImportResult{Skipped: []string{}}
+	for _, price := range prices {
+		if price.Model == "" {
+			continue
+		}
+		query := s.Db.Model(&models.LLM{}).Where("id = ?", price.Model)
+		if price.Provider != "" {
+			query = query.Where("provider_id = ?", price.Provider)
+		}
+		tx := query.Updates(map[string]any{
+			"input_price":  price.InputPrice,
+			"output_price": price.OutputPrice,
+		})
+		if tx.Error != nil {
+			return nil, tx.Error
+		}
+		if tx.RowsAffected == 0 {
+			result.Skipped = append(result.Skipped, price.Model)
+			continue
+		}
+		result.Updated += int(tx.RowsAffected)
+	}
+	return result, nil
+}
+
+// getLLMPrice returns the model with its prices, models that aren't saved are free. A model is saved at the first
+// provider that listed it, so its usage at the other providers is free too, which is logged once per model.
+func (s *Service) getLLMPrice(providerID, modelID string) *models.LLM {
+	llm := &models.LLM{}
+	tx := s.Db.Where("id = ? AND provider_id = ?", modelID, providerID).Limit(1).Find(llm)
+	if tx.Error == nil && tx.RowsAffected == 0 {
+		if _, warned := s.unpricedModels.LoadOrStore(providerID+"/"+modelID, true); !warned {
+			log.Printf("warning: %s/%s has no saved prices, its usage costs nothing and doesn't count towards the budgets", providerID, modelID)
+		}
+	}
+	return llm
+}
+
+// setCost prices the message with the prices of the model that generated it.
+func setCost(metadata *models.MessageMetadata, llm *models.LLM) {
+	if metadata == nil {
+		return
+	}
+	metadata.Cost = llm.Cost(metadata.InputTokenCount, metadata.OutputTokenCount)
+}
+
+// SetConversationCosts adds up the cost of the logged responses of every conversation.
+func (s *Service) SetConversationCosts(conversations ...*models.Conversation) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+	ids := make([]string, len(conversations))
+	for i, conversation := range conversations {
+		ids[i] = conversation.ID
+	}
+
+	costs := []struct {
+		ConversationID string
+		Cost           float64
+	}{}
+	tx := s.Db.Model(&models.Message{}).
+		Select("messages.conversation_id, SUM(message_metadata.cost) AS cost").
+		Joins("JOIN message_metadata ON message_metadata.message_id = messages.id AND message_metadata.deleted_at IS NULL").
+		Where("messages.conversation_id IN ? AND messages.test_message_id = ''", ids).
+		Group("messages.conversation_id").
+		Scan(&costs)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	costByID := map[string]float64{}
+	for _, cost := range costs {
+		costByID[cost.ConversationID] = cost.Cost
+	}
+	for _, conversation := range conversations {
+		conversation.Cost = costByID[conversation.ID]
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestLLMCost(t *testing.T) {
+	llm := &models.LLM{InputPrice: 2.5, OutputPrice: 10}
+	assert.InDelta(t, 0.0125, llm.Cost(1000, 1000), 1e-9)
+	assert.Equal(t, 0.0, (&models.LLM{}).Cost(1000, 1000), "Expect models without prices to be free")
+}
+
+func TestImportPrices(t *testing.T) {
+	s := New(":memory:", "../.env")
+	s.Db.Create(&models.LLM{BaseModel: models.BaseModel{ID: "gpt-4o"}, ProviderID: "openai"})
+	s.Db.Create(&models.LLM{BaseModel: models.BaseModel{ID: "openai/gpt-4o-mini"}, ProviderID: "openrouter"})
+
+	result, err := s.ImportPrices(strings.NewReader(`[
+		{"model": "gpt-4o", "input": 2.5, "output": 10},
+		{"provider": "openrouter", "model": "openai/gpt-4o-mini", "input": 0.15, "output": 0.6},
+		{"provider": "openai", "model": "unknown", "input": 1, "output": 1}
+	]`))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Updated)
+	assert.Equal(t, []string{"unknown"}, result.Skipped)
+	assert.Equal(t, 0.6, s.getLLMPrice("openrouter", "openai/gpt-4o-mini").OutputPrice)
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+	assert.Equal(t, 0.0, s.getLLMPrice("other", "gpt-4o").InputPrice, "Expect the price to be kept per provider")
+	s.getLLMPrice("other", "gpt-4o")
+	assert.Equal(t, 1, strings.Count(logs.String(), "other/gpt-4o has no saved prices"), "Expect the unpriced usage to be logged once")
+
+	llm, err := s.UpdateLLM("gpt-4o", models.LLMUpdate{ProviderID: "openai", InputPrice: 5, OutputPrice: 0})
+	assert.Nil(t, err)
+	assert.Equal(t, 5.0, llm.InputPrice)
+	assert.Equal(t, 0.0, s.getLLMPrice("openai", "gpt-4o").OutputPrice, "Expect a price to be set back to zero")
+
+	_, err = s.ImportPrices(strings.NewReader(`{"gpt-4o": 1}`))
+	assert.Error(t, err)
+}
+
+func TestProxyCost(t *testing.T) {
+	s, server := newFakeProxyService(t)
+	defer server.Close()
+	s.Db.Create(&models.LLM{BaseModel: models.BaseModel{ID: "model"}, ProviderID: "fake", InputPrice: 1, OutputPrice: 2})
+
+	messages := []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}
+	_, route, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "model", Messages: messages}, ProxyOptions{ProviderID: "fake"})
+	assert.Nil(t, err)
+	for range 2 {
+		err = s.AddProxyResponse(route, &models.Message{
+			Role:     "assistant",
+			Content:  "Reply",
+			Metadata: &models.MessageMetadata{InputTokenCount: 1_000_000, OutputTokenCount: 500_000},
+		})
+		assert.Nil(t, err)
+	}
+	conversation := route.Conversation
+	assert.Equal(t, 2.0, conversation.Messages[len(conversation.Messages)-1].Metadata.Cost)
+
+	conversations, err := s.GetAllConversations(models.ConversationFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 4.0, conversations[0].Cost)
+}
+
+func TestFinishTestRunCost(t *testing.T) {
+	s := New(":memory:", "../.env")
+	conversation := &models.Conversation{
+		BaseModel: models.BaseModel{ID: "test-run-cost"},
+		TestModels: []models.TestModels{
+			{ID: "a", Provider: "openai", Model: "a", Cost: 3},
+			{ID: "b", Provider: "openai", Model: "b"},
+		},
+	}
+	testRun, err := s.createTestRun(ExecuteTestInput{RunCount: 2}, &RunTestInput{Conversation: conversation})
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, testRun.Models[0].Cost, "Expect the costs of previous runs to be cleared")
+
+	results := []*models.Message{
+		{LLMID: "a", TestModelID: "a", Metadata: &models.MessageMetadata{Cost: 0.5}},
+		{LLMID: "a", TestModelID: "a", Metadata: &models.MessageMetadata{Cost: 0.25}},
+		{LLMID: "b", TestModelID: "b", Metadata: &models.MessageMetadata{Cost: 1}},
+	}
+	err = s.finishTestRun(testRun, results, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.75, testRun.Models[0].Cost)
+	assert.Equal(t, 1.0, testRun.Models[1].Cost)
+	assert.Equal(t, 1.75, testRun.Cost)
+}
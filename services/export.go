@@ -0,0 +1,340 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/y2a-labs/evaluate/models"
+)
+
+const (
+	ExportFormatCSV      = "csv"
+	ExportFormatJSON     = "json"
+	ExportFormatMarkdown = "markdown"
+	ExportFormatHTML     = "html"
+)
+
+// TestExport is the report of the results of a test version.
+type TestExport struct {
+	TestID     string           `json:"test_id"`
+	TestName   string           `json:"test_name"`
+	Version    int              `json:"version"`
+	Scorer     string           `json:"scorer"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Models     []ModelSummary   `json:"models"`
+	Results    []ExportedResult `json:"results"`
+}
+
+// ModelSummary sums up the results of a single tested model entry.
+type ModelSummary struct {
+	TestModelID   string  `json:"test_model_id"`
+	Provider      string  `json:"provider"`
+	Model         string  `json:"model"`
+	Settings      string  `json:"settings,omitempty"`
+	Results       int     `json:"results"`
+	MeanScore     float64 `json:"mean_score"`
+	MedianScore   float64 `json:"median_score"`
+	MeanLatencyMs float64 `json:"mean_latency_ms"`
+	InputTokens   int     `json:"input_tokens"`
+	OutputTokens  int     `json:"output_tokens"`
+}
+
+// ExportedResult is a single candidate output along with the reference message it was generated for.
+type ExportedResult struct {
+	MessageIndex int     `json:"message_index"`
+	Reference    string  `json:"reference"`
+	Prompt       string  `json:"prompt,omitempty"`
+	TestModelID  string  `json:"test_model_id"`
+	Model        string  `json:"model"`
+	Settings     string  `json:"settings,omitempty"`
+	Output       string  `json:"output"`
+	Score        float64 `json:"score"`
+	Rationale    string  `json:"rationale,omitempty"`
+	LatencyMs    int     `json:"latency_ms"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+}
+
+// ExportTest scores the results of the test version and sums them up for every tested model.
+func (s *Service) ExportTest(conversationID string, selectedVersion int) (*TestExport, error) {
+	conversation, testMessages, err := s.getTest(conversationID, selectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	export := &TestExport{
+		TestID:     conversation.ID,
+		TestName:   conversation.Name,
+		Version:    conversation.SelectedVersion,
+		Scorer:     conversation.Scorer,
+		ExportedAt: time.Now(),
+		Models:     []ModelSummary{},
+		Results:    []ExportedResult{},
+	}
+
+	references := map[string]*models.Message{}
+	for _, message := range conversation.Messages {
+		references[message.ID] = message
+	}
+	promptLabels := map[string]string{}
+	for _, promptScore := range conversation.PromptScores {
+		promptLabels[promptScore.PromptID] = promptScore.Label
+	}
+
+	// Models that were removed from the test since keep their results
+	summaryIndex := map[string]int{}
+	for _, testModel := range conversation.TestModels {
+		summaryIndex[testModel.Key()] = len(export.Models)
+		export.Models = append(export.Models, ModelSummary{
+			TestModelID: testModel.Key(),
+			Provider:    testModel.Provider,
+			Model:       testModel.Model,
+			Settings:    testModel.GenerationParams.String(),
+		})
+	}
+
+	scores := map[string][]float64{}
+	latencies := map[string][]int{}
+	for _, testMessage := range testMessages {
+		key := testMessage.TestModelKey()
+		i, ok := summaryIndex[key]
+		if !ok {
+			i = len(export.Models)
+			summaryIndex[key] = i
+			export.Models = append(export.Models, ModelSummary{TestModelID: key, Model: testMessage.LLMID})
+		}
+
+		result := ExportedResult{
+			MessageIndex: testMessage.MessageIndex,
+			Prompt:       promptLabels[testMessage.PromptID],
+			TestModelID:  key,
+			Model:        testMessage.LLMID,
+			Settings:     testMessage.Settings,
+			Output:       testMessage.Content,
+			Score:        testMessage.Score,
+		}
+		if reference, ok := references[testMessage.TestMessageID]; ok {
+			result.Reference = reference.Content
+		}
+		if metadata := testMessage.Metadata; metadata != nil {
+			result.Rationale = metadata.ScoreRationale
+			result.LatencyMs = metadata.EndLatencyMs
+			result.InputTokens = metadata.InputTokenCount
+			result.OutputTokens = metadata.OutputTokenCount
+		}
+		export.Results = append(export.Results, result)
+
+		scores[key] = append(scores[key], result.Score)
+		latencies[key] = append(latencies[key], result.LatencyMs)
+		export.Models[i].Results++
+		export.Models[i].InputTokens += result.InputTokens
+		export.Models[i].OutputTokens += result.OutputTokens
+	}
+
+	for i, summary := range export.Models {
+		export.Models[i].MeanScore = mean(scores[summary.TestModelID])
+		export.Models[i].MedianScore = median(scores[summary.TestModelID])
+		export.Models[i].MeanLatencyMs = mean(latencies[summary.TestModelID])
+	}
+
+	// Keep the results in the order of the conversation, with the best ones first
+	sort.SliceStable(export.Results, func(i, j int) bool {
+		if export.Results[i].MessageIndex != export.Results[j].MessageIndex {
+			return export.Results[i].MessageIndex < export.Results[j].MessageIndex
+		}
+		return export.Results[i].Score > export.Results[j].Score
+	})
+	return export, nil
+}
+
+func mean[T int | float64](values []T) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, value := range values {
+		sum += float64(value)
+	}
+	return roundTo2(sum / float64(len(values)))
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return roundTo2((sorted[middle-1] + sorted[middle]) / 2)
+	}
+	return sorted[middle]
+}
+
+func roundTo2(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// ExportFormats returns the formats a test can be exported in.
+func ExportFormats() []string {
+	return []string{ExportFormatCSV, ExportFormatJSON, ExportFormatMarkdown, ExportFormatHTML}
+}
+
+// ExportContentType returns the content type and file extension of the export format.
+func ExportContentType(format string) (string, string, error) {
+	switch format {
+	case ExportFormatCSV:
+		return "text/csv; charset=utf-8", "csv", nil
+	case ExportFormatJSON:
+		return "application/json", "json", nil
+	case ExportFormatMarkdown:
+		return "text/markdown; charset=utf-8", "md", nil
+	case ExportFormatHTML:
+		return "text/html; charset=utf-8", "html", nil
+	default:
+		return "", "", fmt.Errorf("export format not found: %s", format)
+	}
+}
+
+// WriteTestExport writes the report in the format.
+func WriteTestExport(w io.Writer, export *TestExport, format string) error {
+	switch format {
+	case ExportFormatCSV:
+		return writeExportCSV(w, export)
+	case ExportFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(export)
+	case ExportFormatMarkdown:
+		return writeExportMarkdown(w, export)
+	case ExportFormatHTML:
+		return exportHTMLTemplate.Execute(w, export)
+	default:
+		return fmt.Errorf("export format not found: %s", format)
+	}
+}
+
+// writeExportCSV writes the summary table, an empty line and then the results table,
+// so both can be pasted into a spreadsheet.
+func writeExportCSV(w io.Writer, export *TestExport) error {
+	writer := csv.NewWriter(w)
+	formatFloat := func(value float64) string {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	writer.Write([]string{"provider", "model", "settings", "results", "mean_score", "median_score", "mean_latency_ms", "input_tokens", "output_tokens"})
+	for _, summary := range export.Models {
+		writer.Write([]string{
+			summary.Provider,
+			summary.Model,
+			summary.Settings,
+			strconv.Itoa(summary.Results),
+			formatFloat(summary.MeanScore),
+			formatFloat(summary.MedianScore),
+			formatFloat(summary.MeanLatencyMs),
+			strconv.Itoa(summary.InputTokens),
+			strconv.Itoa(summary.OutputTokens),
+		})
+	}
+	writer.Write(nil)
+
+	writer.Write([]string{"message_index", "prompt", "model", "settings", "score", "latency_ms", "input_tokens", "output_tokens", "reference", "output", "rationale"})
+	for _, result := range export.Results {
+		writer.Write([]string{
+			strconv.Itoa(result.MessageIndex),
+			result.Prompt,
+			result.Model,
+			result.Settings,
+			formatFloat(result.Score),
+			strconv.Itoa(result.LatencyMs),
+			strconv.Itoa(result.InputTokens),
+			strconv.Itoa(result.OutputTokens),
+			result.Reference,
+			result.Output,
+			result.Rationale,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// markdownCell keeps the value on a single table cell.
+func markdownCell(value string) string {
+	value = strings.ReplaceAll(value, "|", "\\|")
+	value = strings.ReplaceAll(value, "\r\n", "\n")
+	return strings.ReplaceAll(value, "\n", "<br>")
+}
+
+func writeExportMarkdown(w io.Writer, export *TestExport) error {
+	builder := strings.Builder{}
+	fmt.Fprintf(&builder, "# %s\n\n", export.TestName)
+	fmt.Fprintf(&builder, "Version %d, scored with %s, exported %s\n\n", export.Version, export.Scorer, export.ExportedAt.Format(time.RFC1123))
+
+	builder.WriteString("## Models\n\n")
+	builder.WriteString("| Model | Settings | Results | Mean score | Median score | Mean latency (ms) | Input tokens | Output tokens |\n")
+	builder.WriteString("| --- | --- | --: | --: | --: | --: | --: | --: |\n")
+	for _, summary := range export.Models {
+		fmt.Fprintf(&builder, "| %s | %s | %d | %.2f%% | %.2f%% | %.0f | %d | %d |\n",
+			markdownCell(summary.Model), markdownCell(summary.Settings), summary.Results, summary.MeanScore, summary.MedianScore,
+			summary.MeanLatencyMs, summary.InputTokens, summary.OutputTokens)
+	}
+
+	builder.WriteString("\n## Results\n")
+	messageIndex := -1
+	for _, result := range export.Results {
+		if result.MessageIndex != messageIndex {
+			messageIndex = result.MessageIndex
+			fmt.Fprintf(&builder, "\n### Message %d\n\n", messageIndex)
+			fmt.Fprintf(&builder, "> %s\n\n", strings.ReplaceAll(result.Reference, "\n", "\n> "))
+			builder.WriteString("| Model | Prompt | Score | Latency (ms) | Output |\n")
+			builder.WriteString("| --- | --- | --: | --: | --- |\n")
+		}
+		model := result.Model
+		if result.Settings != "" {
+			model += " (" + result.Settings + ")"
+		}
+		fmt.Fprintf(&builder, "| %s | %s | %.2f%% | %d | %s |\n",
+			markdownCell(model), markdownCell(result.Prompt), result.Score, result.LatencyMs, markdownCell(result.Output))
+	}
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+var exportHTMLTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .TestName }}</title>
+<style>
+body { font-family: sans-serif; margin: 2rem; }
+table { border-collapse: collapse; margin-bottom: 1.5rem; }
+th, td { border: 1px solid #ddd; padding: 0.4rem 0.6rem; text-align: left; vertical-align: top; }
+pre { white-space: pre-wrap; margin: 0; font-family: inherit; }
+blockquote { color: #555; border-left: 3px solid #ddd; margin: 0 0 1rem; padding-left: 1rem; }
+</style>
+</head>
+<body>
+<h1>{{ .TestName }}</h1>
+<p>Version {{ .Version }}, scored with {{ .Scorer }}, exported {{ .ExportedAt.Format "Mon, 02 Jan 2006 15:04:05 MST" }}</p>
+<h2>Models</h2>
+<table>
+<tr><th>Model</th><th>Settings</th><th>Results</th><th>Mean score</th><th>Median score</th><th>Mean latency (ms)</th><th>Input tokens</th><th>Output tokens</th></tr>
+{{ range .Models }}<tr><td>{{ .Model }}</td><td>{{ .Settings }}</td><td>{{ .Results }}</td><td>{{ printf "%.2f" .MeanScore }}%</td><td>{{ printf "%.2f" .MedianScore }}%</td><td>{{ printf "%.0f" .MeanLatencyMs }}</td><td>{{ .InputTokens }}</td><td>{{ .OutputTokens }}</td></tr>
+{{ end }}</table>
+<h2>Results</h2>
+<table>
+<tr><th>Message</th><th>Reference</th><th>Model</th><th>Prompt</th><th>Score</th><th>Latency (ms)</th><th>Output</th></tr>
+{{ range .Results }}<tr><td>{{ .MessageIndex }}</td><td><pre>{{ .Reference }}</pre></td><td>{{ .Model }}{{ if .Settings }} ({{ .Settings }}){{ end }}</td><td>{{ .Prompt }}</td><td>{{ printf "%.2f" .Score }}%</td><td>{{ .LatencyMs }}</td><td><pre>{{ .Output }}</pre></td></tr>
+{{ end }}</table>
+</body>
+</html>
+`))
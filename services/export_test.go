@@ -0,0 +1,87 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestExportTest(t *testing.T) {
+	s := New(":memory:", "../.env")
+	conversation, err := s.CreateConversation(models.ConversationCreate{
+		Name:   "export",
+		IsTest: true,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: "user", Content: "Hi"},
+			{Role: "assistant", Content: "Hello"},
+		},
+	})
+	assert.Nil(t, err)
+	conversation.TestModels = []models.TestModels{{ID: "a", Provider: "fake", Model: "model-a"}}
+	conversation.Scorer = "exact_match"
+	tx := s.Db.Model(conversation).Updates(map[string]any{"test_models": conversation.TestModels, "scorer": conversation.Scorer})
+	assert.Nil(t, tx.Error)
+
+	reference := conversation.Messages[1]
+	for i, content := range []string{"Hello", "Hello", "Bye | now"} {
+		tx := s.Db.Create(&models.Message{
+			Role:          "assistant",
+			Content:       content,
+			LLMID:         "model-a",
+			TestModelID:   "a",
+			TestMessageID: reference.ID,
+			MessageIndex:  reference.MessageIndex,
+			Metadata:      &models.MessageMetadata{EndLatencyMs: 100 * (i + 1), InputTokenCount: 10, OutputTokenCount: 5},
+		})
+		assert.Nil(t, tx.Error)
+	}
+
+	export, err := s.ExportTest(conversation.ID, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(export.Results), "Expect duplicate outputs to be kept")
+	assert.Equal(t, ModelSummary{
+		TestModelID:   "a",
+		Provider:      "fake",
+		Model:         "model-a",
+		Results:       3,
+		MeanScore:     66.67,
+		MedianScore:   100,
+		MeanLatencyMs: 200,
+		InputTokens:   30,
+		OutputTokens:  15,
+	}, export.Models[0])
+	assert.Equal(t, "Hello", export.Results[0].Reference)
+	assert.Equal(t, "Bye | now", export.Results[2].Output, "Expect the best results first")
+
+	buffer := &bytes.Buffer{}
+	assert.Nil(t, WriteTestExport(buffer, export, ExportFormatJSON))
+	decoded := TestExport{}
+	assert.Nil(t, json.Unmarshal(buffer.Bytes(), &decoded))
+	assert.Equal(t, export.Models, decoded.Models)
+
+	buffer.Reset()
+	assert.Nil(t, WriteTestExport(buffer, export, ExportFormatCSV))
+	reader := csv.NewReader(buffer)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(rows), "Expect the summary header and row, then the results header and rows")
+	assert.Equal(t, "66.67", rows[1][4])
+
+	buffer.Reset()
+	assert.Nil(t, WriteTestExport(buffer, export, ExportFormatMarkdown))
+	assert.Contains(t, buffer.String(), "| model-a |  | 3 | 66.67% | 100.00% |")
+	assert.Contains(t, buffer.String(), "Bye \\| now", "Expect pipes to be escaped in tables")
+
+	buffer.Reset()
+	assert.Nil(t, WriteTestExport(buffer, export, ExportFormatHTML))
+	assert.True(t, strings.HasPrefix(buffer.String(), "<!DOCTYPE html>"))
+
+	assert.Error(t, WriteTestExport(buffer, export, "pdf"))
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+// ProxyRoute is the model that answered a proxied request, and the conversation the request is logged to.
+type ProxyRoute struct {
+	Conversation *models.Conversation
+	ProviderID   string
+	ModelID      string
+	// RequestedModel is the model of the request, which differs from the model that answered when it fell back
+	RequestedModel string
+	// Alias is the alias that named the model that answered, if any
+	Alias string
+	// FallbackErrors are the errors of the models that were tried before the one that answered
+	FallbackErrors []string
+	// Request is the request of the client, it is mirrored to the shadow models of the requested model
+	Request openai.ChatCompletionRequest
+	// Temperature is the temperature of the client, nil when the request leaves it out
+	Temperature *float32
+	// SplitArm is the arm of the traffic split of the model that answered, if any
+	SplitArm string
+	// cacheEntry is set when the model has a response cache and the response is the answer of the model
+	cacheEntry
+	// CachedFrom is the ID of the logged response that answered the request from the cache
+	CachedFrom string
+	// Similarity is set when the cached response is the one of a similar request
+	Similarity float64
+}
+
+// FellBack reports whether the request was answered by a fallback of the requested model.
+func (r *ProxyRoute) FellBack() bool {
+	return len(r.FallbackErrors) > 0
+}
+
+// proxyTarget is a model of a fallback chain, with the error that makes it unavailable.
+type proxyTarget struct {
+	Name       string
+	ModelID    string
+	ProviderID string
+	// Alias and Params are set when the name is an alias
+	Alias  string
+	Params models.GenerationParams
+	// SplitArm is the arm of the traffic split that the target answers for
+	SplitArm string
+	Err      error
+}
+
+// shouldFallback reports whether the next model of the chain is tried after the error. Errors of the request
+// itself, like a bad request, would fail at every provider and are returned as they are.
+func shouldFallback(err error) bool {
+	return isRetryableError(err) || IsBudgetError(err) || errors.Is(err, errProviderNotFound)
+}
+
+var errProviderNotFound = errors.New("provider not found")
+
+// proxyTargets returns the requested model followed by the models of its fallback chain. The models that
+// can't be sent to, because their provider isn't found or its budget is spent, keep the error.
+func (s *Service) proxyTargets(model string, options ProxyOptions) ([]proxyTarget, error) {
+	// The budget of the client caps every provider of the chain
+	if err := s.checkClientBudget(options.App); err != nil {
+		return nil, err
+	}
+	requested := s.resolveProxyTarget(model, options)
+	if requested.Err != nil {
+		// The conversation is still logged under the requested name
+		requested.ModelID = model
+	}
+	targets := []proxyTarget{requested}
+
+	chain := &models.FallbackChain{}
+	tx := s.Db.Where("model = ?", model).Limit(1).Find(chain)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected > 0 {
+		// A chain can be set for a model name that no provider serves, only its fallbacks are used then
+		for _, fallback := range chain.Fallbacks {
+			// The fallbacks name their own provider, or are aliases
+			targets = append(targets, s.resolveProxyTarget(fallback, ProxyOptions{}))
+		}
+	}
+
+	available := false
+	errs := []error{}
+	for i := range targets {
+		target := &targets[i]
+		s.checkTarget(target)
+		if target.Err == nil {
+			available = true
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", target.Name, target.Err))
+	}
+	if available {
+		return targets, nil
+	}
+	// Nothing is logged when no model can be sent to
+	if len(targets) == 1 {
+		return nil, targets[0].Err
+	}
+	earlier := make([]string, len(errs)-1)
+	for i, err := range errs[:len(errs)-1] {
+		earlier[i] = err.Error()
+	}
+	return nil, fmt.Errorf("no model of the fallback chain of %s is available: %s; %w", model, strings.Join(earlier, "; "), errs[len(errs)-1])
+}
+
+// checkTarget sets the error of the target when its provider isn't found or its budget is spent.
+func (s *Service) checkTarget(target *proxyTarget) {
+	if target.Err != nil {
+		return
+	}
+	if _, ok := s.llmProviders[target.ProviderID]; !ok {
+		target.Err = fmt.Errorf("%w: %s", errProviderNotFound, target.ProviderID)
+		return
+	}
+	target.Err = s.checkProviderBudget(target.ProviderID)
+}
+
+// routeProxyRequest sends the request to the requested model, and to the models of its fallback chain in order
+// while the ones before fail with a server error, a timeout, rate limiting or a spent budget. The temperature is the
+// one of the client.
+func (s *Service) routeProxyRequest(ctx context.Context, req openai.ChatCompletionRequest, temperature *float32, targets []proxyTarget, send func(ctx context.Context, provider *llmProvider, req openai.ChatCompletionRequest) error) (*ProxyRoute, error) {
+	model := req.Model
+	route := &ProxyRoute{RequestedModel: model, Request: req, Temperature: temperature}
+	var lastErr error
+	for _, target := range targets {
+		err := target.Err
+		if err == nil {
+			targetReq := withDefaultParams(req, temperature, target.Params)
+			targetReq.Model = target.ModelID
+			err = send(withTemperature(ctx, requestTemperature(temperature, target.Params)), s.llmProviders[target.ProviderID], targetReq)
+			if err == nil {
+				route.ProviderID = target.ProviderID
+				route.ModelID = target.ModelID
+				route.Alias = target.Alias
+				route.SplitArm = target.SplitArm
+				return route, nil
+			}
+			if !shouldFallback(err) || ctx.Err() != nil {
+				return nil, err
+			}
+		}
+		lastErr = fmt.Errorf("%s: %w", target.Name, err)
+		route.FallbackErrors = append(route.FallbackErrors, lastErr.Error())
+	}
+	// The request is answered like the last model failed, so a spent budget is still a 429
+	earlier := strings.Join(route.FallbackErrors[:len(route.FallbackErrors)-1], "; ")
+	return nil, fmt.Errorf("every model of the fallback chain of %s failed: %s; %w", model, earlier, lastErr)
+}
+
+func (s *Service) GetFallbackChains() ([]*models.FallbackChain, error) {
+	chains := []*models.FallbackChain{}
+	tx := s.Db.Order("model ASC").Find(&chains)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return chains, nil
+}
+
+// SetFallbackChain creates or replaces the fallback chain of the model.
+func (s *Service) SetFallbackChain(input models.FallbackChainCreate) (*models.FallbackChain, error) {
+	model := strings.TrimSpace(input.Model)
+	if model == "" {
+		return nil, errors.New("the model of the fallback chain is required")
+	}
+	fallbacks := parseModelNames(input.Fallbacks, model)
+	if len(fallbacks) == 0 {
+		return nil, errors.New("the fallback chain needs at least one fallback model")
+	}
+
+	chain := &models.FallbackChain{}
+	tx := s.Db.Where("model = ?", model).Limit(1).Find(chain)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	chain.Model = model
+	chain.Fallbacks = fallbacks
+	tx = s.Db.Save(chain)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return chain, nil
+}
+
+func (s *Service) DeleteFallbackChain(id string) error {
+	// The model of a deleted chain can be given a new one
+	tx := s.Db.Unscoped().Where("id = ?", id).Delete(&models.FallbackChain{})
+	return tx.Error
+}
+
+// parseModelNames reads a list of model names, where each entry can also be a comma separated list. The requested
+// model is left out of it.
+func parseModelNames(entries []string, model string) []string {
+	names := []string{}
+	for _, entry := range entries {
+		for _, name := range strings.Split(entry, ",") {
+			if name = strings.TrimSpace(name); name != "" && name != model {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
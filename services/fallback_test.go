@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+// addFailingProvider adds a provider that answers every request with the status.
+func addFailingProvider(s *Service, id string, status int) (*httptest.Server, *int) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":{"message":"provider error"}}`))
+	}))
+	provider := &models.Provider{BaseModel: models.BaseModel{ID: id}, BaseUrl: server.URL, Requests: 100}
+	s.Db.Create(provider)
+	s.llmProviders[id] = newLLMProvider(provider, "key")
+	return server, &calls
+}
+
+func TestProxyFallback(t *testing.T) {
+	s, server := newFakeProxyService(t)
+	defer server.Close()
+	s.Db.Create(&models.Provider{BaseModel: models.BaseModel{ID: "fake"}})
+	s.Db.Create(&models.LLM{BaseModel: models.BaseModel{ID: "model"}, ProviderID: "fake", InputPrice: 1})
+	down, downCalls := addFailingProvider(s, "down", http.StatusServiceUnavailable)
+	defer down.Close()
+	bad, _ := addFailingProvider(s, "bad", http.StatusBadRequest)
+	defer bad.Close()
+
+	_, err := s.SetFallbackChain(models.FallbackChainCreate{Model: "down/model", Fallbacks: []string{"missing/model, fake/model"}})
+	assert.Nil(t, err)
+	chain, err := s.SetFallbackChain(models.FallbackChainCreate{Model: "down/model", Fallbacks: []string{"missing/model", "fake/model", "down/model"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"missing/model", "fake/model"}, []string(chain.Fallbacks), "Expect the chain to be replaced without the model itself")
+	_, err = s.SetFallbackChain(models.FallbackChainCreate{Model: "down/model"})
+	assert.Error(t, err)
+
+	messages := []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}
+	resp, route, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "down/model", Messages: messages}, ProxyOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "Reply Hi", resp.Choices[0].Message.Content)
+	assert.Equal(t, 1, *downCalls)
+	assert.Equal(t, "fake", route.ProviderID)
+	assert.Equal(t, "model", route.ModelID)
+	assert.Equal(t, 2, len(route.FallbackErrors))
+	assert.Contains(t, route.FallbackErrors[0], "down/model: error, status code: 503")
+
+	// The log records the model that answered
+	message := &models.Message{Role: "assistant", Content: "Reply Hi", Metadata: &models.MessageMetadata{InputTokenCount: 1_000_000}}
+	assert.Nil(t, s.AddProxyResponse(route, message))
+	assert.Equal(t, "fake", message.ProviderID)
+	assert.Equal(t, "model", message.LLMID)
+	assert.Equal(t, "down/model", message.Metadata.FallbackFrom)
+	assert.Equal(t, 1.0, message.Metadata.Cost)
+
+	// A model that answers doesn't fall back
+	_, route, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages}, ProxyOptions{})
+	assert.Nil(t, err)
+	assert.False(t, route.FellBack())
+
+	// A bad request would fail at every provider
+	_, err = s.SetFallbackChain(models.FallbackChainCreate{Model: "bad/model", Fallbacks: []string{"fake/model"}})
+	assert.Nil(t, err)
+	_, _, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "bad/model", Messages: messages}, ProxyOptions{})
+	assert.Equal(t, http.StatusBadRequest, errorStatusCode(err))
+
+	// A spent budget falls back too, and is answered with a 429 when nothing is left
+	_, err = s.UpdateProvider("fake", models.ProviderUpdate{BudgetUpdate: models.BudgetUpdate{DailyBudget: &message.Metadata.Cost}})
+	assert.Nil(t, err)
+	_, _, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "down/model", Messages: messages}, ProxyOptions{})
+	assert.ErrorContains(t, err, "every model of the fallback chain of down/model failed")
+	assert.True(t, IsBudgetError(err))
+
+	chains, err := s.GetFallbackChains()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(chains))
+	assert.Nil(t, s.DeleteFallbackChain(chains[0].ID))
+	_, _, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "bad/model", Messages: messages}, ProxyOptions{})
+	assert.Equal(t, http.StatusBadRequest, errorStatusCode(err))
+}
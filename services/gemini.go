@@ -0,0 +1,512 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ProviderTypeGemini is the provider type of the Gemini generateContent API
+const ProviderTypeGemini = "gemini"
+
+// geminiClient translates OpenAI requests to and from the Gemini generateContent API.
+type geminiClient struct {
+	apiKey     string
+	baseURL    string
+	httpClient *http.Client
+}
+
+func newGeminiClient(apiKey, baseURL string) *geminiClient {
+	if baseURL == "" {
+		baseURL = "https://generativelanguage.googleapis.com/v1beta"
+	}
+	return &geminiClient{apiKey: apiKey, baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: &http.Client{}}
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent         `json:"contents"`
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	ToolConfig        *geminiToolConfig       `json:"toolConfig,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	InlineData       *geminiInlineData       `json:"inlineData,omitempty"`
+	FileData         *geminiFileData         `json:"fileData,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+type geminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature      *float32 `json:"temperature,omitempty"`
+	TopP             *float32 `json:"topP,omitempty"`
+	MaxOutputTokens  int      `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	CandidateCount   int      `json:"candidateCount,omitempty"`
+	PresencePenalty  *float32 `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequencyPenalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	ResponseMimeType string   `json:"responseMimeType,omitempty"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+type geminiToolConfig struct {
+	FunctionCallingConfig geminiFunctionCallingConfig `json:"functionCallingConfig"`
+}
+
+type geminiFunctionCallingConfig struct {
+	Mode                 string   `json:"mode"`
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+}
+
+type geminiResponse struct {
+	ResponseID    string            `json:"responseId"`
+	ModelVersion  string            `json:"modelVersion"`
+	Candidates    []geminiCandidate `json:"candidates"`
+	UsageMetadata geminiUsage       `json:"usageMetadata"`
+}
+
+type geminiCandidate struct {
+	Index        int           `json:"index"`
+	Content      geminiContent `json:"content"`
+	FinishReason string        `json:"finishReason"`
+}
+
+type geminiUsage struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+// newGeminiRequest moves the system messages to the system instruction and turns tool calls and results into
+// function parts. Gemini only knows the user and model roles, so tool results are sent by the user.
+func newGeminiRequest(req openai.ChatCompletionRequest) (geminiRequest, error) {
+	request := geminiRequest{}
+	config := geminiGenerationConfig{
+		MaxOutputTokens: req.MaxTokens,
+		StopSequences:   req.Stop,
+		CandidateCount:  req.N,
+		Seed:            req.Seed,
+	}
+	// Left out when zero, like the openai client does
+	if req.Temperature != 0 {
+		config.Temperature = ptr(req.Temperature)
+	}
+	if req.TopP != 0 {
+		config.TopP = ptr(req.TopP)
+	}
+	if req.PresencePenalty != 0 {
+		config.PresencePenalty = ptr(req.PresencePenalty)
+	}
+	if req.FrequencyPenalty != 0 {
+		config.FrequencyPenalty = ptr(req.FrequencyPenalty)
+	}
+	if req.ResponseFormat != nil && req.ResponseFormat.Type == openai.ChatCompletionResponseFormatTypeJSONObject {
+		config.ResponseMimeType = "application/json"
+	}
+	request.GenerationConfig = &config
+
+	// Function responses are matched to their call by name, so remember the name of every call
+	toolNames := map[string]string{}
+	system := []geminiPart{}
+	for _, message := range req.Messages {
+		role := "user"
+		parts := []geminiPart{}
+		switch message.Role {
+		case openai.ChatMessageRoleSystem:
+			system = append(system, geminiPart{Text: message.Content})
+			continue
+		case openai.ChatMessageRoleUser:
+			parts = geminiTextParts(message)
+		case openai.ChatMessageRoleAssistant:
+			role = "model"
+			parts = geminiTextParts(message)
+			for _, toolCall := range message.ToolCalls {
+				toolNames[toolCall.ID] = toolCall.Function.Name
+				args := json.RawMessage(toolCall.Function.Arguments)
+				if !json.Valid(args) {
+					args = json.RawMessage("{}")
+				}
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: toolCall.Function.Name, Args: args}})
+			}
+		case openai.ChatMessageRoleTool:
+			name, ok := toolNames[message.ToolCallID]
+			if !ok {
+				return request, fmt.Errorf("tool call not found for tool message: %s", message.ToolCallID)
+			}
+			parts = append(parts, geminiPart{FunctionResponse: &geminiFunctionResponse{Name: name, Response: newGeminiFunctionResponse(message.Content)}})
+		default:
+			return request, fmt.Errorf("unsupported role for gemini: %s", message.Role)
+		}
+		if len(parts) == 0 {
+			continue
+		}
+
+		last := len(request.Contents) - 1
+		if last >= 0 && request.Contents[last].Role == role {
+			request.Contents[last].Parts = append(request.Contents[last].Parts, parts...)
+			continue
+		}
+		request.Contents = append(request.Contents, geminiContent{Role: role, Parts: parts})
+	}
+	if len(system) > 0 {
+		request.SystemInstruction = &geminiContent{Parts: system}
+	}
+
+	if len(req.Tools) > 0 {
+		tool := geminiTool{}
+		for _, openaiTool := range req.Tools {
+			tool.FunctionDeclarations = append(tool.FunctionDeclarations, geminiFunctionDeclaration{
+				Name:        openaiTool.Function.Name,
+				Description: openaiTool.Function.Description,
+				Parameters:  openaiTool.Function.Parameters,
+			})
+		}
+		request.Tools = []geminiTool{tool}
+	}
+	switch mode, function := readToolChoice(req.ToolChoice); mode {
+	case "auto", "none":
+		request.ToolConfig = &geminiToolConfig{FunctionCallingConfig: geminiFunctionCallingConfig{Mode: strings.ToUpper(mode)}}
+	case "required":
+		request.ToolConfig = &geminiToolConfig{FunctionCallingConfig: geminiFunctionCallingConfig{Mode: "ANY"}}
+	case "function":
+		request.ToolConfig = &geminiToolConfig{FunctionCallingConfig: geminiFunctionCallingConfig{Mode: "ANY", AllowedFunctionNames: []string{function}}}
+	}
+	return request, nil
+}
+
+func geminiTextParts(message openai.ChatCompletionMessage) []geminiPart {
+	if len(message.MultiContent) == 0 {
+		if message.Content == "" {
+			return []geminiPart{}
+		}
+		return []geminiPart{{Text: message.Content}}
+	}
+	parts := []geminiPart{}
+	for _, part := range message.MultiContent {
+		switch {
+		case part.Type == openai.ChatMessagePartTypeText:
+			parts = append(parts, geminiPart{Text: part.Text})
+		case part.ImageURL != nil:
+			if mediaType, data, ok := splitDataURL(part.ImageURL.URL); ok {
+				parts = append(parts, geminiPart{InlineData: &geminiInlineData{MimeType: mediaType, Data: data}})
+			} else {
+				parts = append(parts, geminiPart{FileData: &geminiFileData{FileURI: part.ImageURL.URL}})
+			}
+		}
+	}
+	return parts
+}
+
+// newGeminiFunctionResponse wraps the tool result in an object, which Gemini requires, unless it already is one.
+func newGeminiFunctionResponse(content string) json.RawMessage {
+	object := map[string]any{}
+	if json.Unmarshal([]byte(content), &object) == nil {
+		return json.RawMessage(content)
+	}
+	response, _ := json.Marshal(map[string]string{"content": content})
+	return response
+}
+
+func geminiFinishReason(finishReason string, hasToolCalls bool) openai.FinishReason {
+	switch finishReason {
+	case "STOP":
+		if hasToolCalls {
+			return openai.FinishReasonToolCalls
+		}
+		return openai.FinishReasonStop
+	case "MAX_TOKENS":
+		return openai.FinishReasonLength
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return openai.FinishReasonContentFilter
+	case "":
+		return ""
+	default:
+		return openai.FinishReasonNull
+	}
+}
+
+func newGeminiUsage(usage geminiUsage) openai.Usage {
+	return openai.Usage{
+		PromptTokens:     usage.PromptTokenCount,
+		CompletionTokens: usage.CandidatesTokenCount,
+		TotalTokens:      usage.PromptTokenCount + usage.CandidatesTokenCount,
+	}
+}
+
+// newGeminiMessage reads the text and the function calls of a candidate. Gemini doesn't always give the calls
+// an ID, so one is made up for the tool messages to refer to.
+func newGeminiMessage(content geminiContent) (string, []openai.ToolCall) {
+	text := ""
+	toolCalls := []openai.ToolCall{}
+	for _, part := range content.Parts {
+		text += part.Text
+		if part.FunctionCall == nil {
+			continue
+		}
+		id := part.FunctionCall.ID
+		if id == "" {
+			id = "call_" + uuid.NewString()
+		}
+		args := string(part.FunctionCall.Args)
+		if args == "" {
+			args = "{}"
+		}
+		toolCalls = append(toolCalls, openai.ToolCall{
+			ID:       id,
+			Type:     openai.ToolTypeFunction,
+			Function: openai.FunctionCall{Name: part.FunctionCall.Name, Arguments: args},
+		})
+	}
+	return text, toolCalls
+}
+
+func (c *geminiClient) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	httpReq, err := newJSONRequest(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("x-goog-api-key", c.apiKey)
+	return sendRequest(c.httpClient, httpReq)
+}
+
+// modelPath returns the path of the model, which can be given with or without the "models/" prefix.
+func (c *geminiClient) modelPath(model string) string {
+	return "/models/" + strings.TrimPrefix(model, "models/")
+}
+
+func (c *geminiClient) send(ctx context.Context, req openai.ChatCompletionRequest, method string) (*http.Response, error) {
+	request, err := newGeminiRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	request.GenerationConfig.Temperature = chatTemperature(ctx, req)
+	return c.do(ctx, http.MethodPost, c.modelPath(req.Model)+method, request)
+}
+
+func (c *geminiClient) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	resp, err := c.send(ctx, req, ":generateContent")
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	geminiResp := geminiResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&geminiResp); err != nil {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("failed to decode gemini response: %w", err)
+	}
+
+	choices := make([]openai.ChatCompletionChoice, len(geminiResp.Candidates))
+	for i, candidate := range geminiResp.Candidates {
+		content, toolCalls := newGeminiMessage(candidate.Content)
+		message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content}
+		if len(toolCalls) > 0 {
+			message.ToolCalls = toolCalls
+		}
+		choices[i] = openai.ChatCompletionChoice{
+			Index:        candidate.Index,
+			Message:      message,
+			FinishReason: geminiFinishReason(candidate.FinishReason, len(toolCalls) > 0),
+		}
+	}
+	return openai.ChatCompletionResponse{
+		ID:      geminiResp.ResponseID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   geminiModel(geminiResp.ModelVersion, req.Model),
+		Choices: choices,
+		Usage:   newGeminiUsage(geminiResp.UsageMetadata),
+	}, nil
+}
+
+func geminiModel(modelVersion, requestModel string) string {
+	if modelVersion != "" {
+		return modelVersion
+	}
+	return requestModel
+}
+
+func (c *geminiClient) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (ChatCompletionStream, error) {
+	resp, err := c.send(ctx, req, ":streamGenerateContent?alt=sse")
+	if err != nil {
+		return nil, err
+	}
+	return &geminiStream{body: resp.Body, reader: bufio.NewReader(resp.Body), model: req.Model, created: time.Now().Unix(), toolCalls: map[int]int{}}, nil
+}
+
+// geminiStream turns the server sent events of streamGenerateContent into OpenAI chat completion chunks.
+// Every event is a whole response with the next part of the candidates.
+type geminiStream struct {
+	body    io.ReadCloser
+	reader  *bufio.Reader
+	model   string
+	created int64
+	usage   geminiUsage
+	// toolCalls counts the tool calls of every candidate, to give each call its index
+	toolCalls map[int]int
+}
+
+func (s *geminiStream) Close() {
+	s.body.Close()
+}
+
+// Usage returns the tokens used so far, the output tokens are only complete at the end of the stream.
+func (s *geminiStream) Usage() openai.Usage {
+	return newGeminiUsage(s.usage)
+}
+
+// Recv returns the next chunk, or io.EOF once the response is done.
+func (s *geminiStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	data, err := readEventData(s.reader)
+	if err != nil {
+		return openai.ChatCompletionStreamResponse{}, err
+	}
+	event := geminiResponse{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return openai.ChatCompletionStreamResponse{}, fmt.Errorf("failed to decode gemini event: %w", err)
+	}
+	if event.UsageMetadata != (geminiUsage{}) {
+		s.usage = event.UsageMetadata
+	}
+
+	choices := make([]openai.ChatCompletionStreamChoice, len(event.Candidates))
+	for i, candidate := range event.Candidates {
+		content, toolCalls := newGeminiMessage(candidate.Content)
+		delta := openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant, Content: content}
+		for _, toolCall := range toolCalls {
+			toolCall.Index = ptr(s.toolCalls[candidate.Index])
+			s.toolCalls[candidate.Index]++
+			delta.ToolCalls = append(delta.ToolCalls, toolCall)
+		}
+		choices[i] = openai.ChatCompletionStreamChoice{
+			Index:        candidate.Index,
+			Delta:        delta,
+			FinishReason: geminiFinishReason(candidate.FinishReason, s.toolCalls[candidate.Index] > 0),
+		}
+	}
+	return openai.ChatCompletionStreamResponse{
+		ID:      event.ResponseID,
+		Object:  "chat.completion.chunk",
+		Created: s.created,
+		Model:   geminiModel(event.ModelVersion, s.model),
+		Choices: choices,
+	}, nil
+}
+
+func (c *geminiClient) CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequest) (openai.EmbeddingResponse, error) {
+	inputs, err := embeddingInputs(req.Input)
+	if err != nil {
+		return openai.EmbeddingResponse{}, err
+	}
+	model := "models/" + strings.TrimPrefix(string(req.Model), "models/")
+	type embedRequest struct {
+		Model   string        `json:"model"`
+		Content geminiContent `json:"content"`
+	}
+	body := struct {
+		Requests []embedRequest `json:"requests"`
+	}{}
+	for _, input := range inputs {
+		body.Requests = append(body.Requests, embedRequest{Model: model, Content: geminiContent{Parts: []geminiPart{{Text: input}}}})
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, c.modelPath(model)+":batchEmbedContents", body)
+	if err != nil {
+		return openai.EmbeddingResponse{}, err
+	}
+	defer resp.Body.Close()
+	embeddings := struct {
+		Embeddings []struct {
+			Values []float32 `json:"values"`
+		} `json:"embeddings"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&embeddings); err != nil {
+		return openai.EmbeddingResponse{}, fmt.Errorf("failed to decode gemini embeddings: %w", err)
+	}
+
+	embeddingResp := openai.EmbeddingResponse{Object: "list", Model: req.Model}
+	for i, embedding := range embeddings.Embeddings {
+		embeddingResp.Data = append(embeddingResp.Data, openai.Embedding{Object: "embedding", Embedding: embedding.Values, Index: i})
+	}
+	return embeddingResp, nil
+}
+
+// ListModels returns the IDs of the models without the "models/" prefix, going through every page of the list.
+func (c *geminiClient) ListModels(ctx context.Context) ([]string, error) {
+	ids := []string{}
+	pageToken := ""
+	for {
+		path := "/models?pageSize=1000"
+		if pageToken != "" {
+			path += "&pageToken=" + url.QueryEscape(pageToken)
+		}
+		resp, err := c.do(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		page := struct {
+			Models []struct {
+				Name string `json:"name"`
+			} `json:"models"`
+			NextPageToken string `json:"nextPageToken"`
+		}{}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode gemini models: %w", err)
+		}
+		for _, model := range page.Models {
+			ids = append(ids, strings.TrimPrefix(model.Name, "models/"))
+		}
+		if page.NextPageToken == "" {
+			return ids, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewGeminiRequest(t *testing.T) {
+	request, err := newGeminiRequest(openai.ChatCompletionRequest{
+		Model: "gemini",
+		Messages: []openai.ChatCompletionMessage{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "What's the weather in Paris?"},
+			{Role: "assistant", ToolCalls: []openai.ToolCall{{ID: "call_1", Type: "function", Function: openai.FunctionCall{Name: "weather", Arguments: `{"city":"Paris"}`}}}},
+			{Role: "tool", ToolCallID: "call_1", Content: "Sunny"},
+			{Role: "user", MultiContent: []openai.ChatMessagePart{
+				{Type: openai.ChatMessagePartTypeText, Text: "And this?"},
+				{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{URL: "data:image/png;base64,AAAA"}},
+			}},
+		},
+		Tools:       []openai.Tool{{Type: "function", Function: openai.FunctionDefinition{Name: "weather", Parameters: map[string]any{"type": "object"}}}},
+		ToolChoice:  "required",
+		MaxTokens:   10,
+		Temperature: 0.5,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "Be brief.", request.SystemInstruction.Parts[0].Text)
+	assert.Equal(t, 10, request.GenerationConfig.MaxOutputTokens)
+	assert.Equal(t, float32(0.5), *request.GenerationConfig.Temperature)
+	assert.Equal(t, "ANY", request.ToolConfig.FunctionCallingConfig.Mode)
+	assert.Equal(t, "weather", request.Tools[0].FunctionDeclarations[0].Name)
+
+	assert.Equal(t, 3, len(request.Contents), "Expect the tool result and the next user message to be merged")
+	assert.Equal(t, "model", request.Contents[1].Role)
+	assert.JSONEq(t, `{"city":"Paris"}`, string(request.Contents[1].Parts[0].FunctionCall.Args))
+	assert.Equal(t, "weather", request.Contents[2].Parts[0].FunctionResponse.Name)
+	assert.JSONEq(t, `{"content":"Sunny"}`, string(request.Contents[2].Parts[0].FunctionResponse.Response))
+	assert.Equal(t, &geminiInlineData{MimeType: "image/png", Data: "AAAA"}, request.Contents[2].Parts[2].InlineData)
+
+	_, err = newGeminiRequest(openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: "tool", ToolCallID: "unknown"}}})
+	assert.Error(t, err)
+}
+
+func TestGeminiClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-goog-api-key") != "key" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":{"code":403,"message":"API key not valid","status":"PERMISSION_DENIED"}}`)
+			return
+		}
+		switch {
+		case r.URL.Path == "/models" && r.URL.Query().Get("pageToken") == "":
+			fmt.Fprint(w, `{"models":[{"name":"models/gemini-a"}],"nextPageToken":"next"}`)
+		case r.URL.Path == "/models":
+			fmt.Fprint(w, `{"models":[{"name":"models/embedding-a"}]}`)
+		case r.URL.Path == "/models/gemini-a:generateContent":
+			fmt.Fprint(w, `{"responseId":"resp_1","modelVersion":"gemini-a-001","candidates":[{"content":{"role":"model","parts":[{"text":"Let me check."},{"functionCall":{"name":"weather","args":{"city":"Paris"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":12,"candidatesTokenCount":7,"totalTokenCount":19}}`)
+		case r.URL.Path == "/models/gemini-a:streamGenerateContent":
+			assert.Equal(t, "sse", r.URL.Query().Get("alt"))
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"Hel\"}]}}],\"usageMetadata\":{\"promptTokenCount\":3}}\n\n")
+			fmt.Fprint(w, "data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"lo\"}]},\"finishReason\":\"MAX_TOKENS\"}],\"usageMetadata\":{\"promptTokenCount\":3,\"candidatesTokenCount\":2}}\n\n")
+		case r.URL.Path == "/models/embedding-a:batchEmbedContents":
+			body := struct {
+				Requests []struct {
+					Model string `json:"model"`
+				} `json:"requests"`
+			}{}
+			json.NewDecoder(r.Body).Decode(&body)
+			assert.Equal(t, "models/embedding-a", body.Requests[0].Model)
+			fmt.Fprint(w, `{"embeddings":[{"values":[1,0]},{"values":[0,1]}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":404,"message":"model not found","status":"NOT_FOUND"}}`)
+		}
+	}))
+	defer server.Close()
+	client := newGeminiClient("key", server.URL)
+
+	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:    "gemini-a",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Weather?"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "gemini-a-001", resp.Model)
+	assert.Equal(t, "Let me check.", resp.Choices[0].Message.Content)
+	assert.Equal(t, openai.FinishReasonToolCalls, resp.Choices[0].FinishReason)
+	assert.True(t, strings.HasPrefix(resp.Choices[0].Message.ToolCalls[0].ID, "call_"))
+	assert.JSONEq(t, `{"city":"Paris"}`, resp.Choices[0].Message.ToolCalls[0].Function.Arguments)
+	assert.Equal(t, openai.Usage{PromptTokens: 12, CompletionTokens: 7, TotalTokens: 19}, resp.Usage)
+
+	stream, err := client.CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{
+		Model:    "models/gemini-a",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}},
+	})
+	assert.Nil(t, err)
+	defer stream.Close()
+	content := ""
+	finishReason := openai.FinishReason("")
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.Nil(t, err)
+		content += chunk.Choices[0].Delta.Content
+		finishReason = chunk.Choices[0].FinishReason
+	}
+	assert.Equal(t, "Hello", content)
+	assert.Equal(t, openai.FinishReasonLength, finishReason)
+	assert.Equal(t, 2, stream.(*geminiStream).Usage().CompletionTokens)
+
+	embeddings, err := client.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{Model: "embedding-a", Input: []any{"a", "b"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []float32{0, 1}, embeddings.Data[1].Embedding)
+
+	ids, err := client.ListModels(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"gemini-a", "embedding-a"}, ids)
+
+	_, err = client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: "unknown"})
+	assert.Equal(t, http.StatusNotFound, errorStatusCode(err))
+	assert.ErrorContains(t, err, "model not found")
+
+	_, err = newGeminiClient("wrong", server.URL).ListModels(context.Background())
+	assert.ErrorContains(t, err, "API key not valid")
+}
@@ -0,0 +1,399 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+const (
+	// ImportFormatJSONL is one conversation per line: {"name", "description", "tags", "messages"}
+	ImportFormatJSONL = "jsonl"
+	// ImportFormatOpenAI is the OpenAI fine-tuning format, one {"messages": [...]} per line
+	ImportFormatOpenAI = "openai"
+	// ImportFormatShareGPT is a JSON array, or JSON lines, of {"id", "conversations": [{"from", "value"}]}
+	ImportFormatShareGPT = "sharegpt"
+	// ImportFormatCSV either has a row per message with id, role and content columns,
+	// or a row per test with input and output columns and an optional system column
+	ImportFormatCSV = "csv"
+)
+
+// maxImportLineSize is the longest JSON line that can be imported.
+const maxImportLineSize = 16 * 1024 * 1024
+
+type ImportInput struct {
+	Format string
+	// Name is used to name the tests that don't have a name of their own, usually the file name
+	Name string
+	// Tags are added to every imported test
+	Tags []string
+	Data io.Reader
+}
+
+type ImportResult struct {
+	Created int           `json:"created"`
+	Skipped int           `json:"skipped"`
+	TestIDs []string      `json:"test_ids"`
+	Errors  []ImportError `json:"errors"`
+}
+
+// ImportError is a record that couldn't be imported. Line is the line of the record in the file,
+// or its position when the file is a JSON array.
+type ImportError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+type importRecord struct {
+	line        int
+	name        string
+	description string
+	tags        []string
+	messages    []openai.ChatCompletionMessage
+	tools       []openai.Tool
+	err         error
+}
+
+// ImportFormatFromPath guesses the format of a dataset from its file extension.
+func ImportFormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return ImportFormatCSV
+	case ".json":
+		return ImportFormatShareGPT
+	default:
+		return ImportFormatJSONL
+	}
+}
+
+// ImportTests creates a test for every conversation in the dataset. Records that can't be read or are invalid
+// are reported and the rest are still imported. Tests that were imported before are skipped.
+func (s *Service) ImportTests(input ImportInput) (*ImportResult, error) {
+	var records []importRecord
+	var err error
+	switch input.Format {
+	case ImportFormatJSONL, ImportFormatOpenAI:
+		records, err = readJSONLRecords(input.Data)
+	case ImportFormatShareGPT:
+		records, err = readShareGPTRecords(input.Data)
+	case ImportFormatCSV:
+		records, err = readCSVRecords(input.Data)
+	default:
+		return nil, fmt.Errorf("import format not found: %s", input.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{TestIDs: []string{}, Errors: []ImportError{}}
+	for _, record := range records {
+		if record.err == nil {
+			record.err = validateImportMessages(record.messages)
+		}
+		if record.err != nil {
+			result.Errors = append(result.Errors, ImportError{Line: record.line, Message: record.err.Error()})
+			continue
+		}
+
+		importKey := newImportKey(record.messages)
+		existing := &models.Conversation{}
+		tx := s.Db.Where("import_key = ? AND is_test = ?", importKey, true).Limit(1).Find(existing)
+		if tx.Error != nil {
+			return nil, tx.Error
+		}
+		if tx.RowsAffected > 0 {
+			result.Skipped++
+			continue
+		}
+
+		name := record.name
+		if name == "" {
+			name = fmt.Sprintf("%s #%d", input.Name, record.line)
+		}
+		conversation, err := s.CreateConversation(models.ConversationCreate{
+			Name:        name,
+			Description: record.description,
+			IsTest:      true,
+			Tags:        mergeTags(input.Tags, record.tags),
+			Messages:    record.messages,
+			Tools:       record.tools,
+			ImportKey:   importKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		// The default cosine scorer compares the results with the embeddings of the messages. A test that
+		// couldn't be embedded is removed, so importing the dataset again retries it.
+		if _, ok := s.llmProviders["openai"]; ok {
+			if err := appendMessageEmbeddings(conversation.Messages, s); err != nil {
+				if _, err := s.DeleteConversation(conversation.ID); err != nil {
+					return nil, err
+				}
+				result.Errors = append(result.Errors, ImportError{Line: record.line, Message: fmt.Sprintf("failed to embed the messages: %v", err)})
+				continue
+			}
+		}
+		result.Created++
+		result.TestIDs = append(result.TestIDs, conversation.ID)
+	}
+	return result, nil
+}
+
+// validateImportMessages makes sure the conversation can be tested, it needs an assistant message after the first message.
+// Assistant messages can call tools instead of having content, and the results of the calls are tool messages.
+func validateImportMessages(messages []openai.ChatCompletionMessage) error {
+	if len(messages) == 0 {
+		return fmt.Errorf("no messages")
+	}
+	testable := false
+	for i, message := range messages {
+		switch message.Role {
+		case openai.ChatMessageRoleSystem, openai.ChatMessageRoleUser, openai.ChatMessageRoleAssistant:
+		case openai.ChatMessageRoleTool:
+			if message.ToolCallID == "" {
+				return fmt.Errorf("message %d: tool message without a tool_call_id", i+1)
+			}
+		default:
+			return fmt.Errorf("message %d: unsupported role %q", i+1, message.Role)
+		}
+		if strings.TrimSpace(message.Content) == "" && !(message.Role == openai.ChatMessageRoleAssistant && len(message.ToolCalls) > 0) {
+			return fmt.Errorf("message %d: empty content", i+1)
+		}
+		if message.Role == openai.ChatMessageRoleAssistant && i > 0 {
+			testable = true
+		}
+	}
+	if !testable {
+		return fmt.Errorf("no assistant message to test")
+	}
+	return nil
+}
+
+// newImportKey hashes the roles and contents of the messages, with their tool calls.
+func newImportKey(messages []openai.ChatCompletionMessage) string {
+	hash := sha256.New()
+	for _, message := range messages {
+		content := threadContent(message.Content, message.ToolCalls, message.ToolCallID)
+		// The lengths keep the boundaries between messages unambiguous
+		fmt.Fprintf(hash, "%s:%d:%s\n", message.Role, len(content), content)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// mergeTags adds the tags of the record to the tags of the import without duplicates.
+func mergeTags(tags []string, extra []string) []string {
+	merged := []string{}
+	seen := map[string]bool{}
+	for _, tag := range append(append([]string{}, tags...), extra...) {
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		merged = append(merged, tag)
+	}
+	return merged
+}
+
+type jsonlRecord struct {
+	Name        string                         `json:"name"`
+	Description string                         `json:"description"`
+	Tags        []string                       `json:"tags"`
+	Messages    []openai.ChatCompletionMessage `json:"messages"`
+	Tools       []openai.Tool                  `json:"tools"`
+}
+
+func readJSONLRecords(data io.Reader) ([]importRecord, error) {
+	records := []importRecord{}
+	err := scanLines(data, func(line int, text []byte) {
+		record := jsonlRecord{}
+		if err := json.Unmarshal(text, &record); err != nil {
+			records = append(records, importRecord{line: line, err: fmt.Errorf("invalid JSON: %w", err)})
+			return
+		}
+		records = append(records, importRecord{
+			line:        line,
+			name:        record.Name,
+			description: record.Description,
+			tags:        record.Tags,
+			messages:    record.Messages,
+			tools:       record.Tools,
+		})
+	})
+	return records, err
+}
+
+type shareGPTRecord struct {
+	ID            string `json:"id"`
+	Conversations []struct {
+		From  string `json:"from"`
+		Value string `json:"value"`
+	} `json:"conversations"`
+}
+
+var shareGPTRoles = map[string]string{
+	"system":    openai.ChatMessageRoleSystem,
+	"human":     openai.ChatMessageRoleUser,
+	"user":      openai.ChatMessageRoleUser,
+	"gpt":       openai.ChatMessageRoleAssistant,
+	"chatgpt":   openai.ChatMessageRoleAssistant,
+	"assistant": openai.ChatMessageRoleAssistant,
+}
+
+func readShareGPTRecords(data io.Reader) ([]importRecord, error) {
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	toRecord := func(line int, record shareGPTRecord) importRecord {
+		result := importRecord{line: line, name: record.ID}
+		for _, turn := range record.Conversations {
+			role, ok := shareGPTRoles[strings.ToLower(turn.From)]
+			if !ok {
+				// Left as it is so the validation reports it
+				role = turn.From
+			}
+			result.messages = append(result.messages, openai.ChatCompletionMessage{Role: role, Content: turn.Value})
+		}
+		return result
+	}
+
+	records := []importRecord{}
+	// ShareGPT datasets are usually a single JSON array, but some are split into lines
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '[' {
+		items := []json.RawMessage{}
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		for i, item := range items {
+			record := shareGPTRecord{}
+			if err := json.Unmarshal(item, &record); err != nil {
+				records = append(records, importRecord{line: i + 1, err: fmt.Errorf("invalid JSON: %w", err)})
+				continue
+			}
+			records = append(records, toRecord(i+1, record))
+		}
+		return records, nil
+	}
+
+	err = scanLines(bytes.NewReader(content), func(line int, text []byte) {
+		record := shareGPTRecord{}
+		if err := json.Unmarshal(text, &record); err != nil {
+			records = append(records, importRecord{line: line, err: fmt.Errorf("invalid JSON: %w", err)})
+			return
+		}
+		records = append(records, toRecord(line, record))
+	})
+	return records, err
+}
+
+// scanLines calls the function with every line that isn't blank.
+func scanLines(data io.Reader, fn func(line int, text []byte)) error {
+	scanner := bufio.NewScanner(data)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		fn(line, text)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("line %d: %w", line+1, err)
+	}
+	return nil
+}
+
+func readCSVRecords(data io.Reader) ([]importRecord, error) {
+	reader := csv.NewReader(data)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	value := func(row []string, column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return row[i]
+	}
+	has := func(names ...string) bool {
+		for _, name := range names {
+			if _, ok := columns[name]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	messagePerRow := has("id", "role", "content")
+	if !messagePerRow && !has("input", "output") {
+		return nil, fmt.Errorf("the CSV needs either id, role and content columns or input and output columns")
+	}
+
+	records := []importRecord{}
+	recordIndex := map[string]int{}
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			parseErr := &csv.ParseError{}
+			if errors.As(err, &parseErr) {
+				records = append(records, importRecord{line: parseErr.StartLine, err: parseErr.Err})
+				continue
+			}
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		if !messagePerRow {
+			record := importRecord{
+				line: line,
+				name: value(row, "name"),
+				tags: ParseTags(value(row, "tags")),
+			}
+			if system := value(row, "system"); system != "" {
+				record.messages = append(record.messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: system})
+			}
+			record.messages = append(record.messages,
+				openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: value(row, "input")},
+				openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: value(row, "output")},
+			)
+			records = append(records, record)
+			continue
+		}
+
+		// The rows of a conversation share its id, the record is reported at its first row
+		id := value(row, "id")
+		i, ok := recordIndex[id]
+		if !ok {
+			i = len(records)
+			recordIndex[id] = i
+			records = append(records, importRecord{line: line, name: value(row, "name"), tags: ParseTags(value(row, "tags"))})
+		}
+		records[i].messages = append(records[i].messages, openai.ChatCompletionMessage{
+			Role:    strings.ToLower(strings.TrimSpace(value(row, "role"))),
+			Content: value(row, "content"),
+		})
+	}
+	return records, nil
+}
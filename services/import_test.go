@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestImportTestsJSONL(t *testing.T) {
+	s := New(":memory:", "../.env")
+	data := `{"messages": [{"role": "system", "content": "Be brief."}, {"role": "user", "content": "Hi"}, {"role": "assistant", "content": "Hello"}]}
+{"name": "Refunds", "tags": ["support"], "messages": [{"role": "user", "content": "Refund"}, {"role": "assistant", "content": "Done"}]}
+
+not json
+{"messages": [{"role": "tool", "content": "42"}, {"role": "assistant", "content": "The answer"}]}
+{"messages": [{"role": "user", "content": "Only a question"}]}`
+
+	result, err := s.ImportTests(ImportInput{Format: ImportFormatOpenAI, Name: "dataset", Tags: []string{"imported"}, Data: strings.NewReader(data)})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Created)
+	assert.Equal(t, 3, len(result.Errors))
+	assert.Equal(t, 4, result.Errors[0].Line, "Expect errors to be reported at their line")
+	assert.Contains(t, result.Errors[1].Message, "without a tool_call_id")
+	assert.Contains(t, result.Errors[2].Message, "no assistant message")
+
+	test, err := s.GetConversationWithMessages(result.TestIDs[1], -1)
+	assert.Nil(t, err)
+	assert.True(t, test.IsTest)
+	assert.Equal(t, "Refunds", test.Name)
+	assert.Equal(t, []string{"imported", "support"}, []string(test.Tags))
+	assert.Equal(t, 2, len(test.Messages))
+
+	test, err = s.GetConversation(result.TestIDs[0])
+	assert.Nil(t, err)
+	assert.Equal(t, "dataset #1", test.Name)
+
+	result, err = s.ImportTests(ImportInput{Format: ImportFormatOpenAI, Name: "dataset", Data: strings.NewReader(data)})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.Created)
+	assert.Equal(t, 2, result.Skipped, "Expect importing again to skip the existing tests")
+}
+
+func TestImportTestsToolCalls(t *testing.T) {
+	s := New(":memory:", "../.env")
+	data := `{"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}}}}], "messages": [` +
+		`{"role": "user", "content": "What is the weather in Paris?"}, ` +
+		`{"role": "assistant", "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]}, ` +
+		`{"role": "tool", "tool_call_id": "call_1", "content": "18C"}, ` +
+		`{"role": "assistant", "content": "It is 18C"}]}`
+
+	result, err := s.ImportTests(ImportInput{Format: ImportFormatOpenAI, Data: strings.NewReader(data)})
+	assert.Nil(t, err)
+	assert.Equal(t, []ImportError{}, result.Errors)
+	assert.Equal(t, 1, result.Created)
+
+	// The imported test is replayed with its tool calls, like a logged conversation
+	test, err := s.GetConversationWithMessages(result.TestIDs[0], -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []openai.Tool{weatherTool}, []openai.Tool(test.Tools))
+	replay := newChatCompletionRequest(test.Messages[:3], test.Tools, "model", models.GenerationParams{})
+	assert.Equal(t, []openai.ToolCall{weatherCall}, replay.Messages[1].ToolCalls)
+	assert.Equal(t, "call_1", replay.Messages[2].ToolCallID)
+}
+
+func TestImportTestsShareGPT(t *testing.T) {
+	s := New(":memory:", "../.env")
+	data := `[
+		{"id": "a", "conversations": [{"from": "human", "value": "Hi"}, {"from": "gpt", "value": "Hello"}]},
+		{"id": "b", "conversations": [{"from": "bot", "value": "Hi"}]}
+	]`
+	result, err := s.ImportTests(ImportInput{Format: ImportFormatShareGPT, Data: strings.NewReader(data)})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Created)
+	assert.Equal(t, []ImportError{{Line: 2, Message: `message 1: unsupported role "bot"`}}, result.Errors)
+
+	test, err := s.GetConversationWithMessages(result.TestIDs[0], -1)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", test.Name)
+	assert.Equal(t, "user", test.Messages[0].Role)
+	assert.Equal(t, "assistant", test.Messages[1].Role)
+}
+
+func TestImportTestsCSV(t *testing.T) {
+	s := New(":memory:", "../.env")
+	data := "id,role,content\n1,system,Be brief.\n1,user,Hi\n1,assistant,Hello\n2,user,Bye\n2,assistant,\n"
+	result, err := s.ImportTests(ImportInput{Format: ImportFormatCSV, Name: "rows", Data: strings.NewReader(data)})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Created)
+	assert.Equal(t, []ImportError{{Line: 5, Message: "message 2: empty content"}}, result.Errors)
+
+	data = "input,output,tags\nHi,Hello,\"greeting, short\"\n"
+	result, err = s.ImportTests(ImportInput{Format: ImportFormatCSV, Name: "pairs", Data: strings.NewReader(data)})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Created)
+	test, err := s.GetConversationWithMessages(result.TestIDs[0], -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"greeting", "short"}, []string(test.Tags))
+	assert.Equal(t, 2, len(test.Messages))
+
+	_, err = s.ImportTests(ImportInput{Format: ImportFormatCSV, Data: strings.NewReader("question,answer\n")})
+	assert.Error(t, err)
+	_, err = s.ImportTests(ImportInput{Format: "xml", Data: strings.NewReader("")})
+	assert.Error(t, err)
+}
+
+func TestImportTestsScored(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/embeddings" {
+			request := openai.EmbeddingRequest{}
+			json.NewDecoder(r.Body).Decode(&request)
+			inputs, _ := request.Input.([]any)
+			response := openai.EmbeddingResponse{}
+			for i := range inputs {
+				response.Data = append(response.Data, openai.Embedding{Index: i, Embedding: []float32{1, 0}})
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: "assistant", Content: "Done"}}},
+		})
+	}))
+	defer server.Close()
+	config := openai.DefaultConfig("key")
+	config.BaseURL = server.URL
+
+	s := New(":memory:", "../.env")
+	provider := &models.Provider{BaseModel: models.BaseModel{ID: "fake"}, Requests: 100}
+	s.llmProviders = map[string]*llmProvider{
+		"fake":   {Provider: provider, client: openai.NewClientWithConfig(config)},
+		"openai": {Provider: provider, client: openai.NewClientWithConfig(config)},
+	}
+	_, err := s.CreateLLM(models.LLMCreate{ID: "candidate-model", ProviderID: "fake"})
+	assert.Nil(t, err)
+
+	data := `{"messages": [{"role": "user", "content": "Refund"}, {"role": "assistant", "content": "Done"}]}`
+	result, err := s.ImportTests(ImportInput{Format: ImportFormatOpenAI, Data: strings.NewReader(data)})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Created)
+
+	conversation := &models.Conversation{BaseModel: models.BaseModel{ID: result.TestIDs[0]}}
+	conversation.TestModels = []models.TestModels{{Provider: "fake", Model: "candidate-model"}}
+	tx := s.Db.Model(conversation).Update("test_models", conversation.TestModels)
+	assert.Nil(t, tx.Error)
+
+	// The imported messages were embedded, so the default cosine scorer can score the results
+	testRun, err := s.ExecuteTestWorkflow(ExecuteTestInput{Context: context.Background(), ConversationID: conversation.ID, RunCount: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, testRun.ErrorCount)
+	assert.Equal(t, 1, testRun.Models[0].ScoredCount)
+	assert.Equal(t, 100.0, testRun.Models[0].Score)
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+const defaultJudgeRubric = `You are grading the answer of an AI assistant in a conversation.
+Compare the candidate answer to the reference answer and judge how well it responds to the last message of the conversation.
+Consider correctness, helpfulness, tone and whether it follows the same intent as the reference answer.
+Respond only with JSON in the format {"score": <integer from 0 to 10>, "rationale": "<one or two sentences>"}.`
+
+// llmJudgeScorer asks a judge model to grade the candidate using a rubric prompt.
+type llmJudgeScorer struct {
+	s        *Service
+	provider *llmProvider
+	model    string
+	rubric   string
+}
+
+func newLLMJudgeScorer(s *Service, conversation *models.Conversation) (Scorer, error) {
+	if conversation.JudgeModel == "" {
+		return nil, fmt.Errorf("llm_judge scorer requires a judge model")
+	}
+	modelID, providerID, err := s.GetModel(conversation.JudgeModel)
+	if err != nil {
+		return nil, err
+	}
+	provider, ok := s.llmProviders[providerID]
+	if !ok {
+		return nil, fmt.Errorf("provider not found: %s", providerID)
+	}
+
+	rubric := defaultJudgeRubric
+	if conversation.JudgePromptID != "" {
+		prompt, err := s.GetPrompt(conversation.JudgePromptID)
+		if err != nil {
+			return nil, fmt.Errorf("rubric prompt not found: %w", err)
+		}
+		rubric = prompt.Content
+	}
+
+	return llmJudgeScorer{s: s, provider: provider, model: modelID, rubric: rubric}, nil
+}
+
+func (llmJudgeScorer) Name() string { return "llm_judge" }
+
+func (j llmJudgeScorer) Score(ctx context.Context, input ScoreInput) (ScoreResult, error) {
+	// Wait for permission before making the request
+	if j.s.limiter != nil {
+		if err := j.s.limiter.GetLimiter(j.provider.Provider).Wait(ctx); err != nil {
+			return ScoreResult{}, fmt.Errorf("rate limiter wait error: %w", err)
+		}
+	}
+	providerID := j.provider.Provider.ID
+	// During a run the budget also counts the calls of the run that aren't saved yet
+	checkBudget := j.s.checkProviderBudget
+	if input.budget != nil {
+		checkBudget = input.budget.check
+	}
+	if err := checkBudget(providerID); err != nil {
+		return ScoreResult{}, err
+	}
+
+	request := openai.ChatCompletionRequest{
+		Model: j.model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: j.rubric},
+			{Role: openai.ChatMessageRoleUser, Content: formatJudgeInput(input)},
+		},
+	}
+	// A zero temperature for reproducible scores
+	resp, err := j.provider.createChatCompletion(withTemperature(ctx, ptr(float32(0))), request)
+	if err != nil {
+		return ScoreResult{}, fmt.Errorf("failed to get judge response: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return ScoreResult{}, fmt.Errorf("judge returned no choices")
+	}
+	content := resp.Choices[0].Message.Content
+
+	// The judge is paid for even when its response can't be read
+	usage, _ := EstimateUsage(request, content, resp.Usage)
+	cost := j.s.getLLMPrice(providerID, j.model).Cost(usage.PromptTokens, usage.CompletionTokens)
+	if input.budget != nil {
+		input.budget.add(providerID, cost)
+	}
+
+	result, err := parseJudgeResponse(content)
+	result.Cost = cost
+	return result, err
+}
+
+// formatJudgeInput writes out the conversation, the reference and the candidate for the judge.
+func formatJudgeInput(input ScoreInput) string {
+	builder := strings.Builder{}
+	builder.WriteString("<conversation>\n")
+	for _, message := range input.History {
+		builder.WriteString(strings.ToUpper(message.Role) + ": " + message.Content + "\n")
+	}
+	builder.WriteString("</conversation>\n\n<reference_answer>\n")
+	builder.WriteString(input.Reference.Content)
+	builder.WriteString("\n</reference_answer>\n\n<candidate_answer>\n")
+	builder.WriteString(input.Candidate.Content)
+	builder.WriteString("\n</candidate_answer>")
+	return builder.String()
+}
+
+var judgeScorePattern = regexp.MustCompile(`(?i)score\W*([0-9]+(?:\.[0-9]+)?)`)
+
+// parseJudgeResponse reads the judge output, either as the requested JSON or as text containing "score: N".
+// Rubrics score on a 0-10 scale, which is normalized to 0-1.
+func parseJudgeResponse(content string) (ScoreResult, error) {
+	judgement := struct {
+		Score     float64 `json:"score"`
+		Rationale string  `json:"rationale"`
+	}{}
+	err := json.Unmarshal([]byte(extractJSON(content)), &judgement)
+	if err != nil {
+		match := judgeScorePattern.FindStringSubmatch(content)
+		if match == nil {
+			return ScoreResult{}, fmt.Errorf("could not find a score in the judge response: %s", content)
+		}
+		judgement.Score, _ = strconv.ParseFloat(match[1], 64)
+		judgement.Rationale = strings.TrimSpace(content)
+	}
+
+	score := max(0, min(1, judgement.Score/10))
+	return ScoreResult{Score: score, Rationale: judgement.Rationale}, nil
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestParseJudgeResponse(t *testing.T) {
+	result, err := parseJudgeResponse(`{"score": 7, "rationale": "Mostly correct"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.7, result.Score)
+	assert.Equal(t, "Mostly correct", result.Rationale)
+
+	result, err = parseJudgeResponse("The answer misses the point.\nScore: 2")
+	assert.Nil(t, err)
+	assert.Equal(t, 0.2, result.Score)
+	assert.Contains(t, result.Rationale, "misses the point")
+
+	result, err = parseJudgeResponse(`{"score": 15}`)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, result.Score, "Expect the score to be capped at 1")
+
+	_, err = parseJudgeResponse("I can't grade this")
+	assert.Error(t, err)
+}
+
+func TestLLMJudgeScorer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		request := openai.ChatCompletionRequest{}
+		json.Unmarshal(body, &request)
+		fields := map[string]json.RawMessage{}
+		json.Unmarshal(body, &fields)
+		assert.Equal(t, "judge-model", request.Model)
+		assert.Equal(t, json.RawMessage("0"), fields["temperature"], "Expect a zero temperature to be sent")
+		assert.Equal(t, "Grade it", request.Messages[0].Content, "Expect the rubric to be the system prompt")
+		assert.True(t, strings.Contains(request.Messages[1].Content, "USER: What is 2+2?"), "Expect the history to be sent to the judge")
+		assert.True(t, strings.Contains(request.Messages[1].Content, "<candidate_answer>\n5\n"))
+
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{
+				{Message: openai.ChatCompletionMessage{Role: "assistant", Content: `{"score": 1, "rationale": "2+2 is 4, not 5"}`}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	s := New(":memory:", "../.env")
+	provider := &models.Provider{BaseModel: models.BaseModel{ID: "judge"}, BaseUrl: server.URL, Requests: 100, Budget: models.Budget{DailyBudget: 1}}
+	s.Db.Create(provider)
+	s.Db.Create(&models.LLM{BaseModel: models.BaseModel{ID: "judge-model"}, ProviderID: "judge", InputPrice: 1000})
+	scorer := llmJudgeScorer{
+		s:        s,
+		provider: newLLMProvider(provider, "key"),
+		model:    "judge-model",
+		rubric:   "Grade it",
+	}
+	input := ScoreInput{
+		History:   []*models.Message{{Role: "user", Content: "What is 2+2?"}},
+		Reference: &models.Message{Role: "assistant", Content: "4"},
+		Candidate: &models.Message{Role: "assistant", Content: "5"},
+		budget:    s.newTestBudget("run-1"),
+	}
+
+	result, err := scorer.Score(context.Background(), input)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.1, result.Score)
+	assert.Equal(t, "2+2 is 4, not 5", result.Rationale)
+	assert.Greater(t, result.Cost, 0.0, "Expect the judge call to be priced")
+
+	// The judge calls count towards the budget of its provider
+	input.budget.add("judge", 1-result.Cost)
+	_, err = scorer.Score(context.Background(), input)
+	assert.True(t, IsBudgetError(err), "Expect the judge not to be called once its provider's budget is spent")
+}
+
+func TestLLMJudgeScoresDuringRuns(t *testing.T) {
+	judgeCalls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEqual(t, "/embeddings", r.URL.Path, "Expect the responses to be embedded only for the cosine scorer")
+		request := openai.ChatCompletionRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		content := "5"
+		if request.Model == "judge-model" {
+			judgeCalls++
+			content = `{"score": 8, "rationale": "Close enough"}`
+		}
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: "assistant", Content: content}}},
+		})
+	}))
+	defer server.Close()
+	s := New(":memory:", "../.env")
+	provider := &models.Provider{BaseModel: models.BaseModel{ID: "fake"}, BaseUrl: server.URL, Requests: 100}
+	s.Db.Create(provider)
+	s.Db.Create(&models.LLM{BaseModel: models.BaseModel{ID: "judge-model"}, ProviderID: "fake", InputPrice: 1})
+	s.llmProviders = map[string]*llmProvider{"fake": newLLMProvider(provider, "key")}
+
+	conversation, err := s.CreateConversation(models.ConversationCreate{Name: "math", IsTest: true, Messages: []openai.ChatCompletionMessage{
+		{Role: "user", Content: "What is 2+2?"},
+		{Role: "assistant", Content: "4"},
+	}})
+	assert.Nil(t, err)
+	conversation.TestModels = []models.TestModels{{Provider: "fake", Model: "candidate-model"}}
+	tx := s.Db.Model(conversation).Update("test_models", conversation.TestModels)
+	assert.Nil(t, tx.Error)
+	_, err = s.SetTestScorer(conversation.ID, models.ScorerUpdate{Scorer: "llm_judge", JudgeModel: "fake/judge-model"})
+	assert.Nil(t, err)
+
+	testRun, err := s.ExecuteTestWorkflow(ExecuteTestInput{Context: context.Background(), ConversationID: conversation.ID, RunCount: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 80.0, testRun.Score)
+	assert.Equal(t, 1, judgeCalls)
+	assert.Greater(t, testRun.Messages[0].Metadata.Cost, 0.0, "Expect the judge call to be part of the cost of the result")
+
+	// Loading the test shows the stored score without asking the judge again
+	for range 2 {
+		test, err := s.GetTest(conversation.ID, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 80.0, test.Messages[1].TestMessages[0].Score)
+	}
+	assert.Equal(t, 1, judgeCalls)
+
+	// A result the judge didn't score is left unscored, instead of being scored on every load
+	tx = s.Db.Model(&models.MessageMetadata{}).Where("message_id = ?", testRun.Messages[0].ID).Update("scorer", "")
+	assert.Nil(t, tx.Error)
+	test, err := s.GetTest(conversation.ID, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, test.Messages[1].TestMessages[0].Score)
+	assert.Equal(t, 1, judgeCalls)
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/y2a-labs/evaluate/models"
+	"gorm.io/gorm/clause"
 )
 
 func (s *Service) GetLLM(id string) (*models.LLM, error) {
@@ -60,14 +61,21 @@ func (s *Service) GetAllLLMs() (*[]models.LLM, error) {
 }
 
 func (s *Service) UpdateLLM(id string, input models.LLMUpdate) (*models.LLM, error) {
-	lLM := &models.LLM{BaseModel: models.BaseModel{ID: id}}
-	tx := s.Db.First(lLM)
+	lLM := &models.LLM{}
+	query := s.Db.Where("id = ?", id)
+	// The ID of a model is its key, so a model belongs to the first provider it was saved at.
+	// The provider makes sure the prices aren't set on the model of another provider.
+	if input.ProviderID != "" {
+		query = query.Where("provider_id = ?", input.ProviderID)
+	}
+	tx := query.First(lLM)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
 	// Apply the updates to the model
-	// lLM.Field = input.Field
-	tx = s.Db.Updates(lLM)
+	lLM.InputPrice = input.InputPrice
+	lLM.OutputPrice = input.OutputPrice
+	tx = s.Db.Model(lLM).Select("input_price", "output_price").Updates(lLM)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
@@ -76,27 +84,36 @@ func (s *Service) UpdateLLM(id string, input models.LLMUpdate) (*models.LLM, err
 
 func (s *Service) PullLLMsFromProvider(providerId string) ([]*models.LLM, error) {
 	// Get the list of models from the provider
-	list, err := s.llmProviders[providerId].client.ListModels(context.Background())
+	modelIDs, err := s.llmProviders[providerId].listModels(context.Background())
 	if err != nil {
 		return nil, fmt.Errorf("no models found for provider: %s", providerId)
 	}
 
 	// Turn it into a list of models.llm
-	llms := make([]*models.LLM, len(list.Models))
-	for i, model := range list.Models {
+	llms := make([]*models.LLM, len(modelIDs))
+	for i, modelID := range modelIDs {
 		llms[i] = &models.LLM{
-			BaseModel: models.BaseModel{ID: model.ID},
+			BaseModel: models.BaseModel{ID: modelID},
 			ProviderID: providerId,
 		}
 	}
 
-	// Saves them to the database if they don't exist already
-	tx := s.Db.Save(llms)
+	// Saves them to the database if they don't exist already, the saved ones keep their provider and prices
+	tx := s.Db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoNothing: true,
+	}).Create(llms)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
 
-	return llms, nil
+	// Return the models as they are stored, with the provider they were first saved at
+	stored := []*models.LLM{}
+	tx = s.Db.Where("id IN ?", modelIDs).Order("id ASC").Find(&stored)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return stored, nil
 }
 
 func (s *Service) DeleteLLM(id string) (*models.LLM, error) {
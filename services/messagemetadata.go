@@ -96,4 +96,27 @@ type MessageMetadataManager interface {
 	GetAllMessageMetadatas() ([]*models.MessageMetadata, error)
 	UpdateMessageMetadata(id string, input models.MessageMetadataUpdate) (*models.MessageMetadata, error)
 	DeleteMessageMetadata(id string) (any, error)
-}
\ No newline at end of file
+}
+// SetMessagesMetadata loads the metadata of the messages, like the fallback that answered a logged request.
+func (s *Service) SetMessagesMetadata(messages []*models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	metadata := []*models.MessageMetadata{}
+	tx := s.Db.Where("message_id IN ?", ids).Find(&metadata)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	byMessageID := map[string]*models.MessageMetadata{}
+	for _, m := range metadata {
+		byMessageID[m.MessageID] = m
+	}
+	for _, message := range messages {
+		message.Metadata = byMessageID[message.ID]
+	}
+	return nil
+}
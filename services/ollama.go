@@ -0,0 +1,346 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ProviderTypeOllama is the provider type of the native Ollama API
+const ProviderTypeOllama = "ollama"
+
+// ollamaClient translates OpenAI requests to and from the native Ollama API. The base url is the address of
+// the Ollama server, without the /api path.
+type ollamaClient struct {
+	apiKey     string
+	baseURL    string
+	httpClient *http.Client
+}
+
+func newOllamaClient(apiKey, baseURL string) *ollamaClient {
+	if baseURL == "" {
+		baseURL = "http://localhost:11434"
+	}
+	return &ollamaClient{apiKey: apiKey, baseURL: strings.TrimSuffix(baseURL, "/"), httpClient: &http.Client{}}
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []openai.Tool   `json:"tools,omitempty"`
+	Format   string          `json:"format,omitempty"`
+	Options  map[string]any  `json:"options,omitempty"`
+	// Stream is always sent, Ollama streams by default
+	Stream bool `json:"stream"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaResponse struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	// Error is set when the stream fails after it started
+	Error string `json:"error"`
+}
+
+// newOllamaRequest moves the generation parameters to the options and the images of a message to its images.
+// Ollama only accepts base64 images, so images have to be sent as data URLs.
+func newOllamaRequest(req openai.ChatCompletionRequest) (ollamaRequest, error) {
+	request := ollamaRequest{
+		Model:   req.Model,
+		Tools:   req.Tools,
+		Stream:  req.Stream,
+		Options: map[string]any{},
+	}
+	if req.ResponseFormat != nil && req.ResponseFormat.Type == openai.ChatCompletionResponseFormatTypeJSONObject {
+		request.Format = "json"
+	}
+	// Left out when zero, like the openai client does
+	if req.Temperature != 0 {
+		request.Options["temperature"] = req.Temperature
+	}
+	if req.TopP != 0 {
+		request.Options["top_p"] = req.TopP
+	}
+	if req.MaxTokens != 0 {
+		request.Options["num_predict"] = req.MaxTokens
+	}
+	if len(req.Stop) > 0 {
+		request.Options["stop"] = req.Stop
+	}
+	if req.Seed != nil {
+		request.Options["seed"] = *req.Seed
+	}
+	if req.PresencePenalty != 0 {
+		request.Options["presence_penalty"] = req.PresencePenalty
+	}
+	if req.FrequencyPenalty != 0 {
+		request.Options["frequency_penalty"] = req.FrequencyPenalty
+	}
+
+	for _, message := range req.Messages {
+		switch message.Role {
+		case openai.ChatMessageRoleSystem, openai.ChatMessageRoleUser, openai.ChatMessageRoleAssistant, openai.ChatMessageRoleTool:
+		default:
+			return request, fmt.Errorf("unsupported role for ollama: %s", message.Role)
+		}
+		ollamaMsg := ollamaMessage{Role: message.Role, Content: message.Content}
+		for _, part := range message.MultiContent {
+			switch {
+			case part.Type == openai.ChatMessagePartTypeText:
+				ollamaMsg.Content += part.Text
+			case part.ImageURL != nil:
+				_, data, ok := splitDataURL(part.ImageURL.URL)
+				if !ok {
+					return request, fmt.Errorf("ollama only accepts images as base64 data urls")
+				}
+				ollamaMsg.Images = append(ollamaMsg.Images, data)
+			}
+		}
+		for _, toolCall := range message.ToolCalls {
+			ollamaCall := ollamaToolCall{}
+			ollamaCall.Function.Name = toolCall.Function.Name
+			ollamaCall.Function.Arguments = json.RawMessage(toolCall.Function.Arguments)
+			if !json.Valid(ollamaCall.Function.Arguments) {
+				ollamaCall.Function.Arguments = json.RawMessage("{}")
+			}
+			ollamaMsg.ToolCalls = append(ollamaMsg.ToolCalls, ollamaCall)
+		}
+		request.Messages = append(request.Messages, ollamaMsg)
+	}
+	return request, nil
+}
+
+func ollamaFinishReason(doneReason string, hasToolCalls bool) openai.FinishReason {
+	if hasToolCalls {
+		return openai.FinishReasonToolCalls
+	}
+	switch doneReason {
+	case "length":
+		return openai.FinishReasonLength
+	default:
+		return openai.FinishReasonStop
+	}
+}
+
+func newOllamaUsage(resp ollamaResponse) openai.Usage {
+	return openai.Usage{
+		PromptTokens:     resp.PromptEvalCount,
+		CompletionTokens: resp.EvalCount,
+		TotalTokens:      resp.PromptEvalCount + resp.EvalCount,
+	}
+}
+
+// newOllamaToolCalls gives the tool calls an ID, which Ollama doesn't, for the tool messages to refer to.
+func newOllamaToolCalls(toolCalls []ollamaToolCall) []openai.ToolCall {
+	openaiCalls := make([]openai.ToolCall, len(toolCalls))
+	for i, toolCall := range toolCalls {
+		openaiCalls[i] = openai.ToolCall{
+			ID:       "call_" + uuid.NewString(),
+			Type:     openai.ToolTypeFunction,
+			Function: openai.FunctionCall{Name: toolCall.Function.Name, Arguments: string(toolCall.Function.Arguments)},
+		}
+	}
+	return openaiCalls
+}
+
+func (c *ollamaClient) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	httpReq, err := newJSONRequest(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	// Ollama doesn't need a key, but it can be behind a proxy that does
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	return sendRequest(c.httpClient, httpReq)
+}
+
+func (c *ollamaClient) send(ctx context.Context, req openai.ChatCompletionRequest) (*http.Response, error) {
+	request, err := newOllamaRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if temperature := chatTemperature(ctx, req); temperature != nil {
+		request.Options["temperature"] = *temperature
+	}
+	return c.do(ctx, http.MethodPost, "/api/chat", request)
+}
+
+func (c *ollamaClient) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	req.Stream = false
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	ollamaResp := ollamaResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("failed to decode ollama response: %w", err)
+	}
+
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: ollamaResp.Message.Content}
+	if len(ollamaResp.Message.ToolCalls) > 0 {
+		message.ToolCalls = newOllamaToolCalls(ollamaResp.Message.ToolCalls)
+	}
+	return openai.ChatCompletionResponse{
+		ID:      "chatcmpl-" + uuid.NewString(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   ollamaResp.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      message,
+			FinishReason: ollamaFinishReason(ollamaResp.DoneReason, len(message.ToolCalls) > 0),
+		}},
+		Usage: newOllamaUsage(ollamaResp),
+	}, nil
+}
+
+func (c *ollamaClient) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (ChatCompletionStream, error) {
+	req.Stream = true
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &ollamaStream{body: resp.Body, reader: bufio.NewReader(resp.Body), id: "chatcmpl-" + uuid.NewString(), created: time.Now().Unix()}, nil
+}
+
+// ollamaStream turns the JSON lines that Ollama streams into OpenAI chat completion chunks.
+type ollamaStream struct {
+	body      io.ReadCloser
+	reader    *bufio.Reader
+	id        string
+	created   int64
+	usage     openai.Usage
+	toolCalls int
+	done      bool
+}
+
+func (s *ollamaStream) Close() {
+	s.body.Close()
+}
+
+// Usage returns the tokens used, which are only known at the end of the stream.
+func (s *ollamaStream) Usage() openai.Usage {
+	return s.usage
+}
+
+// Recv returns the next chunk, or io.EOF once the response is done.
+func (s *ollamaStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if s.done {
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	line := []byte{}
+	for len(line) == 0 {
+		var err error
+		line, err = s.reader.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(bytes.TrimSpace(line)) == 0) {
+			return openai.ChatCompletionStreamResponse{}, err
+		}
+		line = bytes.TrimSpace(line)
+	}
+	chunk := ollamaResponse{}
+	if err := json.Unmarshal(line, &chunk); err != nil {
+		return openai.ChatCompletionStreamResponse{}, fmt.Errorf("failed to decode ollama chunk: %w", err)
+	}
+	if chunk.Error != "" {
+		return openai.ChatCompletionStreamResponse{}, &openai.APIError{Message: chunk.Error}
+	}
+
+	delta := openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant, Content: chunk.Message.Content}
+	for _, toolCall := range newOllamaToolCalls(chunk.Message.ToolCalls) {
+		toolCall.Index = ptr(s.toolCalls)
+		s.toolCalls++
+		delta.ToolCalls = append(delta.ToolCalls, toolCall)
+	}
+	finishReason := openai.FinishReason("")
+	if chunk.Done {
+		s.done = true
+		s.usage = newOllamaUsage(chunk)
+		finishReason = ollamaFinishReason(chunk.DoneReason, s.toolCalls > 0)
+	}
+	return openai.ChatCompletionStreamResponse{
+		ID:      s.id,
+		Object:  "chat.completion.chunk",
+		Created: s.created,
+		Model:   chunk.Model,
+		Choices: []openai.ChatCompletionStreamChoice{{Delta: delta, FinishReason: finishReason}},
+	}, nil
+}
+
+func (c *ollamaClient) CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequest) (openai.EmbeddingResponse, error) {
+	inputs, err := embeddingInputs(req.Input)
+	if err != nil {
+		return openai.EmbeddingResponse{}, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/api/embed", map[string]any{"model": req.Model, "input": inputs})
+	if err != nil {
+		return openai.EmbeddingResponse{}, err
+	}
+	defer resp.Body.Close()
+	embeddings := struct {
+		Embeddings      [][]float32 `json:"embeddings"`
+		PromptEvalCount int         `json:"prompt_eval_count"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&embeddings); err != nil {
+		return openai.EmbeddingResponse{}, fmt.Errorf("failed to decode ollama embeddings: %w", err)
+	}
+
+	embeddingResp := openai.EmbeddingResponse{
+		Object: "list",
+		Model:  req.Model,
+		Usage:  openai.Usage{PromptTokens: embeddings.PromptEvalCount, TotalTokens: embeddings.PromptEvalCount},
+	}
+	for i, embedding := range embeddings.Embeddings {
+		embeddingResp.Data = append(embeddingResp.Data, openai.Embedding{Object: "embedding", Embedding: embedding, Index: i})
+	}
+	return embeddingResp, nil
+}
+
+// ListModels returns the models that have been pulled to the Ollama server.
+func (c *ollamaClient) ListModels(ctx context.Context) ([]string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	tags := struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to decode ollama models: %w", err)
+	}
+	ids := make([]string, len(tags.Models))
+	for i, model := range tags.Models {
+		ids[i] = model.Name
+	}
+	return ids, nil
+}
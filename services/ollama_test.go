@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestNewOllamaRequest(t *testing.T) {
+	request, err := newOllamaRequest(openai.ChatCompletionRequest{
+		Model: "llama",
+		Messages: []openai.ChatCompletionMessage{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", MultiContent: []openai.ChatMessagePart{
+				{Type: openai.ChatMessagePartTypeText, Text: "What is this?"},
+				{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{URL: "data:image/png;base64,AAAA"}},
+			}},
+			{Role: "assistant", ToolCalls: []openai.ToolCall{{ID: "call_1", Function: openai.FunctionCall{Name: "lookup", Arguments: `{"q":"x"}`}}}},
+		},
+		MaxTokens:      10,
+		Stop:           []string{"END"},
+		ResponseFormat: &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "json", request.Format)
+	assert.Equal(t, map[string]any{"num_predict": 10, "stop": []string{"END"}}, request.Options)
+	assert.Equal(t, "What is this?", request.Messages[1].Content)
+	assert.Equal(t, []string{"AAAA"}, request.Messages[1].Images)
+	assert.JSONEq(t, `{"q":"x"}`, string(request.Messages[2].ToolCalls[0].Function.Arguments))
+
+	_, err = newOllamaRequest(openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: "user", MultiContent: []openai.ChatMessagePart{
+		{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{URL: "https://example.com/a.png"}},
+	}}}})
+	assert.Error(t, err, "Expect image urls to be refused")
+}
+
+func newFakeOllamaServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			fmt.Fprint(w, `{"models":[{"name":"llama:latest"},{"name":"nomic-embed-text:latest"}]}`)
+		case "/api/embed":
+			fmt.Fprint(w, `{"model":"nomic-embed-text","embeddings":[[1,0]],"prompt_eval_count":2}`)
+		case "/api/chat":
+			request := ollamaRequest{}
+			json.NewDecoder(r.Body).Decode(&request)
+			if request.Model != "llama" {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprintf(w, `{"error":"model \"%s\" not found, try pulling it first"}`, request.Model)
+				return
+			}
+			if !request.Stream {
+				fmt.Fprint(w, `{"model":"llama","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"lookup","arguments":{"q":"x"}}}]},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":7}`)
+				return
+			}
+			fmt.Fprint(w, `{"model":"llama","message":{"role":"assistant","content":"Hel"},"done":false}`+"\n")
+			fmt.Fprint(w, `{"model":"llama","message":{"role":"assistant","content":"lo"},"done":false}`+"\n")
+			fmt.Fprint(w, `{"model":"llama","message":{"role":"assistant","content":""},"done":true,"done_reason":"length","prompt_eval_count":3,"eval_count":2}`+"\n")
+		}
+	}))
+}
+
+func TestOllamaClient(t *testing.T) {
+	server := newFakeOllamaServer(t)
+	defer server.Close()
+	client := newOllamaClient("", server.URL)
+
+	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:    "llama",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Look up x"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, openai.FinishReasonToolCalls, resp.Choices[0].FinishReason)
+	assert.Equal(t, "lookup", resp.Choices[0].Message.ToolCalls[0].Function.Name)
+	assert.JSONEq(t, `{"q":"x"}`, resp.Choices[0].Message.ToolCalls[0].Function.Arguments)
+	assert.Equal(t, openai.Usage{PromptTokens: 12, CompletionTokens: 7, TotalTokens: 19}, resp.Usage)
+
+	stream, err := client.CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{
+		Model:    "llama",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}},
+	})
+	assert.Nil(t, err)
+	defer stream.Close()
+	content := ""
+	finishReason := openai.FinishReason("")
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.Nil(t, err)
+		content += chunk.Choices[0].Delta.Content
+		finishReason = chunk.Choices[0].FinishReason
+	}
+	assert.Equal(t, "Hello", content)
+	assert.Equal(t, openai.FinishReasonLength, finishReason)
+	assert.Equal(t, openai.Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5}, stream.(*ollamaStream).Usage())
+
+	embeddings, err := client.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{Model: "nomic-embed-text", Input: "a"})
+	assert.Nil(t, err)
+	assert.Equal(t, []float32{1, 0}, embeddings.Data[0].Embedding)
+
+	_, err = client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: "unknown"})
+	assert.Equal(t, http.StatusNotFound, errorStatusCode(err))
+	assert.ErrorContains(t, err, "try pulling it first")
+}
+
+func TestPullLLMsFromOllama(t *testing.T) {
+	server := newFakeOllamaServer(t)
+	defer server.Close()
+
+	s := New(":memory:", "../.env")
+	provider := &models.Provider{BaseModel: models.BaseModel{ID: "ollama"}, Type: ProviderTypeOllama, BaseUrl: server.URL, Requests: 100}
+	s.Db.Create(provider)
+	s.llmProviders["ollama"] = newLLMProvider(provider, "")
+	s.Db.Create(&models.LLM{BaseModel: models.BaseModel{ID: "llama:latest"}, ProviderID: "other", InputPrice: 1})
+
+	llms, err := s.PullLLMsFromProvider("ollama")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(llms))
+	assert.Equal(t, "llama:latest", llms[0].ID)
+	assert.Equal(t, "other", llms[0].ProviderID, "Expect the models to be returned as they are stored")
+
+	// A model saved at another provider stays there with its prices
+	saved := &models.LLM{}
+	assert.Nil(t, s.Db.Where("id = ?", "llama:latest").First(saved).Error)
+	assert.Equal(t, "other", saved.ProviderID)
+	assert.Equal(t, 1.0, saved.InputPrice)
+	_, err = s.UpdateLLM("llama:latest", models.LLMUpdate{ProviderID: "ollama", InputPrice: 2})
+	assert.Error(t, err)
+
+	resp, err := s.ProxyOpenaiEmbedding(context.Background(), openai.EmbeddingRequest{Model: "ollama/nomic-embed-text", Input: []string{"a"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(resp.Data))
+}
@@ -15,7 +15,11 @@ func (s *Service) GetPrompt(id string) (*models.Prompt, error) {
 }
 
 func (s *Service) CreatePrompt(input models.PromptCreate) (*models.Prompt, error) {
-	prompt := &models.Prompt{}
+	prompt := &models.Prompt{
+		BaseModel: models.BaseModel{ID: input.ID},
+		Content:   input.Content,
+		AgentID:   input.AgentID,
+	}
 	tx := s.Db.Create(prompt)
 	if tx.Error != nil {
 		return nil, tx.Error
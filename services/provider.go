@@ -5,8 +5,6 @@ import (
 	"context"
 	"fmt"
 	"github.com/y2a-labs/evaluate/models"
-
-	"github.com/sashabaranov/go-openai"
 )
 
 func (s *Service) GetProvider(id string) (*models.Provider, error) {
@@ -39,15 +37,12 @@ func (s *Service) CreateProvider(input models.ProviderCreate) (*models.Provider,
 		Interval:        input.Interval,
 		Unit:            input.Unit,
 		ValidKey:        false,
+		Budget:          models.Budget{BudgetWarning: 80},
 	}
+	input.Apply(&provider.Budget)
 
 	// Initialize the provider
-	client := openai.NewClient(input.ApiKey, provider.BaseUrl)
-
-	s.llmProviders[provider.ID] = &llmProvider{
-		Provider: provider,
-		client:   client,
-	}
+	s.llmProviders[provider.ID] = newLLMProvider(provider, input.ApiKey)
 
 	// Load any models that are compatible with the provider
 	modelList, err := s.PullLLMsFromProvider(provider.ID)
@@ -56,7 +51,8 @@ func (s *Service) CreateProvider(input models.ProviderCreate) (*models.Provider,
 	}
 	provider.Models = modelList
 
-	tx := s.Db.Save(provider)
+	// The models keep the provider they were first saved at
+	tx := s.Db.Omit("Models").Save(provider)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
@@ -83,30 +79,8 @@ func (s *Service) UpdateProvider(id string, input models.ProviderUpdate) (*model
 		return nil, tx.Error
 	}
 	// Apply the updates to the model
-	if input.ApiKey != "" {
-		// Create the encryption key
-		aesKey, err := loadOrCreateAESKey(".env")
-		if err != nil {
-			return nil, err
-		}
-		encryptedApiKey, err := Encrypt(input.ApiKey, aesKey)
-		if err != nil {
-			return nil, err
-		}
-		provider.EncryptedAPIKey = encryptedApiKey
-		// Make the update to the client
-		s.llmProviders[provider.ID] = &llmProvider{
-			Provider: provider,
-			client:   openai.NewClient(input.ApiKey, provider.BaseUrl),
-		}
-
-		// Load any models that are compatible with the provider
-		modelList, err := s.PullLLMsFromProvider(provider.ID)
-		if err == nil {
-			provider.ValidKey = true
-		}
-		provider.Models = modelList
-	}
+	typeChanged := input.Type != "" && input.Type != provider.Type
+	baseUrlChanged := input.BaseUrl != "" && input.BaseUrl != provider.BaseUrl
 
 	if input.BaseUrl != "" {
 		provider.BaseUrl = input.BaseUrl
@@ -128,9 +102,43 @@ func (s *Service) UpdateProvider(id string, input models.ProviderUpdate) (*model
 		provider.Unit = input.Unit
 	}
 
-	// Test the provider by tyring to list the models
-	_, err := s.llmProviders[provider.ID].client.ListModels(context.Background())
+	input.Apply(&provider.Budget)
+
+	// The client is made from the key, base url and type, so it is rebuilt when any of them change
+	aesKey, err := loadOrCreateAESKey(".env")
 	if err != nil {
+		return nil, err
+	}
+	apiKey := input.ApiKey
+	if apiKey != "" {
+		encryptedApiKey, err := Encrypt(input.ApiKey, aesKey)
+		if err != nil {
+			return nil, err
+		}
+		provider.EncryptedAPIKey = encryptedApiKey
+	} else if (typeChanged || baseUrlChanged) && provider.EncryptedAPIKey != "" {
+		apiKey, err = decrypt(provider.EncryptedAPIKey, aesKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if apiKey != "" {
+		// Make the update to the client
+		s.llmProviders[provider.ID] = newLLMProvider(provider, apiKey)
+
+		// Load any models that are compatible with the provider
+		modelList, err := s.PullLLMsFromProvider(provider.ID)
+		if err == nil {
+			provider.ValidKey = true
+		}
+		provider.Models = modelList
+	}
+
+	// Test the provider by tyring to list the models
+	llm, ok := s.llmProviders[provider.ID]
+	if !ok {
+		provider.ValidKey = false
+	} else if _, err := llm.listModels(context.Background()); err != nil {
 		provider.ValidKey = false
 		fmt.Println("Error: ", err)
 	} else {
@@ -138,7 +146,8 @@ func (s *Service) UpdateProvider(id string, input models.ProviderUpdate) (*model
 
 	}
 
-	tx = s.Db.Save(provider)
+	// The models keep the provider they were first saved at
+	tx = s.Db.Omit("Models").Save(provider)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
@@ -2,13 +2,37 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/y2a-labs/evaluate/models"
 	"strings"
 
+	"github.com/google/uuid"
 	"github.com/sashabaranov/go-openai"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
+// ProxyOptions are read from the headers of a proxied request.
+type ProxyOptions struct {
+	// ProviderID skips looking up the provider of the model
+	ProviderID string
+	// ConversationID logs the request to that conversation, instead of finding it from the messages
+	ConversationID string
+	// Tags, User, App and Metadata are logged with the conversation
+	Tags     []string
+	User     string
+	App      string
+	Metadata map[string]string
+	// NoCache sends the request even when a cached response is found, the response then refreshes the cache
+	NoCache bool
+	// Temperature is the temperature of the request, nil when the client leaves it out. The openai request
+	// can't tell a zero temperature from a missing one
+	Temperature *float32
+}
+
 func (s *Service) GetModel(modelName string) (modelID, providerID string, err error) {
 	// Check if the modelName can be split with a "/"
 	parts := strings.SplitN(modelName, "/", 2)
@@ -36,69 +60,94 @@ func (s *Service) GetModel(modelName string) (modelID, providerID string, err er
 	return
 }
 
-func (s *Service) ProxyOpenaiStream(ctx context.Context, req openai.ChatCompletionRequest, providerId string) (*openai.ChatCompletionStream, *models.Conversation, error) {
-	// When the provider comes from the headers
-	modelId := req.Model
-	var err error
-
-	if providerId == "" {
-		modelId, providerId, err = s.GetModel(req.Model)
-		if err != nil {
-			return nil, nil, err
-		}
+// resolveProxyModel finds the model and provider of a proxied request, the provider either comes from
+// the headers or from the name of the model.
+func (s *Service) resolveProxyModel(model string, options ProxyOptions) (modelID, providerID string, err error) {
+	if options.ProviderID != "" {
+		return model, options.ProviderID, nil
 	}
+	return s.GetModel(model)
+}
 
-	req.Model = modelId
-
-	provider, ok := s.llmProviders[providerId]
-	if !ok {
+// ProxyOpenaiStream streams the response of the requested model, or of its fallbacks when it fails.
+func (s *Service) ProxyOpenaiStream(ctx context.Context, req openai.ChatCompletionRequest, options ProxyOptions) (ChatCompletionStream, *ProxyRoute, error) {
+	entry, cached, cachedRoute, err := s.lookupResponseCache(ctx, req, options)
+	if err != nil {
 		return nil, nil, err
 	}
-
-	conversation, err := s.CreateConversation(models.ConversationCreate{Messages: req.Messages, LLMID: req.Model})
+	if cached != nil {
+		return newCachedStream(cached), cachedRoute, nil
+	}
+	targets, err := s.proxyTargets(req.Model, options)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	stream, err := provider.client.CreateChatCompletionStream(ctx, req)
-
+	requested := targets[0]
+	conversation, err := s.threadConversation(req, requested.ModelID, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	targets, err = s.splitTraffic(req.Model, conversation, options, targets)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return stream, conversation, nil
+	var stream ChatCompletionStream
+	// Only the start of the stream can fall back, the response is sent to the client as it comes
+	route, err := s.routeProxyRequest(ctx, req, clientTemperature(req, options), targets, func(ctx context.Context, provider *llmProvider, req openai.ChatCompletionRequest) error {
+		var err error
+		stream, err = provider.createChatCompletionStream(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	route.Conversation = conversation
+	// A response of a fallback or of a candidate isn't the answer of the model
+	if route.ModelID == requested.ModelID && route.ProviderID == requested.ProviderID && !route.FellBack() {
+		route.cacheEntry = entry
+	}
+	return stream, route, nil
 }
 
-func (s *Service) ProxyOpenaiChat(ctx context.Context, req openai.ChatCompletionRequest, providerId string) (*openai.ChatCompletionResponse, *models.Conversation, error) {
-	// When the provider comes from the headers
-	modelId := req.Model
-	var err error
-
-	if providerId == "" {
-		modelId, providerId, err = s.GetModel(req.Model)
-		if err != nil {
-			return nil, nil, err
-		}
+// ProxyOpenaiChat returns the response of the requested model, or of its fallbacks when it fails.
+func (s *Service) ProxyOpenaiChat(ctx context.Context, req openai.ChatCompletionRequest, options ProxyOptions) (*openai.ChatCompletionResponse, *ProxyRoute, error) {
+	entry, cached, cachedRoute, err := s.lookupResponseCache(ctx, req, options)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	req.Model = modelId
-
-	provider, ok := s.llmProviders[providerId]
-	if !ok {
+	if cached != nil {
+		return cachedChatCompletion(cached), cachedRoute, nil
+	}
+	targets, err := s.proxyTargets(req.Model, options)
+	if err != nil {
 		return nil, nil, err
 	}
-
-	conversation, err := s.CreateConversation(models.ConversationCreate{Messages: req.Messages, LLMID: req.Model})
+	requested := targets[0]
+	conversation, err := s.threadConversation(req, requested.ModelID, options)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	stream, err := provider.client.CreateChatCompletion(ctx, req)
+	targets, err = s.splitTraffic(req.Model, conversation, options, targets)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return &stream, conversation, err
+	var resp openai.ChatCompletionResponse
+	route, err := s.routeProxyRequest(ctx, req, clientTemperature(req, options), targets, func(ctx context.Context, provider *llmProvider, req openai.ChatCompletionRequest) error {
+		var err error
+		resp, err = provider.createChatCompletion(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	route.Conversation = conversation
+	// A response of a fallback or of a candidate isn't the answer of the model
+	if route.ModelID == requested.ModelID && route.ProviderID == requested.ProviderID && !route.FellBack() {
+		route.cacheEntry = entry
+	}
+	return &resp, route, nil
 }
 
 func (s *Service) ProxyOpenaiEmbedding(ctx context.Context, req openai.EmbeddingRequest) (*openai.EmbeddingResponse, error) {
@@ -112,6 +161,231 @@ func (s *Service) ProxyOpenaiEmbedding(ctx context.Context, req openai.Embedding
 	if !ok {
 		return nil, fmt.Errorf("provider not found")
 	}
-	resp, err := provider.client.CreateEmbeddings(ctx, req)
+	resp, err := provider.createEmbeddings(ctx, req)
 	return &resp, err
-}
\ No newline at end of file
+}
+// nextThreadHash chains the hash of a conversation with its next message, so the hash of every prefix of the
+// messages of a request can be found in one pass.
+func nextThreadHash(previous, role, content string) string {
+	hash := sha256.Sum256([]byte(previous + "\x00" + role + "\x00" + content))
+	return hex.EncodeToString(hash[:])
+}
+
+// threadHashes returns the hash of every prefix of the messages, the last one is the hash of all of them.
+func threadHashes(messages []openai.ChatCompletionMessage) []string {
+	hashes := make([]string, len(messages))
+	previous := ""
+	for i, message := range messages {
+		previous = nextThreadHash(previous, message.Role, threadContent(message.Content, message.ToolCalls, message.ToolCallID))
+		hashes[i] = previous
+	}
+	return hashes
+}
+
+// sameMessage reports whether the logged message is the message of the request.
+func sameMessage(logged *models.Message, message openai.ChatCompletionMessage) bool {
+	return logged.Role == message.Role &&
+		threadContent(logged.Content, logged.ToolCalls, logged.ToolCallID) == threadContent(message.Content, message.ToolCalls, message.ToolCallID)
+}
+
+// threadConversation finds the logged conversation that the messages continue and adds the new messages to it,
+// so a chat is logged as one conversation rather than one per request. The conversation is found by its ID when
+// the client sends one, or else by the hash of its messages matching a prefix of the request, among the conversations
+// of the same user and app.
+// A new conversation is created when there is none, or when the logged one was edited since. The conversation keeps
+// the tools of the request.
+func (s *Service) threadConversation(req openai.ChatCompletionRequest, model string, options ProxyOptions) (*models.Conversation, error) {
+	messages := req.Messages
+	hashes := threadHashes(messages)
+	conversationID := options.ConversationID
+
+	var conversation *models.Conversation
+	matched := 0
+	threadHash := ""
+	if conversationID != "" {
+		var err error
+		conversation, err = s.GetConversationWithMessages(conversationID, -1)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return s.createThread(req, hashes, model, options)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if conversation.IsTest {
+			return nil, fmt.Errorf("conversation is a test: %s", conversationID)
+		}
+		// The client can send the whole chat or only the new messages
+		for matched < len(conversation.Messages) && matched < len(messages) &&
+			sameMessage(conversation.Messages[matched], messages[matched]) {
+			matched++
+		}
+		// The chat was edited or branched from an earlier turn, the logged messages don't lead up to the request
+		if matched > 0 && matched < len(conversation.Messages) {
+			options.ConversationID = ""
+			return s.createThread(req, hashes, model, options)
+		}
+		// The new messages are added after the logged ones, so their hash is chained to the hash of the conversation
+		threadHash = conversation.ThreadHash
+		if threadHash == "" {
+			// Conversations that weren't proxied have no hash yet
+			for _, message := range conversation.Messages {
+				threadHash = nextThreadHash(threadHash, message.Role, threadContent(message.Content, message.ToolCalls, message.ToolCallID))
+			}
+		}
+		for _, message := range messages[matched:] {
+			threadHash = nextThreadHash(threadHash, message.Role, threadContent(message.Content, message.ToolCalls, message.ToolCallID))
+		}
+	} else {
+		if len(hashes) == 0 {
+			return s.createThread(req, hashes, model, options)
+		}
+		conversation = &models.Conversation{}
+		// The longest conversation wins, when several have the same start. The chats of other users and apps are left out.
+		tx := s.Db.Where("thread_hash IN ? AND is_test = ? AND conversations.user = ? AND conversations.app = ?", hashes, false, options.User, options.App).Order("last_message_index DESC").Order("updated_at DESC").Limit(1).Find(conversation)
+		if tx.Error != nil {
+			return nil, tx.Error
+		}
+		if tx.RowsAffected == 0 {
+			return s.createThread(req, hashes, model, options)
+		}
+		for i, hash := range hashes {
+			if hash == conversation.ThreadHash {
+				matched = i + 1
+			}
+		}
+		threadHash = hashes[len(hashes)-1]
+	}
+
+	newMessages := []models.ChatCompletionMessage{}
+	for _, message := range messages[matched:] {
+		newMessages = append(newMessages, models.NewChatCompletionMessage(message))
+	}
+	if len(newMessages) > 0 {
+		if _, err := s.AddMessagesToConversation(conversation, newMessages); err != nil {
+			return nil, err
+		}
+	}
+
+	// A repeated request then finds the conversation with nothing new to add
+	conversation.ThreadHash = threadHash
+	updates := map[string]any{"thread_hash": conversation.ThreadHash}
+	if len(options.Tags) > 0 {
+		conversation.Tags = mergeTags(conversation.Tags, options.Tags)
+		updates["tags"] = conversation.Tags
+	}
+	if len(req.Tools) > 0 {
+		conversation.Tools = req.Tools
+		updates["tools"] = conversation.Tools
+	}
+	if options.User != "" {
+		conversation.User = options.User
+		updates["user"] = conversation.User
+	}
+	if options.App != "" {
+		conversation.App = options.App
+		updates["app"] = conversation.App
+	}
+	if len(options.Metadata) > 0 {
+		if conversation.Metadata == nil {
+			conversation.Metadata = datatypes.JSONMap{}
+		}
+		for key, value := range options.Metadata {
+			conversation.Metadata[key] = value
+		}
+		updates["metadata"] = conversation.Metadata
+	}
+	tx := s.Db.Model(conversation).Updates(updates)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return conversation, nil
+}
+
+func (s *Service) createThread(req openai.ChatCompletionRequest, hashes []string, model string, options ProxyOptions) (*models.Conversation, error) {
+	messages := req.Messages
+	conversation, err := s.CreateConversation(models.ConversationCreate{
+		ID:       options.ConversationID,
+		Messages: messages,
+		Tools:    req.Tools,
+		LLMID:    model,
+		Tags:     options.Tags,
+		User:     options.User,
+		App:      options.App,
+		Metadata: options.Metadata,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// CreateConversation counts the messages, the thread keeps the index of the last one so the response follows it
+	conversation.LastMessageIndex = len(messages) - 1
+	if len(hashes) > 0 {
+		conversation.ThreadHash = hashes[len(hashes)-1]
+	}
+	tx := s.Db.Model(conversation).Updates(map[string]any{
+		"last_message_index": conversation.LastMessageIndex,
+		"thread_hash":        conversation.ThreadHash,
+	})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return conversation, nil
+}
+
+// AddProxyResponse logs the response as the next message of the proxied conversation, under the model that
+// answered the request and priced with its prices.
+func (s *Service) AddProxyResponse(route *ProxyRoute, message *models.Message) error {
+	if message.ID == "" {
+		message.ID = uuid.NewString()
+	}
+	conversation := route.Conversation
+	message.LLMID = route.ModelID
+	message.ProviderID = route.ProviderID
+	setCost(message.Metadata, s.getLLMPrice(route.ProviderID, route.ModelID))
+	if message.Metadata != nil {
+		message.Metadata.Alias = route.Alias
+		message.Metadata.SplitArm = route.SplitArm
+		if route.CacheKey != "" {
+			message.Metadata.CacheModel = route.RequestedModel
+			message.Metadata.CacheKey = route.CacheKey
+			message.Metadata.CacheScope = route.CacheScope
+		}
+		if route.CachedFrom != "" {
+			message.Metadata.CachedFrom = route.CachedFrom
+			message.Metadata.SavedCost = message.Metadata.Cost
+			message.Metadata.Cost = 0
+			message.Metadata.Similarity = route.Similarity
+		}
+	}
+	if message.Metadata != nil && route.FellBack() {
+		message.Metadata.FallbackFrom = route.RequestedModel
+		message.Metadata.FallbackErrors = route.FallbackErrors
+	}
+	message.ConversationID = conversation.ID
+	message.ConversationVersion = conversation.Version
+	message.MessageIndex = conversation.LastMessageIndex + 1
+	tx := s.Db.Create(message)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	conversation.Messages = append(conversation.Messages, message)
+	conversation.LastMessageIndex = message.MessageIndex
+	conversation.ThreadHash = nextThreadHash(conversation.ThreadHash, message.Role, threadContent(message.Content, message.ToolCalls, message.ToolCallID))
+	tx = s.Db.Model(conversation).Updates(map[string]any{
+		"last_message_index": conversation.LastMessageIndex,
+		"thread_hash":        conversation.ThreadHash,
+	})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if route.CachedFrom == "" && route.PromptEmbedding != nil {
+		if err := s.savePromptEmbedding(message, route.PromptEmbedding); err != nil {
+			return err
+		}
+	}
+	// The shadow models already got the request when the response was cached
+	if route.CachedFrom == "" {
+		s.mirrorToShadows(route, message)
+	}
+	return nil
+}
@@ -1,9 +1,16 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/sashabaranov/go-openai"
 	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
 )
 
 func TestGetModel(t *testing.T) {
@@ -30,3 +37,158 @@ func TestGetModel(t *testing.T) {
 	assert.Equal(t, "", modelID)
 	assert.Equal(t, "", providerID)
 }
+
+func newFakeProxyService(t *testing.T) (*Service, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := openai.ChatCompletionRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{
+				{Message: openai.ChatCompletionMessage{Role: "assistant", Content: "Reply " + request.Messages[len(request.Messages)-1].Content}},
+			},
+		})
+	}))
+	config := openai.DefaultConfig("key")
+	config.BaseURL = server.URL
+	s := New(":memory:", "../.env")
+	s.llmProviders = map[string]*llmProvider{
+		"fake": {Provider: &models.Provider{BaseModel: models.BaseModel{ID: "fake"}, Requests: 100}, client: openai.NewClientWithConfig(config)},
+	}
+	return s, server
+}
+
+// proxyChat sends the messages through the proxy and logs the response, like the chat completions route.
+func proxyChat(t *testing.T, s *Service, messages []openai.ChatCompletionMessage, conversationID string) (*models.Conversation, openai.ChatCompletionMessage) {
+	resp, route, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "model", Messages: messages}, ProxyOptions{ProviderID: "fake", ConversationID: conversationID})
+	assert.Nil(t, err)
+	reply := resp.Choices[0].Message
+	err = s.AddProxyResponse(route, &models.Message{Role: reply.Role, Content: reply.Content})
+	assert.Nil(t, err)
+	return route.Conversation, reply
+}
+
+func TestProxyThreading(t *testing.T) {
+	s, server := newFakeProxyService(t)
+	defer server.Close()
+
+	messages := []openai.ChatCompletionMessage{{Role: "system", Content: "Be brief."}, {Role: "user", Content: "Hi"}}
+	first, reply := proxyChat(t, s, messages, "")
+	messages = append(messages, reply, openai.ChatCompletionMessage{Role: "user", Content: "Again"})
+	second, reply := proxyChat(t, s, messages, "")
+	assert.Equal(t, first.ID, second.ID, "Expect the next turn to be added to the conversation")
+
+	conversation, err := s.GetConversationWithMessages(first.ID, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(conversation.Messages))
+	for i, message := range conversation.Messages {
+		assert.Equal(t, i, message.MessageIndex)
+	}
+	assert.Equal(t, "Reply Again", conversation.Messages[4].Content)
+
+	// A chat with a different start is a new conversation
+	other, _ := proxyChat(t, s, []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}, "")
+	assert.NotEqual(t, first.ID, other.ID)
+
+	// Branching from an earlier turn starts a new conversation too
+	branch, _ := proxyChat(t, s, append(messages[:2:2], openai.ChatCompletionMessage{Role: "assistant", Content: "Edited"}, openai.ChatCompletionMessage{Role: "user", Content: "Again"}), "")
+	assert.NotEqual(t, first.ID, branch.ID)
+
+	// The client can name the conversation, and then only send the new messages
+	named, _ := proxyChat(t, s, []openai.ChatCompletionMessage{{Role: "user", Content: "One"}}, "my-chat")
+	assert.Equal(t, "my-chat", named.ID)
+	named, _ = proxyChat(t, s, []openai.ChatCompletionMessage{{Role: "user", Content: "Two"}}, "my-chat")
+	conversation, err = s.GetConversationWithMessages("my-chat", -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"One", "Reply One", "Two", "Reply Two"}, []string{
+		conversation.Messages[0].Content, conversation.Messages[1].Content, conversation.Messages[2].Content, conversation.Messages[3].Content,
+	})
+
+	// A named chat that was edited after an earlier turn is a new conversation, the logged one is left as it is
+	edited, _ := proxyChat(t, s, []openai.ChatCompletionMessage{{Role: "user", Content: "One"}, {Role: "assistant", Content: "Reply One"}, {Role: "user", Content: "Edited"}}, "my-chat")
+	assert.NotEqual(t, "my-chat", edited.ID)
+	edited, err = s.GetConversationWithMessages(edited.ID, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(edited.Messages))
+	conversation, err = s.GetConversationWithMessages("my-chat", -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(conversation.Messages))
+
+	// The same chat of another user or app is its own conversation
+	for _, options := range []ProxyOptions{{ProviderID: "fake", User: "user-2"}, {ProviderID: "fake", App: "other-app"}} {
+		_, route, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "model", Messages: messages}, options)
+		assert.Nil(t, err)
+		assert.NotEqual(t, first.ID, route.Conversation.ID)
+	}
+
+	// When only the new messages are sent, the whole chat still finds the conversation afterwards
+	proxyChat(t, s, []openai.ChatCompletionMessage{{Role: "user", Content: "Three"}}, "my-chat")
+	full := []openai.ChatCompletionMessage{}
+	for _, content := range []string{"One", "Reply One", "Two", "Reply Two", "Three", "Reply Three"} {
+		role := "user"
+		if strings.HasPrefix(content, "Reply") {
+			role = "assistant"
+		}
+		full = append(full, openai.ChatCompletionMessage{Role: role, Content: content})
+	}
+	continued, _ := proxyChat(t, s, append(full, openai.ChatCompletionMessage{Role: "user", Content: "Four"}), "")
+	assert.Equal(t, "my-chat", continued.ID)
+
+	// Tests can't be written to by the proxy
+	s.Db.Model(&models.Conversation{}).Where("id = ?", "my-chat").Update("is_test", true)
+	_, _, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "model"}, ProxyOptions{ProviderID: "fake", ConversationID: "my-chat"})
+	assert.Error(t, err)
+}
+
+func TestProxyTagging(t *testing.T) {
+	s, server := newFakeProxyService(t)
+	defer server.Close()
+
+	request := models.ProxyChatCompletionRequest{}
+	err := json.Unmarshal([]byte(`{"model":"model","user":"user-1","metadata":{"session":"abc"},"messages":[{"role":"user","content":"Hi"}]}`), &request)
+	assert.Nil(t, err)
+	assert.Equal(t, "user-1", request.User)
+	assert.Equal(t, "Hi", request.Messages[0].Content)
+
+	options := ProxyOptions{ProviderID: "fake", Tags: []string{"beta"}, User: request.User, App: "support-bot", Metadata: request.Metadata}
+	_, tagged, err := s.ProxyOpenaiChat(context.Background(), request.ChatCompletionRequest, options)
+	assert.Nil(t, err)
+	assert.Nil(t, s.AddProxyResponse(tagged, &models.Message{Role: "assistant", Content: "Reply Hi"}))
+
+	// The next turn adds its tags to the conversation, a turn without the user is found by its ID
+	request.Messages = append(request.Messages, openai.ChatCompletionMessage{Role: "assistant", Content: "Reply Hi"}, openai.ChatCompletionMessage{Role: "user", Content: "Bye"})
+	options = ProxyOptions{ProviderID: "fake", ConversationID: tagged.Conversation.ID, Tags: []string{"beta", "vip"}, Metadata: map[string]string{"page": "home"}}
+	_, next, err := s.ProxyOpenaiChat(context.Background(), request.ChatCompletionRequest, options)
+	assert.Nil(t, err)
+	assert.Equal(t, tagged.Conversation.ID, next.Conversation.ID)
+
+	_, _, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "model", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Other"}}}, ProxyOptions{ProviderID: "fake", App: "other-app"})
+	assert.Nil(t, err)
+
+	conversations, err := s.GetAllConversations(ParseConversationFilter("vip", "", "", ""))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(conversations))
+	assert.Equal(t, []string{"beta", "vip"}, []string(conversations[0].Tags))
+	assert.Equal(t, "user-1", conversations[0].User, "Expect the user to be kept when the next turn doesn't send one")
+	assert.Equal(t, "support-bot", conversations[0].App)
+	assert.Equal(t, "abc", conversations[0].Metadata["session"])
+	assert.Equal(t, "home", conversations[0].Metadata["page"])
+
+	for _, filter := range []models.ConversationFilter{
+		ParseConversationFilter("", "user-1", "", ""),
+		ParseConversationFilter("", "", "support-bot", ""),
+		ParseConversationFilter("", "", "", "session=abc, page=home"),
+	} {
+		conversations, err = s.GetAllConversations(filter)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(conversations))
+		assert.Equal(t, tagged.Conversation.ID, conversations[0].ID)
+	}
+
+	conversations, err = s.GetAllConversations(ParseConversationFilter("", "", "", "session=other"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(conversations))
+
+	conversations, err = s.GetAllConversations(models.ConversationFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(conversations))
+}
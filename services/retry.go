@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const maxTestRetries = 2
+
+// retryBackoff is the wait before the first retry, it doubles after every attempt.
+var retryBackoff = time.Second
+
+// withRetries calls fn until it succeeds, returns an error that isn't retryable, or runs out of retries.
+// It returns the number of retries that were made.
+func withRetries(ctx context.Context, maxRetries int, fn func() error) (int, error) {
+	backoff := retryBackoff
+	for retries := 0; ; retries++ {
+		err := fn()
+		if err == nil || retries == maxRetries || !isRetryableError(err) {
+			return retries, err
+		}
+		select {
+		case <-ctx.Done():
+			return retries, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// errorStatusCode returns the HTTP status code of a failed provider call, or 0 when there is none.
+func errorStatusCode(err error) int {
+	apiErr := &openai.APIError{}
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode
+	}
+	requestErr := &openai.RequestError{}
+	if errors.As(err, &requestErr) {
+		return requestErr.HTTPStatusCode
+	}
+	return 0
+}
+
+// isRetryableError reports whether the call failed because of rate limiting, a server error or a timeout.
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || IsBudgetError(err) {
+		return false
+	}
+	statusCode := errorStatusCode(err)
+	if statusCode == http.StatusTooManyRequests || statusCode >= 500 {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	netErr := net.Error(nil)
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
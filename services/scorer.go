@@ -0,0 +1,359 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/y2a-labs/evaluate/models"
+)
+
+const defaultScorer = "cosine"
+
+// Scorer grades a candidate test message against the reference message it was generated for.
+type Scorer interface {
+	Name() string
+	Score(ctx context.Context, input ScoreInput) (ScoreResult, error)
+}
+
+type ScoreInput struct {
+	// History is the conversation that came before the reference message
+	History   []*models.Message
+	Reference *models.Message
+	Candidate *models.Message
+	// budget is the budget of the run being scored, the scorers that call a model check and count their calls with it
+	budget *testBudget
+}
+
+type ScoreResult struct {
+	// Score is between 0 and 1
+	Score     float64
+	Rationale string
+	// Cost is the price in dollars of the calls the scorer made
+	Cost float64
+}
+
+// scorerFactories builds a scorer from the scorer settings stored on the test conversation.
+var scorerFactories = map[string]func(s *Service, conversation *models.Conversation) (Scorer, error){
+	"cosine": func(s *Service, conversation *models.Conversation) (Scorer, error) {
+		return cosineScorer{s: s}, nil
+	},
+	"exact_match": func(s *Service, conversation *models.Conversation) (Scorer, error) {
+		return exactMatchScorer{}, nil
+	},
+	"regex": func(s *Service, conversation *models.Conversation) (Scorer, error) {
+		if conversation.ScorerConfig == "" {
+			return nil, fmt.Errorf("regex scorer requires a pattern")
+		}
+		pattern, err := regexp.Compile(conversation.ScorerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern: %w", err)
+		}
+		return regexScorer{pattern: pattern}, nil
+	},
+	"json_schema": func(s *Service, conversation *models.Conversation) (Scorer, error) {
+		schema := map[string]any{}
+		if conversation.ScorerConfig != "" {
+			if err := json.Unmarshal([]byte(conversation.ScorerConfig), &schema); err != nil {
+				return nil, fmt.Errorf("invalid json schema: %w", err)
+			}
+		}
+		return jsonSchemaScorer{schema: schema}, nil
+	},
+	"length_ratio": func(s *Service, conversation *models.Conversation) (Scorer, error) {
+		return lengthRatioScorer{}, nil
+	},
+	"llm_judge": newLLMJudgeScorer,
+}
+
+// ScorerNames returns the names of all of the available scorers.
+func ScorerNames() []string {
+	names := make([]string, 0, len(scorerFactories))
+	for name := range scorerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetScorer returns the scorer selected for the test conversation.
+func (s *Service) GetScorer(conversation *models.Conversation) (Scorer, error) {
+	name := conversation.Scorer
+	if name == "" {
+		name = defaultScorer
+	}
+	factory, ok := scorerFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("scorer not found: %s", name)
+	}
+	return factory(s, conversation)
+}
+
+// SetTestScorer changes the scorer of a test and clears the stored scores so they are recomputed.
+func (s *Service) SetTestScorer(conversationID string, input models.ScorerUpdate) (*models.Conversation, error) {
+	conversation, err := s.GetConversation(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	conversation.Scorer = input.Scorer
+	conversation.ScorerConfig = input.ScorerConfig
+	conversation.JudgeModel = input.JudgeModel
+	conversation.JudgePromptID = input.JudgePromptID
+
+	// Make sure the scorer can be built before saving it
+	if _, err := s.GetScorer(conversation); err != nil {
+		return nil, err
+	}
+
+	tx := s.Db.Model(conversation).Updates(map[string]any{
+		"scorer":          conversation.Scorer,
+		"scorer_config":   conversation.ScorerConfig,
+		"judge_model":     conversation.JudgeModel,
+		"judge_prompt_id": conversation.JudgePromptID,
+	})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	testMessageIDs := s.Db.Model(&models.Message{}).Select("id").Where("conversation_id = ? AND test_message_id <> ''", conversation.ID)
+	tx = s.Db.Model(&models.MessageMetadata{}).Where("message_id IN (?)", testMessageIDs).Update("scorer", "")
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return conversation, nil
+}
+
+// needsEmbeddings reports whether the scorer compares the embeddings of the messages, the responses of the
+// tests are only embedded for it.
+func needsEmbeddings(scorer Scorer) bool {
+	_, ok := scorer.(cosineScorer)
+	return ok
+}
+
+// scoresOnLoad reports whether the scorer can score the messages when a test is loaded. The judge calls a model,
+// so it only scores during the test runs and loading a test doesn't make calls.
+func scoresOnLoad(scorer Scorer) bool {
+	_, ok := scorer.(llmJudgeScorer)
+	return !ok
+}
+
+// scoreTestMessages fills in the score of every candidate, reusing the stored result when
+// it was produced by the same scorer and storing it otherwise.
+func (s *Service) scoreTestMessages(ctx context.Context, scorer Scorer, history []*models.Message, reference *models.Message, candidates []*models.Message) error {
+	for _, candidate := range candidates {
+		if candidate.Metadata != nil && candidate.Metadata.Scorer == scorer.Name() {
+			candidate.Score = roundScore(candidate.Metadata.Score)
+			continue
+		}
+		if !scoresOnLoad(scorer) {
+			// Left unscored until a run scores it
+			candidate.Score = 0
+			continue
+		}
+
+		result, err := scorer.Score(ctx, ScoreInput{History: history, Reference: reference, Candidate: candidate})
+		if err != nil {
+			// Leave it unscored so it is retried on the next load
+			log.Printf("error scoring message %s with %s: %v", candidate.ID, scorer.Name(), err)
+			candidate.Score = 0
+			continue
+		}
+		candidate.Score = roundScore(result.Score)
+
+		if candidate.Metadata == nil {
+			candidate.Metadata = &models.MessageMetadata{MessageID: candidate.ID}
+		}
+		setScore(candidate.Metadata, scorer, result)
+		tx := s.Db.Save(candidate.Metadata)
+		if tx.Error != nil {
+			return tx.Error
+		}
+	}
+	return nil
+}
+
+// setScore stores the result of a scorer on the message metadata.
+func setScore(metadata *models.MessageMetadata, scorer Scorer, result ScoreResult) {
+	if metadata == nil {
+		return
+	}
+	metadata.Scorer = scorer.Name()
+	metadata.Score = result.Score
+	metadata.ScoreRationale = result.Rationale
+}
+
+// roundScore turns a 0-1 score into a percentage with two decimals.
+func roundScore(score float64) float64 {
+	return math.Round(score*10000) / 100
+}
+
+type cosineScorer struct {
+	s *Service
+}
+
+func (cosineScorer) Name() string { return "cosine" }
+
+func (c cosineScorer) Score(ctx context.Context, input ScoreInput) (ScoreResult, error) {
+	// Make sure both messages have embeddings
+	if input.Reference.Metadata == nil || input.Candidate.Metadata == nil {
+		return ScoreResult{}, fmt.Errorf("missing metadata on message")
+	}
+	if len(input.Reference.Metadata.Embedding) == 0 || len(input.Candidate.Metadata.Embedding) == 0 {
+		return ScoreResult{}, fmt.Errorf("missing embedding on message")
+	}
+	score, err := c.s.CosineSimilarity(input.Candidate.Metadata.Embedding, input.Reference.Metadata.Embedding)
+	if err != nil {
+		return ScoreResult{}, err
+	}
+	return ScoreResult{Score: score}, nil
+}
+
+type exactMatchScorer struct{}
+
+func (exactMatchScorer) Name() string { return "exact_match" }
+
+func (exactMatchScorer) Score(ctx context.Context, input ScoreInput) (ScoreResult, error) {
+	if strings.TrimSpace(input.Reference.Content) == strings.TrimSpace(input.Candidate.Content) {
+		return ScoreResult{Score: 1}, nil
+	}
+	return ScoreResult{Score: 0}, nil
+}
+
+type regexScorer struct {
+	pattern *regexp.Regexp
+}
+
+func (regexScorer) Name() string { return "regex" }
+
+func (r regexScorer) Score(ctx context.Context, input ScoreInput) (ScoreResult, error) {
+	if r.pattern.MatchString(input.Candidate.Content) {
+		return ScoreResult{Score: 1}, nil
+	}
+	return ScoreResult{Score: 0, Rationale: fmt.Sprintf("does not match %s", r.pattern)}, nil
+}
+
+type lengthRatioScorer struct{}
+
+func (lengthRatioScorer) Name() string { return "length_ratio" }
+
+func (lengthRatioScorer) Score(ctx context.Context, input ScoreInput) (ScoreResult, error) {
+	a := utf8.RuneCountInString(input.Reference.Content)
+	b := utf8.RuneCountInString(input.Candidate.Content)
+	if a == 0 && b == 0 {
+		return ScoreResult{Score: 1}, nil
+	}
+	return ScoreResult{Score: float64(min(a, b)) / float64(max(a, b))}, nil
+}
+
+// jsonSchemaScorer checks that the candidate is valid JSON matching a subset of JSON schema
+// (type, properties, required, items and enum).
+type jsonSchemaScorer struct {
+	schema map[string]any
+}
+
+func (jsonSchemaScorer) Name() string { return "json_schema" }
+
+func (j jsonSchemaScorer) Score(ctx context.Context, input ScoreInput) (ScoreResult, error) {
+	var value any
+	if err := json.Unmarshal([]byte(extractJSON(input.Candidate.Content)), &value); err != nil {
+		return ScoreResult{Score: 0, Rationale: "invalid json: " + err.Error()}, nil
+	}
+	if err := validateJSONSchema(j.schema, value); err != nil {
+		return ScoreResult{Score: 0, Rationale: err.Error()}, nil
+	}
+	return ScoreResult{Score: 1}, nil
+}
+
+// extractJSON strips a markdown code fence from around the content if there is one.
+func extractJSON(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+	content = strings.TrimPrefix(content, "```")
+	content = strings.TrimPrefix(content, "json")
+	content = strings.TrimSuffix(content, "```")
+	return strings.TrimSpace(content)
+}
+
+func validateJSONSchema(schema map[string]any, value any) error {
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, item := range enum {
+			if fmt.Sprint(item) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("value %v is not in enum", value)
+		}
+	}
+
+	switch schema["type"] {
+	case nil:
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected object")
+		}
+		required, _ := schema["required"].([]any)
+		for _, key := range required {
+			if _, ok := object[fmt.Sprint(key)]; !ok {
+				return fmt.Errorf("missing required property %v", key)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for key, propertySchema := range properties {
+			propertyValue, ok := object[key]
+			if !ok {
+				continue
+			}
+			child, _ := propertySchema.(map[string]any)
+			if err := validateJSONSchema(child, propertyValue); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("expected array")
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range array {
+			if err := validateJSONSchema(items, item); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("expected string")
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("expected number")
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			return fmt.Errorf("expected integer")
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("expected boolean")
+		}
+	case "null":
+		if value != nil {
+			return fmt.Errorf("expected null")
+		}
+	default:
+		return fmt.Errorf("unsupported schema type %v", schema["type"])
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestScorers(t *testing.T) {
+	ctx := context.Background()
+	s := &Service{}
+	reference := &models.Message{
+		Content:  "Hello world",
+		Metadata: &models.MessageMetadata{Embedding: []float32{1, 0}},
+	}
+
+	scorer, err := s.GetScorer(&models.Conversation{})
+	assert.Nil(t, err)
+	assert.Equal(t, "cosine", scorer.Name(), "Expect cosine to be the default scorer")
+	result, err := scorer.Score(ctx, ScoreInput{Reference: reference, Candidate: &models.Message{Metadata: &models.MessageMetadata{Embedding: []float32{1, 0}}}})
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, result.Score)
+	_, err = scorer.Score(ctx, ScoreInput{Reference: reference, Candidate: &models.Message{}})
+	assert.Error(t, err, "Expect an error when the candidate has no embedding")
+
+	scorer, err = s.GetScorer(&models.Conversation{Scorer: "exact_match"})
+	assert.Nil(t, err)
+	result, _ = scorer.Score(ctx, ScoreInput{Reference: reference, Candidate: &models.Message{Content: " Hello world\n"}})
+	assert.Equal(t, 1.0, result.Score)
+	result, _ = scorer.Score(ctx, ScoreInput{Reference: reference, Candidate: &models.Message{Content: "Hello"}})
+	assert.Equal(t, 0.0, result.Score)
+
+	_, err = s.GetScorer(&models.Conversation{Scorer: "regex"})
+	assert.Error(t, err, "Expect the regex scorer to require a pattern")
+	scorer, err = s.GetScorer(&models.Conversation{Scorer: "regex", ScorerConfig: "^[0-9]+$"})
+	assert.Nil(t, err)
+	result, _ = scorer.Score(ctx, ScoreInput{Reference: reference, Candidate: &models.Message{Content: "42"}})
+	assert.Equal(t, 1.0, result.Score)
+	result, _ = scorer.Score(ctx, ScoreInput{Reference: reference, Candidate: &models.Message{Content: "forty two"}})
+	assert.Equal(t, 0.0, result.Score)
+
+	scorer, err = s.GetScorer(&models.Conversation{Scorer: "length_ratio"})
+	assert.Nil(t, err)
+	result, _ = scorer.Score(ctx, ScoreInput{Reference: reference, Candidate: &models.Message{Content: "Hello"}})
+	assert.InDelta(t, 5.0/11.0, result.Score, 0.0001)
+
+	_, err = s.GetScorer(&models.Conversation{Scorer: "unknown"})
+	assert.Error(t, err)
+}
+
+func TestJSONSchemaScorer(t *testing.T) {
+	ctx := context.Background()
+	s := &Service{}
+	scorer, err := s.GetScorer(&models.Conversation{
+		Scorer:       "json_schema",
+		ScorerConfig: `{"type":"object","required":["name"],"properties":{"name":{"type":"string"},"tags":{"type":"array","items":{"enum":["a","b"]}}}}`,
+	})
+	assert.Nil(t, err)
+
+	result, _ := scorer.Score(ctx, ScoreInput{Reference: &models.Message{}, Candidate: &models.Message{Content: "```json\n{\"name\":\"x\",\"tags\":[\"a\"]}\n```"}})
+	assert.Equal(t, 1.0, result.Score, "Expect fenced JSON matching the schema to pass")
+	result, _ = scorer.Score(ctx, ScoreInput{Reference: &models.Message{}, Candidate: &models.Message{Content: `{"tags":["a"]}`}})
+	assert.Equal(t, 0.0, result.Score, "Expect a missing required property to fail")
+	result, _ = scorer.Score(ctx, ScoreInput{Reference: &models.Message{}, Candidate: &models.Message{Content: `{"name":"x","tags":["c"]}`}})
+	assert.Equal(t, 0.0, result.Score, "Expect a value outside the enum to fail")
+	result, _ = scorer.Score(ctx, ScoreInput{Reference: &models.Message{}, Candidate: &models.Message{Content: "not json"}})
+	assert.Equal(t, 0.0, result.Score)
+
+	_, err = s.GetScorer(&models.Conversation{Scorer: "json_schema", ScorerConfig: "{"})
+	assert.Error(t, err)
+}
+
+func TestRoundScore(t *testing.T) {
+	assert.Equal(t, 87.65, roundScore(0.876543))
+	assert.Equal(t, 100.0, roundScore(1))
+}
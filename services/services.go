@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -9,6 +10,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"sync"
 	"github.com/y2a-labs/evaluate/internal/limiter"
 	"github.com/y2a-labs/evaluate/models"
 	"time"
@@ -22,11 +24,55 @@ type Service struct {
 	Db           *gorm.DB
 	limiter      *limiter.RateLimiterManager
 	llmProviders map[string]*llmProvider
+	testJobs     map[string]*TestJob
+	testJobsMu   sync.Mutex
+	// shadowRuns are the requests that are being mirrored to shadow models
+	shadowRuns sync.WaitGroup
+	// unpricedModels are the provider/model names that were used without saved prices
+	unpricedModels sync.Map
 }
 
 type llmProvider struct {
 	*models.Provider
+	// client is only set for OpenAI compatible providers, it is used to embed the messages of tests
 	client *openai.Client
+	// adapter speaks the API of the provider
+	adapter providerAdapter
+}
+
+func newLLMProvider(provider *models.Provider, apiKey string) *llmProvider {
+	llm := &llmProvider{
+		Provider: provider,
+		adapter:  newProviderAdapter(provider, apiKey),
+	}
+	if adapter, ok := llm.adapter.(*openaiAdapter); ok {
+		llm.client = adapter.client
+	}
+	return llm
+}
+
+// api returns the adapter of the provider, or talks to the OpenAI client directly when there is none.
+func (p *llmProvider) api() providerAdapter {
+	if p.adapter == nil {
+		return &openaiAdapter{client: p.client}
+	}
+	return p.adapter
+}
+
+func (p *llmProvider) createChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return p.api().CreateChatCompletion(ctx, req)
+}
+
+func (p *llmProvider) createChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (ChatCompletionStream, error) {
+	return p.api().CreateChatCompletionStream(ctx, req)
+}
+
+func (p *llmProvider) createEmbeddings(ctx context.Context, req openai.EmbeddingRequest) (openai.EmbeddingResponse, error) {
+	return p.api().CreateEmbeddings(ctx, req)
+}
+
+func (p *llmProvider) listModels(ctx context.Context) ([]string, error) {
+	return p.api().ListModels(ctx)
 }
 
 func (s *Service) GetLLMProviderNames() []string {
@@ -70,7 +116,7 @@ func getOpenaiComatibleProviders(db *gorm.DB, aesKey string) map[string]*llmProv
 
 	// Get the list of providers
 	providers := []models.Provider{}
-	tx := db.Where("type = ?", "llm").Find(&providers)
+	tx := db.Where("type IN ?", llmProviderTypes).Find(&providers)
 	if tx.Error != nil {
 		log.Printf("error getting embedding providers: %v", tx.Error)
 	}
@@ -85,12 +131,7 @@ func getOpenaiComatibleProviders(db *gorm.DB, aesKey string) map[string]*llmProv
 			log.Printf("error decrypting api key for provider %s: %v", provider.ID, err)
 			continue
 		}
-		client := openai.NewClient(decryptedKey, provider.BaseUrl)
-
-		llmProviders[provider.ID] = &llmProvider{
-			Provider: &provider,
-			client:   client,
-		}
+		llmProviders[provider.ID] = newLLMProvider(&provider, decryptedKey)
 	}
 	return llmProviders
 }
@@ -111,6 +152,14 @@ func connectDB(db_name string) *gorm.DB {
 		&models.LLM{},
 		&models.MessageMetadata{},
 		&models.Provider{},
+		&models.Prompt{},
+		&models.TestRun{},
+		&models.ClientBudget{},
+		&models.FallbackChain{},
+		&models.Alias{},
+		&models.TrafficSplit{},
+		&models.Shadow{},
+		&models.ResponseCache{},
 	)
 
 	// Creates the inital list of providers on the first run
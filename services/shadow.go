@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+// shadowTimeout caps the time of a mirrored request, it runs after the client got its response.
+var shadowTimeout = 2 * time.Minute
+
+// mirrorToShadows sends the request of the logged response to the shadow models of the requested model in the
+// background, for the percent of the requests that is mirrored. The outputs are saved as test messages of the
+// response, so they are compared with it like the results of a test.
+func (s *Service) mirrorToShadows(route *ProxyRoute, message *models.Message) {
+	shadow := &models.Shadow{}
+	tx := s.Db.Where("model = ?", route.RequestedModel).Limit(1).Find(shadow)
+	if tx.Error != nil || tx.RowsAffected == 0 {
+		return
+	}
+	if rand.Float64()*100 >= shadow.Percent {
+		return
+	}
+	for _, name := range shadow.Models {
+		target := s.resolveProxyTarget(name, ProxyOptions{})
+		s.checkTarget(&target)
+		if target.Err != nil {
+			log.Printf("skipped the shadow model %s: %v", name, target.Err)
+			continue
+		}
+		s.shadowRuns.Add(1)
+		go func() {
+			defer s.shadowRuns.Done()
+			if err := s.runShadow(route, message, target); err != nil {
+				log.Printf("shadow model %s failed: %v", name, err)
+			}
+		}()
+	}
+}
+
+// runShadow sends the request to the shadow model and saves its output as a test message of the response.
+func (s *Service) runShadow(route *ProxyRoute, message *models.Message, target proxyTarget) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shadowTimeout)
+	defer cancel()
+	provider := s.llmProviders[target.ProviderID]
+	if err := s.limiter.GetLimiter(provider.Provider).Wait(ctx); err != nil {
+		return fmt.Errorf("rate limiter wait error: %w", err)
+	}
+
+	req := withDefaultParams(route.Request, route.Temperature, target.Params)
+	req.Model = target.ModelID
+	req.Stream = false
+	startTime := time.Now()
+	resp, err := provider.createChatCompletion(withTemperature(ctx, requestTemperature(route.Temperature, target.Params)), req)
+	if err != nil {
+		return err
+	}
+	if len(resp.Choices) == 0 {
+		return errors.New("no choices returned")
+	}
+	content := resp.Choices[0].Message.Content
+	toolCalls := resp.Choices[0].Message.ToolCalls
+	usage, estimated := EstimateUsage(req, ResponseText(content, toolCalls), resp.Usage)
+
+	shadowMessage := &models.Message{
+		BaseModel:           models.BaseModel{ID: uuid.NewString()},
+		Role:                "assistant",
+		Content:             content,
+		ToolCalls:           toolCalls,
+		LLMID:               target.ModelID,
+		ProviderID:          target.ProviderID,
+		TestMessageID:       message.ID,
+		ConversationID:      message.ConversationID,
+		ConversationVersion: message.ConversationVersion,
+		MessageIndex:        message.MessageIndex,
+		Metadata: &models.MessageMetadata{
+			EndLatencyMs:     int(time.Since(startTime).Milliseconds()),
+			InputTokenCount:  usage.PromptTokens,
+			OutputTokenCount: usage.CompletionTokens,
+			EstimatedTokens:  estimated,
+			Alias:            target.Alias,
+		},
+	}
+	setCost(shadowMessage.Metadata, s.getLLMPrice(target.ProviderID, target.ModelID))
+	return s.Db.Create(shadowMessage).Error
+}
+
+func (s *Service) GetShadows() ([]*models.Shadow, error) {
+	shadows := []*models.Shadow{}
+	tx := s.Db.Order("model ASC").Find(&shadows)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return shadows, nil
+}
+
+// SetShadow creates the shadow models of the model, or replaces them.
+func (s *Service) SetShadow(input models.ShadowCreate) (*models.Shadow, error) {
+	model := strings.TrimSpace(input.Model)
+	if model == "" {
+		return nil, errors.New("the model of the shadow is required")
+	}
+	names := parseModelNames(input.Models, model)
+	if len(names) == 0 {
+		return nil, errors.New("the shadow needs at least one shadow model")
+	}
+	if input.Percent <= 0 || input.Percent > 100 {
+		return nil, errors.New("the percent of the mirrored requests must be above 0 and up to 100")
+	}
+
+	shadow := &models.Shadow{}
+	tx := s.Db.Where("model = ?", model).Limit(1).Find(shadow)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	shadow.Model = model
+	shadow.Models = names
+	shadow.Percent = input.Percent
+	tx = s.Db.Save(shadow)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return shadow, nil
+}
+
+func (s *Service) DeleteShadow(id string) error {
+	// The model of a deleted shadow can be given a new one
+	tx := s.Db.Unscoped().Where("id = ?", id).Delete(&models.Shadow{})
+	return tx.Error
+}
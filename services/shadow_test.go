@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestProxyShadow(t *testing.T) {
+	s, server, requests := newRecordingProxyService(t)
+	defer server.Close()
+
+	_, err := s.SetShadow(models.ShadowCreate{Model: "fake/model", Models: []string{"fake/model"}, Percent: 100})
+	assert.Error(t, err, "Expect a shadow model other than the model")
+	_, err = s.SetShadow(models.ShadowCreate{Model: "fake/model", Models: []string{"fake/shadow"}})
+	assert.Error(t, err, "Expect a percent")
+	_, err = s.SetShadow(models.ShadowCreate{Model: "fake/model", Models: []string{"fake/shadow-a, fake/shadow-b", "missing/model"}, Percent: 100})
+	assert.Nil(t, err)
+
+	messages := []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}
+	_, route, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages}, ProxyOptions{})
+	assert.Nil(t, err)
+	message := &models.Message{Role: "assistant", Content: "Reply", Metadata: &models.MessageMetadata{}}
+	assert.Nil(t, s.AddProxyResponse(route, message))
+	s.shadowRuns.Wait()
+
+	assert.Equal(t, 3, len(*requests), "Expect the request to be mirrored to the shadow models that exist")
+	shadowMessages := []*models.Message{}
+	s.Db.Preload("Metadata").Where("test_message_id = ?", message.ID).Order("llm_id ASC").Find(&shadowMessages)
+	assert.Equal(t, 2, len(shadowMessages))
+	llmIDs := []string{shadowMessages[0].LLMID, shadowMessages[1].LLMID}
+	sort.Strings(llmIDs)
+	assert.Equal(t, []string{"shadow-a", "shadow-b"}, llmIDs)
+	assert.Equal(t, message.MessageIndex, shadowMessages[0].MessageIndex)
+	assert.Equal(t, message.ConversationVersion, shadowMessages[0].ConversationVersion)
+	assert.Equal(t, "Reply", shadowMessages[0].Content)
+	assert.NotNil(t, shadowMessages[0].Metadata)
+
+	// The shadow outputs aren't part of the logged conversation
+	conversation, err := s.GetConversationWithMessages(route.Conversation.ID, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(conversation.Messages))
+
+	// They are compared with the response once the conversation is a test
+	s.Db.Model(&models.Conversation{}).Where("id = ?", conversation.ID).Updates(map[string]any{"is_test": true, "scorer": "exact_match"})
+	test, err := s.GetTest(conversation.ID, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(test.Messages[1].TestMessages), "Expect the outputs with the same content to be shown once")
+	assert.Equal(t, 100.0, test.Messages[1].TestMessages[0].Score)
+
+	shadows, err := s.GetShadows()
+	assert.Nil(t, err)
+	assert.Nil(t, s.DeleteShadow(shadows[0].ID))
+	_, route, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages}, ProxyOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, s.AddProxyResponse(route, &models.Message{Role: "assistant", Content: "Reply"}))
+	s.shadowRuns.Wait()
+	assert.Equal(t, 4, len(*requests))
+}
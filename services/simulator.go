@@ -0,0 +1,334 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sashabaranov/go-openai"
+	"github.com/y2a-labs/evaluate/models"
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
+)
+
+const defaultSimulatorPersona = `You are playing the part of a user talking to an AI assistant.
+Stay in character, work towards your goal and reply with only your next message to the assistant.
+When your goal is met, or the conversation can't go any further, reply with only ` + simulatorDoneMarker + `.`
+
+// simulatorDoneMarker is how the user simulator ends the conversation early.
+const simulatorDoneMarker = "[DONE]"
+
+const defaultSimulatorTurns = 3
+
+// userSimulator plays the user in simulated tests, driven by a persona and goal prompt.
+type userSimulator struct {
+	provider *llmProvider
+	model    string
+	persona  string
+	// turns is the number of assistant turns the tested model takes
+	turns int
+	// reference is the recorded conversation of the test, the transcripts are scored against it
+	reference *models.Message
+}
+
+func (s *Service) newUserSimulator(conversation *models.Conversation) (*userSimulator, error) {
+	if conversation.SimulatorModel == "" {
+		return nil, fmt.Errorf("simulate mode requires a user simulator model")
+	}
+	modelID, providerID, err := s.GetModel(conversation.SimulatorModel)
+	if err != nil {
+		return nil, err
+	}
+	provider, ok := s.llmProviders[providerID]
+	if !ok {
+		return nil, fmt.Errorf("provider not found: %s", providerID)
+	}
+
+	persona := defaultSimulatorPersona
+	if conversation.SimulatorPromptID != "" {
+		prompt, err := s.GetPrompt(conversation.SimulatorPromptID)
+		if err != nil {
+			return nil, fmt.Errorf("persona prompt not found: %w", err)
+		}
+		persona = prompt.Content
+	}
+
+	turns := conversation.SimulatorTurns
+	if turns < 1 {
+		turns = defaultSimulatorTurns
+	}
+	return &userSimulator{provider: provider, model: modelID, persona: persona, turns: turns}, nil
+}
+
+// SetTestSimulator changes the test mode and the user simulator settings of a test.
+func (s *Service) SetTestSimulator(conversationID string, input models.SimulatorUpdate) (*models.Conversation, error) {
+	conversation, err := s.GetConversation(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if input.TestMode == "" {
+		input.TestMode = models.TestModeReplay
+	}
+	if input.TestMode != models.TestModeReplay && input.TestMode != models.TestModeSimulate {
+		return nil, fmt.Errorf("test mode not found: %s", input.TestMode)
+	}
+	if input.SimulatorTurns < 0 {
+		return nil, fmt.Errorf("simulator turns must be a positive number")
+	}
+	conversation.TestMode = input.TestMode
+	conversation.SimulatorModel = input.SimulatorModel
+	conversation.SimulatorPromptID = input.SimulatorPromptID
+	conversation.SimulatorTurns = input.SimulatorTurns
+
+	// Make sure the simulator can be built before saving it
+	if conversation.TestMode == models.TestModeSimulate {
+		if _, err := s.newUserSimulator(conversation); err != nil {
+			return nil, err
+		}
+	}
+
+	tx := s.Db.Model(conversation).Updates(map[string]any{
+		"test_mode":           conversation.TestMode,
+		"simulator_model":     conversation.SimulatorModel,
+		"simulator_prompt_id": conversation.SimulatorPromptID,
+		"simulator_turns":     conversation.SimulatorTurns,
+	})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return conversation, nil
+}
+
+// seedMessages returns the start of the test that the simulated conversations continue from,
+// which is everything before the first assistant message.
+func seedMessages(messages []*models.Message) []*models.Message {
+	for i, message := range messages {
+		if message.Role == "assistant" {
+			return messages[:i]
+		}
+	}
+	return messages
+}
+
+// formatTranscript writes out the conversation without the system messages.
+func formatTranscript(messages []*models.Message) string {
+	builder := strings.Builder{}
+	for _, message := range messages {
+		if message.Role == "system" {
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\n\n")
+		}
+		builder.WriteString(strings.ToUpper(message.Role) + ": " + message.Content)
+	}
+	return builder.String()
+}
+
+// simulateConversation has the user simulator converse with the tested model for the configured number of turns.
+// It returns the transcript as a single message, with the messages of the conversation attached to it, and the
+// number of retries. Every call checks the budget and waits for the rate limit of its provider, and is retried on its own.
+// The calls of both models are added to the budget as they are made, and the cost of the message is their sum.
+func (s *Service) simulateConversation(ctx context.Context, sim *userSimulator, seed []*models.Message, testModel models.TestModels, candidate *llmProvider, limiter *rate.Limiter, budget *testBudget) (*models.Message, int, error) {
+	totalRetries := 0
+	totalCost := 0.0
+	complete := func(provider *llmProvider, limiter *rate.Limiter, price *models.LLM, request openai.ChatCompletionRequest, temperature *float32) (string, openai.Usage, bool, error) {
+		var resp openai.ChatCompletionResponse
+		retries, err := withRetries(ctx, maxTestRetries, func() error {
+			// Wait for permission before making the request
+			if err := limiter.Wait(ctx); err != nil {
+				return fmt.Errorf("rate limiter wait error: %w", err)
+			}
+			if err := budget.check(provider.Provider.ID); err != nil {
+				return err
+			}
+			var err error
+			resp, err = provider.createChatCompletion(withTemperature(ctx, temperature), request)
+			if err == nil && len(resp.Choices) == 0 {
+				// The call failed, an empty message would be taken as the reply
+				return errors.New("no choices returned")
+			}
+			return err
+		})
+		totalRetries += retries
+		if err != nil {
+			return "", openai.Usage{}, false, err
+		}
+		content := resp.Choices[0].Message.Content
+		usage, estimated := EstimateUsage(request, content, resp.Usage)
+		cost := price.Cost(usage.PromptTokens, usage.CompletionTokens)
+		budget.add(provider.Provider.ID, cost)
+		totalCost += cost
+		return content, usage, estimated, nil
+	}
+	simulatorLimiter := s.limiter.GetLimiter(sim.provider.Provider)
+	simulatorPrice := s.getLLMPrice(sim.provider.Provider.ID, sim.model)
+	candidatePrice := s.getLLMPrice(testModel.Provider, testModel.Model)
+	nextUserMessage := func(transcript []*models.Message) (string, bool, error) {
+		content, _, _, err := complete(sim.provider, simulatorLimiter, simulatorPrice, sim.newRequest(transcript), nil)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to get user simulator response: %w", err)
+		}
+		content = strings.TrimSpace(content)
+		return content, content == "" || strings.Contains(content, simulatorDoneMarker), nil
+	}
+
+	startTime := time.Now()
+	transcript := append([]*models.Message{}, seed...)
+	usage := openai.Usage{}
+	estimated := false
+
+	// The simulator opens the conversation when the test doesn't start with a user message
+	if len(transcript) == 0 || transcript[len(transcript)-1].Role != "user" {
+		content, done, err := nextUserMessage(transcript)
+		if err != nil {
+			return nil, totalRetries, err
+		}
+		if done {
+			return nil, totalRetries, fmt.Errorf("the user simulator ended the conversation before it started")
+		}
+		transcript = append(transcript, &models.Message{Role: "user", Content: content})
+	}
+
+	for turn := range sim.turns {
+		// The simulated user can't answer tool calls, so the tools aren't sent
+		request := newChatCompletionRequest(transcript, nil, testModel.Model, testModel.GenerationParams)
+		content, turnUsage, turnEstimated, err := complete(candidate, limiter, candidatePrice, request, testModel.Temperature)
+		if err != nil {
+			return nil, totalRetries, fmt.Errorf("failed to get LLM response: %w", err)
+		}
+		estimated = estimated || turnEstimated
+		usage.PromptTokens += turnUsage.PromptTokens
+		usage.CompletionTokens += turnUsage.CompletionTokens
+		transcript = append(transcript, &models.Message{Role: "assistant", Content: content, LLMID: testModel.Model})
+		if turn == sim.turns-1 {
+			break
+		}
+
+		content, done, err := nextUserMessage(transcript)
+		if err != nil {
+			return nil, totalRetries, err
+		}
+		if done {
+			break
+		}
+		transcript = append(transcript, &models.Message{Role: "user", Content: content})
+	}
+
+	message := &models.Message{
+		Role:       "assistant",
+		Content:    formatTranscript(transcript),
+		Transcript: transcript,
+		Metadata: &models.MessageMetadata{
+			BaseModel:        models.BaseModel{ID: uuid.NewString()},
+			EndLatencyMs:     int(time.Since(startTime).Milliseconds()),
+			OutputTokenCount: usage.CompletionTokens,
+			InputTokenCount:  usage.PromptTokens,
+			EstimatedTokens:  estimated,
+			// The tokens are the ones of the tested model, the cost includes the user simulator
+			Cost: totalCost,
+		},
+	}
+
+	// The transcript only needs an embedding when it is compared to the reference by embedding
+	if sim.reference != nil && sim.reference.Metadata != nil && len(sim.reference.Metadata.Embedding) > 0 {
+		openaiProvider, ok := s.llmProviders["openai"]
+		if !ok {
+			return nil, totalRetries, fmt.Errorf("the cosine scorer requires the openai provider")
+		}
+		embedding, err := createEmbedding(ctx, openaiProvider.client, message.Content)
+		if err != nil {
+			return nil, totalRetries, err
+		}
+		message.Metadata.Embedding = embedding
+	}
+	return message, totalRetries, nil
+}
+
+// newRequest asks for the next user message. The simulator sees the conversation from the other side,
+// so the roles are swapped and the system messages of the test are left out.
+func (sim *userSimulator) newRequest(transcript []*models.Message) openai.ChatCompletionRequest {
+	messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem, Content: sim.persona}}
+	for _, message := range transcript {
+		switch message.Role {
+		case "user":
+			messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: message.Content})
+		case "assistant":
+			messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: message.Content})
+		}
+	}
+	if len(messages) == 1 {
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "Start the conversation."})
+	}
+	return openai.ChatCompletionRequest{Model: sim.model, Messages: messages}
+}
+
+// newSimulationReference formats the recorded conversation of the test so the transcripts can be scored against it.
+func (s *Service) newSimulationReference(ctx context.Context, conversation *models.Conversation, scorer Scorer) (*models.Message, error) {
+	reference := &models.Message{
+		Role:     "assistant",
+		Content:  formatTranscript(conversation.Messages),
+		Metadata: &models.MessageMetadata{},
+	}
+	if scorer.Name() != "cosine" {
+		return reference, nil
+	}
+	openaiProvider, ok := s.llmProviders["openai"]
+	if !ok {
+		return nil, fmt.Errorf("the cosine scorer requires the openai provider")
+	}
+	embedding, err := createEmbedding(ctx, openaiProvider.client, reference.Content)
+	if err != nil {
+		return nil, err
+	}
+	reference.Metadata.Embedding = embedding
+	return reference, nil
+}
+
+// saveTranscripts stores the conversation of every simulated result as a new version of the test, and links the
+// result to it. The messages of a transcript have the test run set, so they only make up their own version.
+func (s *Service) saveTranscripts(input *RunTestInput, results []*models.Message) error {
+	for _, result := range results {
+		if len(result.Transcript) == 0 {
+			continue
+		}
+		// The version is counted in the database, the test may have been edited during the run
+		tx := s.Db.Model(&models.Conversation{}).Where("id = ?", input.Conversation.ID).UpdateColumn("version", gorm.Expr("version + 1"))
+		if tx.Error != nil {
+			return tx.Error
+		}
+		conversation := &models.Conversation{}
+		tx = s.Db.Select("version").Where("id = ?", input.Conversation.ID).First(conversation)
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		messages := make([]*models.Message, len(result.Transcript))
+		for i, message := range result.Transcript {
+			messages[i] = &models.Message{
+				BaseModel:           models.BaseModel{ID: uuid.NewString()},
+				Role:                message.Role,
+				Content:             message.Content,
+				LLMID:               message.LLMID,
+				MessageIndex:        i,
+				ConversationID:      input.Conversation.ID,
+				ConversationVersion: conversation.Version,
+				TestRunID:           input.TestRunID,
+			}
+		}
+		tx = s.Db.Create(messages)
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		result.TranscriptVersion = conversation.Version
+		tx = s.Db.Model(result).Update("transcript_version", result.TranscriptVersion)
+		if tx.Error != nil {
+			return tx.Error
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestSimulatorRequest(t *testing.T) {
+	sim := &userSimulator{model: "sim", persona: "You want a refund."}
+	request := sim.newRequest(nil)
+	assert.Equal(t, "sim", request.Model)
+	assert.Equal(t, 2, len(request.Messages), "Expect the simulator to be asked to start the conversation")
+
+	request = sim.newRequest([]*models.Message{
+		{Role: "system", Content: "You are a support agent."},
+		{Role: "user", Content: "Hello"},
+		{Role: "assistant", Content: "How can I help?"},
+	})
+	assert.Equal(t, []openai.ChatCompletionMessage{
+		{Role: "assistant", Content: "Hello"},
+		{Role: "user", Content: "How can I help?"},
+	}, request.Messages[1:], "Expect the roles to be swapped and the system message of the test left out")
+	assert.Equal(t, "You want a refund.", request.Messages[0].Content)
+
+	assert.Equal(t, "USER: Hello\n\nASSISTANT: Hi", formatTranscript([]*models.Message{
+		{Role: "system", Content: "Be nice."},
+		{Role: "user", Content: "Hello"},
+		{Role: "assistant", Content: "Hi"},
+	}))
+}
+
+func TestRunSimulatedTest(t *testing.T) {
+	var mu sync.Mutex
+	simulatorCalls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/embeddings" {
+			request := openai.EmbeddingRequest{}
+			json.NewDecoder(r.Body).Decode(&request)
+			inputs, _ := request.Input.([]any)
+			response := openai.EmbeddingResponse{}
+			for range inputs {
+				response.Data = append(response.Data, openai.Embedding{Embedding: []float32{1}})
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		request := openai.ChatCompletionRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		content := "Candidate reply"
+		if request.Model == "simulator-model" {
+			mu.Lock()
+			simulatorCalls++
+			content = "Follow up question"
+			// The simulator is done after its second message
+			if simulatorCalls == 2 {
+				content = simulatorDoneMarker
+			}
+			mu.Unlock()
+		}
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: "assistant", Content: content}}},
+			Usage:   openai.Usage{PromptTokens: 10, CompletionTokens: 5},
+		})
+	}))
+	defer server.Close()
+	config := openai.DefaultConfig("key")
+	config.BaseURL = server.URL
+
+	s := New(":memory:", "../.env")
+	provider := &models.Provider{BaseModel: models.BaseModel{ID: "fake"}, Requests: 100}
+	s.llmProviders = map[string]*llmProvider{
+		"fake":   {Provider: provider, client: openai.NewClientWithConfig(config)},
+		"openai": {Provider: provider, client: openai.NewClientWithConfig(config)},
+	}
+	_, err := s.CreateLLM(models.LLMCreate{ID: "simulator-model", ProviderID: "fake"})
+	assert.Nil(t, err)
+	_, err = s.CreateLLM(models.LLMCreate{ID: "candidate-model", ProviderID: "fake"})
+	assert.Nil(t, err)
+	s.Db.Model(&models.LLM{}).Where("id = ?", "simulator-model").Updates(map[string]any{"input_price": 2, "output_price": 2})
+	s.Db.Model(&models.LLM{}).Where("id = ?", "candidate-model").Updates(map[string]any{"input_price": 1, "output_price": 1})
+	conversation, err := s.CreateConversation(models.ConversationCreate{Name: "support", IsTest: true})
+	assert.Nil(t, err)
+	_, err = s.AddMessagesToConversation(conversation, []models.ChatCompletionMessage{
+		{Role: "user", Content: "My order is late"},
+		{Role: "assistant", Content: "Sorry about that"},
+		{Role: "user", Content: "When will it arrive?"},
+		{Role: "assistant", Content: "Tomorrow"},
+	})
+	assert.Nil(t, err)
+	conversation.TestModels = []models.TestModels{{Provider: "fake", Model: "candidate-model"}}
+	tx := s.Db.Model(conversation).Update("test_models", conversation.TestModels)
+	assert.Nil(t, tx.Error)
+
+	_, err = s.SetTestSimulator(conversation.ID, models.SimulatorUpdate{TestMode: "unknown"})
+	assert.Error(t, err)
+	_, err = s.SetTestSimulator(conversation.ID, models.SimulatorUpdate{TestMode: models.TestModeSimulate})
+	assert.Error(t, err, "Expect simulate mode to require a simulator model")
+	_, err = s.SetTestSimulator(conversation.ID, models.SimulatorUpdate{
+		TestMode:       models.TestModeSimulate,
+		SimulatorModel: "simulator-model",
+		SimulatorTurns: 3,
+	})
+	assert.Nil(t, err)
+
+	testRun, err := s.ExecuteTestWorkflow(ExecuteTestInput{Context: context.Background(), ConversationID: conversation.ID, RunCount: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, models.TestModeSimulate, testRun.TestMode)
+	assert.Equal(t, 1, len(testRun.Messages), "Expect a single result for the whole conversation")
+
+	result := testRun.Messages[0]
+	assert.Equal(t, "USER: My order is late\n\nASSISTANT: Candidate reply\n\nUSER: Follow up question\n\nASSISTANT: Candidate reply", result.Content, "Expect the conversation to stop when the simulator is done")
+	assert.Equal(t, 10, result.Metadata.OutputTokenCount)
+	// Two calls of the tested model and two of the user simulator, of 15 tokens each
+	assert.InDelta(t, 90.0/1_000_000, result.Metadata.Cost, 1e-12, "Expect the cost to include the user simulator")
+	assert.NotZero(t, result.TranscriptVersion)
+
+	// The transcript is a version of the test
+	transcript, err := s.GetConversationWithMessages(conversation.ID, result.TranscriptVersion)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(transcript.Messages))
+	assert.Equal(t, "Candidate reply", transcript.Messages[1].Content)
+	assert.Equal(t, "assistant", transcript.Messages[3].Role)
+	assert.Equal(t, testRun.ID, transcript.Messages[3].TestRunID)
+
+	// And the test keeps its own messages, so the next run is seeded the same way
+	test, err := s.GetConversationWithMessages(conversation.ID, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, testRun.ConversationVersion, test.SelectedVersion)
+	assert.Equal(t, 4, len(test.Messages))
+	assert.Equal(t, "Sorry about that", test.Messages[1].Content)
+	assert.Equal(t, "Tomorrow", test.Messages[3].Content)
+	tests, err := s.GetTestList()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tests))
+}
+
+func TestRunSimulatedTestWithoutChoices(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{})
+	}))
+	defer server.Close()
+	config := openai.DefaultConfig("key")
+	config.BaseURL = server.URL
+
+	s := New(":memory:", "../.env")
+	provider := &models.Provider{BaseModel: models.BaseModel{ID: "fake"}, Requests: 100}
+	s.llmProviders = map[string]*llmProvider{"fake": {Provider: provider, client: openai.NewClientWithConfig(config)}}
+	_, err := s.CreateLLM(models.LLMCreate{ID: "simulator-model", ProviderID: "fake"})
+	assert.Nil(t, err)
+	conversation, err := s.CreateConversation(models.ConversationCreate{Name: "support", IsTest: true, Messages: []openai.ChatCompletionMessage{
+		{Role: "user", Content: "My order is late"},
+		{Role: "assistant", Content: "Sorry about that"},
+	}})
+	assert.Nil(t, err)
+	conversation.TestModels = []models.TestModels{{Provider: "fake", Model: "candidate-model"}}
+	conversation.Scorer = "exact_match"
+	tx := s.Db.Model(conversation).Updates(map[string]any{"test_models": conversation.TestModels, "scorer": conversation.Scorer})
+	assert.Nil(t, tx.Error)
+	_, err = s.SetTestSimulator(conversation.ID, models.SimulatorUpdate{TestMode: models.TestModeSimulate, SimulatorModel: "simulator-model"})
+	assert.Nil(t, err)
+
+	// A turn without choices fails the conversation instead of being taken as an empty reply
+	_, err = s.ExecuteTestWorkflow(ExecuteTestInput{Context: context.Background(), ConversationID: conversation.ID, RunCount: 1})
+	assert.ErrorContains(t, err, "no choices returned")
+	testRun, err := s.GetLastTestRun(conversation.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, testRun.ResultCount)
+	assert.Equal(t, 1, testRun.ErrorCount)
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"errors"
+	"hash/fnv"
+	"strings"
+
+	"github.com/y2a-labs/evaluate/models"
+)
+
+// splitBucket places the key in one of 10000 buckets, so the same user or conversation always gets the same arm.
+func splitBucket(splitID, key string) float64 {
+	hash := fnv.New32a()
+	hash.Write([]byte(splitID + "\x00" + key))
+	return float64(hash.Sum32()%10000) / 100
+}
+
+// splitTraffic assigns the conversation to an arm of the traffic split of the requested model, and records it.
+// The candidate model is put first for the conversations of the candidate arm, the requested model is then
+// its fallback. The targets are marked with the arm they answer for, so a response is counted under the model
+// that served it.
+func (s *Service) splitTraffic(model string, conversation *models.Conversation, options ProxyOptions, targets []proxyTarget) ([]proxyTarget, error) {
+	split := &models.TrafficSplit{}
+	tx := s.Db.Where("model = ?", model).Limit(1).Find(split)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return targets, nil
+	}
+
+	arm := conversation.SplitArm
+	if conversation.SplitModel != split.Model || arm == "" {
+		// Users keep their arm across conversations
+		key := options.User
+		if key == "" {
+			key = conversation.ID
+		}
+		arm = models.SplitArmControl
+		if splitBucket(split.ID, key) < split.Percent {
+			arm = models.SplitArmCandidate
+		}
+		conversation.SplitModel = split.Model
+		conversation.SplitArm = arm
+		tx = s.Db.Model(conversation).Updates(map[string]any{"split_model": split.Model, "split_arm": arm})
+		if tx.Error != nil {
+			return nil, tx.Error
+		}
+	}
+
+	control := make([]proxyTarget, len(targets))
+	for i, target := range targets {
+		target.SplitArm = models.SplitArmControl
+		control[i] = target
+	}
+	if arm != models.SplitArmCandidate {
+		return control, nil
+	}
+	candidate := s.resolveProxyTarget(split.Candidate, ProxyOptions{})
+	candidate.SplitArm = models.SplitArmCandidate
+	s.checkTarget(&candidate)
+	return append([]proxyTarget{candidate}, control...), nil
+}
+
+func (s *Service) GetTrafficSplits() ([]*models.TrafficSplit, error) {
+	splits := []*models.TrafficSplit{}
+	tx := s.Db.Order("model ASC").Find(&splits)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	for _, split := range splits {
+		arms, err := s.getSplitArmStats(split)
+		if err != nil {
+			return nil, err
+		}
+		split.Arms = arms
+	}
+	return splits, nil
+}
+
+// getSplitArmStats compares the latency, the cost and the scores of the logged responses of the arms. A response
+// counts for the arm whose model served it, the responses logged before it was recorded count for the arm of
+// their conversation.
+func (s *Service) getSplitArmStats(split *models.TrafficSplit) ([]models.SplitArmStats, error) {
+	arms := []models.SplitArmStats{
+		{Arm: models.SplitArmControl, Model: split.Model},
+		{Arm: models.SplitArmCandidate, Model: split.Candidate},
+	}
+	for i := range arms {
+		tx := s.Db.Model(&models.Message{}).
+			Select(`COUNT(DISTINCT messages.conversation_id) AS conversations,
+				COUNT(messages.id) AS responses,
+				COALESCE(AVG(message_metadata.end_latency_ms), 0) AS avg_latency_ms,
+				COALESCE(SUM(message_metadata.cost), 0) AS cost,
+				COALESCE(AVG(CASE WHEN message_metadata.scorer <> '' THEN message_metadata.score END), 0) AS avg_score,
+				COUNT(CASE WHEN message_metadata.scorer <> '' THEN 1 END) AS scored`).
+			Joins("JOIN conversations ON conversations.id = messages.conversation_id").
+			Joins("LEFT JOIN message_metadata ON message_metadata.message_id = messages.id AND message_metadata.deleted_at IS NULL").
+			Where("conversations.split_model = ? AND COALESCE(NULLIF(message_metadata.split_arm, ''), conversations.split_arm) = ?", split.Model, arms[i].Arm).
+			Where("messages.role = ? AND messages.test_message_id = ''", "assistant").
+			Scan(&arms[i])
+		if tx.Error != nil {
+			return nil, tx.Error
+		}
+	}
+	return arms, nil
+}
+
+// SetTrafficSplit creates the traffic split of the model, or changes its candidate and percent.
+func (s *Service) SetTrafficSplit(input models.TrafficSplitCreate) (*models.TrafficSplit, error) {
+	model := strings.TrimSpace(input.Model)
+	candidate := strings.TrimSpace(input.Candidate)
+	if model == "" || candidate == "" {
+		return nil, errors.New("the model and the candidate of the traffic split are required")
+	}
+	if model == candidate {
+		return nil, errors.New("the candidate of the traffic split must be another model")
+	}
+	if input.Percent < 0 || input.Percent > 100 {
+		return nil, errors.New("the percent of the traffic split must be between 0 and 100")
+	}
+
+	split := &models.TrafficSplit{}
+	tx := s.Db.Where("model = ?", model).Limit(1).Find(split)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	split.Model = model
+	split.Candidate = candidate
+	split.Percent = input.Percent
+	tx = s.Db.Save(split)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return split, nil
+}
+
+func (s *Service) DeleteTrafficSplit(id string) error {
+	// The model of a deleted split can be given a new one
+	tx := s.Db.Unscoped().Where("id = ?", id).Delete(&models.TrafficSplit{})
+	return tx.Error
+}
+
+// ScoreMessage saves a score of a logged response that was given after the fact, like the feedback of its user.
+func (s *Service) ScoreMessage(id string, input models.MessageScore) (*models.MessageMetadata, error) {
+	if input.Score < 0 || input.Score > 1 {
+		return nil, errors.New("the score must be between 0 and 1")
+	}
+	if input.Scorer == "" {
+		input.Scorer = "feedback"
+	}
+	message, err := s.GetMessage(id)
+	if err != nil {
+		return nil, err
+	}
+	metadata := message.Metadata
+	if metadata == nil {
+		metadata = &models.MessageMetadata{MessageID: message.ID}
+	}
+	metadata.Score = input.Score
+	metadata.Scorer = input.Scorer
+	metadata.ScoreRationale = input.ScoreRationale
+	tx := s.Db.Save(metadata)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return metadata, nil
+}
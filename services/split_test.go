@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestSplitBucket(t *testing.T) {
+	assert.Equal(t, splitBucket("split", "user-1"), splitBucket("split", "user-1"))
+	candidates := 0
+	for i := range 1000 {
+		if splitBucket("split", fmt.Sprintf("user-%d", i)) < 10 {
+			candidates++
+		}
+	}
+	assert.InDelta(t, 100, candidates, 30, "Expect about a tenth of the users in the candidate arm")
+}
+
+func TestProxyTrafficSplit(t *testing.T) {
+	s, server, requests := newRecordingProxyService(t)
+	defer server.Close()
+
+	_, err := s.SetTrafficSplit(models.TrafficSplitCreate{Model: "fake/model", Candidate: "fake/model", Percent: 10})
+	assert.Error(t, err)
+	_, err = s.SetTrafficSplit(models.TrafficSplitCreate{Model: "fake/model", Candidate: "fake/cheap", Percent: 101})
+	assert.Error(t, err)
+	_, err = s.SetTrafficSplit(models.TrafficSplitCreate{Model: "fake/model", Candidate: "fake/cheap", Percent: 100})
+	assert.Nil(t, err)
+
+	messages := []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}
+	_, route, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages}, ProxyOptions{User: "user-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "cheap", (*requests)[0].Model)
+	assert.Equal(t, models.SplitArmCandidate, route.Conversation.SplitArm)
+	assert.False(t, route.FellBack())
+	message := &models.Message{Role: "assistant", Content: "Reply", Metadata: &models.MessageMetadata{EndLatencyMs: 100}}
+	assert.Nil(t, s.AddProxyResponse(route, message))
+	_, err = s.ScoreMessage(message.ID, models.MessageScore{Score: 0.8})
+	assert.Nil(t, err)
+	_, err = s.ScoreMessage(message.ID, models.MessageScore{Score: 80})
+	assert.Error(t, err)
+
+	// The conversation keeps its arm when the percent changes
+	_, err = s.SetTrafficSplit(models.TrafficSplitCreate{Model: "fake/model", Candidate: "fake/cheap", Percent: 0})
+	assert.Nil(t, err)
+	messages = append(messages, openai.ChatCompletionMessage{Role: "assistant", Content: "Reply"}, openai.ChatCompletionMessage{Role: "user", Content: "Again"})
+	_, next, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages}, ProxyOptions{User: "user-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, route.Conversation.ID, next.Conversation.ID)
+	assert.Equal(t, "cheap", (*requests)[1].Model)
+
+	_, control, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages[:1]}, ProxyOptions{User: "user-2", ConversationID: "other"})
+	assert.Nil(t, err)
+	assert.Equal(t, "model", (*requests)[2].Model)
+	assert.Equal(t, models.SplitArmControl, control.Conversation.SplitArm)
+	assert.Nil(t, s.AddProxyResponse(control, &models.Message{Role: "assistant", Content: "Reply", Metadata: &models.MessageMetadata{EndLatencyMs: 300}}))
+
+	// A candidate that falls back to the requested model is counted under the model that served the response
+	_, err = s.SetTrafficSplit(models.TrafficSplitCreate{Model: "fake/model", Candidate: "gone/cheap", Percent: 100})
+	assert.Nil(t, err)
+	_, fellBack, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages[:1]}, ProxyOptions{User: "user-3", ConversationID: "third"})
+	assert.Nil(t, err)
+	assert.Equal(t, models.SplitArmCandidate, fellBack.Conversation.SplitArm)
+	assert.Equal(t, "model", fellBack.ModelID)
+	assert.Nil(t, s.AddProxyResponse(fellBack, &models.Message{Role: "assistant", Content: "Reply", Metadata: &models.MessageMetadata{EndLatencyMs: 500}}))
+
+	splits, err := s.GetTrafficSplits()
+	assert.Nil(t, err)
+	assert.Equal(t, models.SplitArmStats{Arm: "control", Model: "fake/model", Conversations: 2, Responses: 2, AvgLatencyMs: 400}, splits[0].Arms[0])
+	assert.Equal(t, models.SplitArmStats{Arm: "candidate", Model: "gone/cheap", Conversations: 1, Responses: 1, AvgLatencyMs: 100, AvgScore: 0.8, Scored: 1}, splits[0].Arms[1])
+
+	assert.Nil(t, s.DeleteTrafficSplit(splits[0].ID))
+	_, _, err = s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Messages: messages}, ProxyOptions{User: "user-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "model", (*requests)[3].Model, "Expect the requests to go to the model once the split is deleted")
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/y2a-labs/evaluate/models"
+)
+
+// testJobRetention is how long a finished job is kept so late listeners still get its final progress.
+var testJobRetention = 10 * time.Minute
+
+// TestJob is a test run happening in the background. It has the same ID as its test run.
+type TestJob struct {
+	ID             string
+	ConversationID string
+
+	cancel    context.CancelFunc
+	mu        sync.Mutex
+	progress  TestJobProgress
+	listeners map[chan struct{}]bool
+}
+
+type TestJobProgress struct {
+	JobID  string          `json:"job_id"`
+	Status string          `json:"status"`
+	Error  string          `json:"error,omitempty"`
+	Models []ModelProgress `json:"models"`
+}
+
+// ModelProgress counts the calls of a single tested model entry in a job.
+type ModelProgress struct {
+	TestModelID string `json:"test_model_id"`
+	LLMID       string `json:"llm_id"`
+	Settings    string `json:"settings,omitempty"`
+	Completed   int    `json:"completed"`
+	Failed      int    `json:"failed"`
+	Total       int    `json:"total"`
+}
+
+// Done reports whether the job has stopped running.
+func (p TestJobProgress) Done() bool {
+	return p.Status != models.TestRunStatusRunning
+}
+
+func newTestJob(testRun *models.TestRun, input *RunTestInput, cancel context.CancelFunc) *TestJob {
+	job := &TestJob{
+		ID:             testRun.ID,
+		ConversationID: testRun.ConversationID,
+		cancel:         cancel,
+		listeners:      map[chan struct{}]bool{},
+		progress: TestJobProgress{
+			JobID:  testRun.ID,
+			Status: models.TestRunStatusRunning,
+			Models: make([]ModelProgress, len(input.TestModels)),
+		},
+	}
+	promptCount := max(1, len(input.Prompts))
+	for i, testModel := range input.TestModels {
+		job.progress.Models[i] = ModelProgress{
+			TestModelID: testModel.Key(),
+			LLMID:       testModel.Model,
+			Settings:    testModel.GenerationParams.String(),
+			Total:       len(input.TestIndexes) * input.RunCount * promptCount,
+		}
+	}
+	return job
+}
+
+// Progress returns a copy of the current progress of the job.
+func (j *TestJob) Progress() TestJobProgress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	progress := j.progress
+	progress.Models = append([]ModelProgress{}, j.progress.Models...)
+	return progress
+}
+
+// Subscribe returns a channel that receives a value whenever the progress changes.
+// Call the returned function to stop listening.
+func (j *TestJob) Subscribe() (<-chan struct{}, func()) {
+	updates := make(chan struct{}, 1)
+	j.mu.Lock()
+	j.listeners[updates] = true
+	j.mu.Unlock()
+	return updates, func() {
+		j.mu.Lock()
+		delete(j.listeners, updates)
+		j.mu.Unlock()
+	}
+}
+
+// Cancel stops the job, the calls that are in flight are cancelled.
+func (j *TestJob) Cancel() {
+	j.cancel()
+}
+
+// notify tells the listeners that the progress changed, it must be called with the lock held.
+func (j *TestJob) notify() {
+	for listener := range j.listeners {
+		// Listeners read the latest progress, so a pending update is enough
+		select {
+		case listener <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// track counts the results as they go through to be saved.
+func (j *TestJob) track(results chan TestResult) chan TestResult {
+	tracked := make(chan TestResult, cap(results))
+	go func() {
+		defer close(tracked)
+		for result := range results {
+			j.record(result)
+			tracked <- result
+		}
+	}()
+	return tracked
+}
+
+func (j *TestJob) record(result TestResult) {
+	// Calls stopped by cancelling the job are neither completed nor failed
+	if errors.Is(result.Err, context.Canceled) {
+		return
+	}
+	key := result.TestModelID
+	if result.Message != nil {
+		key = result.Message.TestModelKey()
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i, model := range j.progress.Models {
+		if model.TestModelID != key {
+			continue
+		}
+		if result.Err != nil {
+			j.progress.Models[i].Failed++
+		} else {
+			j.progress.Models[i].Completed++
+		}
+		break
+	}
+	j.notify()
+}
+
+func (j *TestJob) finish(testRun *models.TestRun, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress.Status = testRun.Status
+	if j.progress.Status == models.TestRunStatusRunning {
+		// The run couldn't be saved
+		j.progress.Status = models.TestRunStatusFailed
+	}
+	if err != nil && testRun.Status != models.TestRunStatusCancelled {
+		j.progress.Error = err.Error()
+	}
+	j.notify()
+}
+
+// StartTestJob starts running a test in the background and returns the job right away.
+// Errors with the input are returned before the job starts.
+func (s *Service) StartTestJob(input ExecuteTestInput) (*TestJob, error) {
+	// The job outlives the request that started it, so it gets its own context
+	ctx, cancel := context.WithCancel(context.Background())
+	input.Context = ctx
+	preparedInput, testRun, err := s.startTestRun(input)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	job := newTestJob(testRun, preparedInput, cancel)
+	s.testJobsMu.Lock()
+	if s.testJobs == nil {
+		s.testJobs = map[string]*TestJob{}
+	}
+	s.testJobs[job.ID] = job
+	s.testJobsMu.Unlock()
+
+	go func() {
+		defer cancel()
+		_, err := s.executeTestRun(preparedInput, testRun, job)
+		job.finish(testRun, err)
+
+		time.AfterFunc(testJobRetention, func() {
+			s.testJobsMu.Lock()
+			delete(s.testJobs, job.ID)
+			s.testJobsMu.Unlock()
+		})
+	}()
+	return job, nil
+}
+
+// GetTestJob returns a job that is running or finished recently.
+func (s *Service) GetTestJob(id string) (*TestJob, error) {
+	s.testJobsMu.Lock()
+	defer s.testJobsMu.Unlock()
+	job, ok := s.testJobs[id]
+	if !ok {
+		return nil, fmt.Errorf("test job not found: %s", id)
+	}
+	return job, nil
+}
+
+// CancelTestJob cancels a running job.
+func (s *Service) CancelTestJob(id string) error {
+	job, err := s.GetTestJob(id)
+	if err != nil {
+		return err
+	}
+	job.Cancel()
+	return nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestTestJobProgress(t *testing.T) {
+	job := newTestJob(
+		&models.TestRun{BaseModel: models.BaseModel{ID: "job"}},
+		&RunTestInput{TestModels: []models.TestModels{{Model: "a"}, {Model: "b"}}, TestIndexes: []int{2, 4}, RunCount: 3},
+		func() {},
+	)
+	updates, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+
+	results := make(chan TestResult, 4)
+	results <- TestResult{Message: &models.Message{LLMID: "a"}}
+	results <- TestResult{LLMID: "b", TestModelID: "b", Err: errors.New("provider error")}
+	results <- TestResult{LLMID: "b", TestModelID: "b", Err: context.Canceled}
+	results <- TestResult{Message: &models.Message{LLMID: "b"}}
+	close(results)
+	count := 0
+	for range job.track(results) {
+		count++
+	}
+	assert.Equal(t, 4, count, "Expect every result to go through to be saved")
+
+	<-updates
+	progress := job.Progress()
+	assert.False(t, progress.Done())
+	assert.Equal(t, ModelProgress{TestModelID: "a", LLMID: "a", Completed: 1, Total: 6}, progress.Models[0])
+	assert.Equal(t, ModelProgress{TestModelID: "b", LLMID: "b", Completed: 1, Failed: 1, Total: 6}, progress.Models[1], "Expect cancelled calls to not count as failed")
+
+	job.finish(&models.TestRun{Status: models.TestRunStatusPartial}, nil)
+	assert.True(t, job.Progress().Done())
+	assert.Equal(t, models.TestRunStatusPartial, job.Progress().Status)
+}
+
+func TestCancelTestJob(t *testing.T) {
+	// The provider never answers, so the calls only stop when the job is cancelled
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	config := openai.DefaultConfig("key")
+	config.BaseURL = server.URL
+
+	s := New(":memory:", "../.env")
+	provider := &models.Provider{BaseModel: models.BaseModel{ID: "slow"}, Requests: 100}
+	s.llmProviders = map[string]*llmProvider{
+		"slow":   {Provider: provider, client: openai.NewClientWithConfig(config)},
+		"openai": {Provider: provider, client: openai.NewClientWithConfig(config)},
+	}
+	_, err := s.CreateLLM(models.LLMCreate{ID: "slow-model", ProviderID: "slow"})
+	assert.Nil(t, err)
+	conversation, err := s.CreateConversation(models.ConversationCreate{Name: "job", IsTest: true})
+	assert.Nil(t, err)
+	_, err = s.AddMessagesToConversation(conversation, []models.ChatCompletionMessage{
+		{Role: "user", Content: "Hello"},
+		{Role: "assistant", Content: "Hi there"},
+	})
+	assert.Nil(t, err)
+	conversation.TestModels = []models.TestModels{{Provider: "slow", Model: "slow-model"}}
+	tx := s.Db.Model(conversation).Update("test_models", conversation.TestModels)
+	assert.Nil(t, tx.Error)
+
+	_, err = s.StartTestJob(ExecuteTestInput{ConversationID: conversation.ID})
+	assert.Error(t, err, "Expect invalid input to fail before the job starts")
+
+	job, err := s.StartTestJob(ExecuteTestInput{ConversationID: conversation.ID, RunCount: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, job.Progress().Models[0].Total)
+
+	updates, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+	assert.Nil(t, s.CancelTestJob(job.ID))
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job did not stop after being cancelled")
+	}
+
+	progress := job.Progress()
+	assert.Equal(t, models.TestRunStatusCancelled, progress.Status)
+	assert.Equal(t, 0, progress.Models[0].Failed)
+
+	testRun, _, err := s.GetTestRun(job.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, models.TestRunStatusCancelled, testRun.Status)
+	assert.NotNil(t, testRun.EndedAt)
+
+	assert.Error(t, s.CancelTestJob("unknown"))
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/y2a-labs/evaluate/models"
+)
+
+// SetTestPrompts sets the prompt versions a test is run with. Without any, the test runs with its own system message.
+func (s *Service) SetTestPrompts(conversationID string, promptIDs []string) (*models.Conversation, error) {
+	conversation, err := s.GetConversation(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.getTestPrompts(promptIDs); err != nil {
+		return nil, err
+	}
+	conversation.TestPromptIDs = promptIDs
+	tx := s.Db.Model(conversation).Update("test_prompt_ids", conversation.TestPromptIDs)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return conversation, nil
+}
+
+// getTestPrompts returns the prompts in the order of the IDs.
+func (s *Service) getTestPrompts(promptIDs []string) ([]*models.Prompt, error) {
+	if len(promptIDs) == 0 {
+		return nil, nil
+	}
+	found := []*models.Prompt{}
+	tx := s.Db.Where("id IN ?", promptIDs).Find(&found)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	promptsByID := map[string]*models.Prompt{}
+	for _, prompt := range found {
+		promptsByID[prompt.ID] = prompt
+	}
+
+	prompts := make([]*models.Prompt, len(promptIDs))
+	for i, id := range promptIDs {
+		prompt, ok := promptsByID[id]
+		if !ok {
+			return nil, fmt.Errorf("prompt not found: %s", id)
+		}
+		prompts[i] = prompt
+	}
+	return prompts, nil
+}
+
+// applyPrompt returns the messages with the prompt as the system message.
+// The conversation's own system message is replaced, or the prompt is added at the start if there is none.
+func applyPrompt(messages []*models.Message, prompt *models.Prompt) []*models.Message {
+	if prompt == nil {
+		return messages
+	}
+	system := &models.Message{Role: "system", Content: prompt.Content}
+	if len(messages) > 0 && messages[0].Role == "system" {
+		return append([]*models.Message{system}, messages[1:]...)
+	}
+	return append([]*models.Message{system}, messages...)
+}
+
+// newPromptScores creates an empty score for every prompt version and test model.
+func newPromptScores(prompts []*models.Prompt, testModels []models.TestModels) []models.PromptScore {
+	promptScores := make([]models.PromptScore, len(prompts))
+	for i, prompt := range prompts {
+		promptScores[i] = models.PromptScore{
+			PromptID: prompt.ID,
+			Label:    prompt.Label(),
+			Models:   make([]models.TestModels, len(testModels)),
+		}
+		for j, testModel := range testModels {
+			testModel.Score = 0
+			promptScores[i].Models[j] = testModel
+		}
+	}
+	return promptScores
+}
+
+// scorePromptVariants averages the scores of the results for each prompt version and test model.
+// Results that weren't scored are left out.
+func scorePromptVariants(promptScores []models.PromptScore, results []*models.Message) {
+	scoreSum := map[string]float64{}
+	scoreCount := map[string]int{}
+	for _, result := range results {
+		if result == nil || result.Metadata == nil || result.Metadata.Scorer == "" {
+			continue
+		}
+		scoreSum[result.PromptID] += result.Metadata.Score
+		scoreCount[result.PromptID]++
+		key := result.PromptID + "/" + result.TestModelKey()
+		scoreSum[key] += result.Metadata.Score
+		scoreCount[key]++
+	}
+
+	for i, promptScore := range promptScores {
+		if scoreCount[promptScore.PromptID] > 0 {
+			promptScores[i].Score = roundScore(scoreSum[promptScore.PromptID] / float64(scoreCount[promptScore.PromptID]))
+		}
+		for j, testModel := range promptScore.Models {
+			key := promptScore.PromptID + "/" + testModel.Key()
+			if scoreCount[key] > 0 {
+				promptScores[i].Models[j].Score = roundScore(scoreSum[key] / float64(scoreCount[key]))
+			}
+		}
+	}
+}
+
+// groupTestMessagesByPrompt labels the test messages with their prompt version and groups them in the order of the prompts.
+func groupTestMessagesByPrompt(promptScores []models.PromptScore, message *models.Message) {
+	if len(promptScores) == 0 {
+		return
+	}
+	groups := make([]models.TestMessageGroup, len(promptScores))
+	groupIndex := map[string]int{}
+	for i, promptScore := range promptScores {
+		groups[i].PromptLabel = promptScore.Label
+		groupIndex[promptScore.PromptID] = i
+	}
+
+	other := models.TestMessageGroup{PromptLabel: "Test system message"}
+	for _, testMessage := range message.TestMessages {
+		i, ok := groupIndex[testMessage.PromptID]
+		if !ok {
+			// Generated before the prompt versions were set
+			testMessage.PromptLabel = other.PromptLabel
+			other.Messages = append(other.Messages, testMessage)
+			continue
+		}
+		testMessage.PromptLabel = groups[i].PromptLabel
+		groups[i].Messages = append(groups[i].Messages, testMessage)
+	}
+
+	message.TestMessageGroups = []models.TestMessageGroup{}
+	for _, group := range append(groups, other) {
+		if len(group.Messages) > 0 {
+			message.TestMessageGroups = append(message.TestMessageGroups, group)
+		}
+	}
+}
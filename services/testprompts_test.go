@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestApplyPrompt(t *testing.T) {
+	prompt := &models.Prompt{Content: "Be brief."}
+	messages := []*models.Message{{Role: "system", Content: "Be verbose."}, {Role: "user", Content: "Hello"}}
+
+	result := applyPrompt(messages, prompt)
+	assert.Equal(t, 2, len(result))
+	assert.Equal(t, "Be brief.", result[0].Content, "Expect the system message to be replaced")
+	assert.Equal(t, "Be verbose.", messages[0].Content, "Expect the test messages to be left as they are")
+
+	result = applyPrompt(messages[1:], prompt)
+	assert.Equal(t, 2, len(result))
+	assert.Equal(t, "system", result[0].Role, "Expect the prompt to be added when there is no system message")
+
+	assert.Equal(t, messages, applyPrompt(messages, nil))
+}
+
+func TestSetTestPrompts(t *testing.T) {
+	s := New(":memory:", "../.env")
+	_, err := s.CreatePrompt(models.PromptCreate{ID: "support", Content: "Be brief."})
+	assert.Nil(t, err)
+	_, err = s.UpdatePrompt("support", models.PromptUpdate{Content: "Be brief and kind."})
+	assert.Nil(t, err)
+	versions := []*models.Prompt{}
+	s.Db.Where("base_prompt_id = ?", "support").Find(&versions)
+	assert.Equal(t, 1, len(versions))
+	conversation, err := s.CreateConversation(models.ConversationCreate{Name: "prompts", IsTest: true})
+	assert.Nil(t, err)
+
+	_, err = s.SetTestPrompts(conversation.ID, []string{"support", "unknown"})
+	assert.Error(t, err)
+
+	_, err = s.SetTestPrompts(conversation.ID, []string{"support", versions[0].ID})
+	assert.Nil(t, err)
+	conversation, err = s.GetConversation(conversation.ID)
+	assert.Nil(t, err)
+	prompts, err := s.getTestPrompts(conversation.TestPromptIDs)
+	assert.Nil(t, err)
+	assert.Equal(t, "support v1", prompts[0].Label())
+	assert.Equal(t, "support v0", prompts[1].Label(), "Expect older versions to be named after their prompt")
+}
+
+func TestRunTestPromptMatrix(t *testing.T) {
+	var mu sync.Mutex
+	systemPrompts := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/embeddings" {
+			json.NewEncoder(w).Encode(openai.EmbeddingResponse{Data: []openai.Embedding{{Embedding: []float32{1}}}})
+			return
+		}
+		request := openai.ChatCompletionRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		mu.Lock()
+		systemPrompts[request.Messages[0].Content]++
+		mu.Unlock()
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: "assistant", Content: request.Messages[0].Content}}},
+		})
+	}))
+	defer server.Close()
+	config := openai.DefaultConfig("key")
+	config.BaseURL = server.URL
+
+	s := New(":memory:", "../.env")
+	provider := &models.Provider{BaseModel: models.BaseModel{ID: "fake"}, Requests: 100}
+	s.llmProviders = map[string]*llmProvider{
+		"fake":   {Provider: provider, client: openai.NewClientWithConfig(config)},
+		"openai": {Provider: provider, client: openai.NewClientWithConfig(config)},
+	}
+
+	prompts := []*models.Prompt{
+		{BaseModel: models.BaseModel{ID: "a"}, Content: "Prompt A"},
+		{BaseModel: models.BaseModel{ID: "b"}, Content: "Prompt B"},
+	}
+	testModels := []models.TestModels{{Provider: "fake", Model: "x"}, {Provider: "fake", Model: "y"}}
+	resultsChan, count, err := s.runTest(&RunTestInput{
+		Context: context.Background(),
+		Conversation: &models.Conversation{Messages: []*models.Message{
+			{Role: "system", Content: "Original"},
+			{Role: "user", Content: "Hello"},
+			{Role: "assistant", Content: "Prompt A"},
+		}},
+		TestIndexes: []int{2},
+		RunCount:    1,
+		TestModels:  testModels,
+		Prompts:     prompts,
+		Scorer:      exactMatchScorer{},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, count, "Expect every prompt version to run against every model")
+
+	results := []*models.Message{}
+	for result := range resultsChan {
+		assert.Nil(t, result.Err)
+		results = append(results, result.Message)
+	}
+	assert.Equal(t, map[string]int{"Prompt A": 2, "Prompt B": 2}, systemPrompts)
+
+	promptScores := newPromptScores(prompts, testModels)
+	scorePromptVariants(promptScores, results)
+	assert.Equal(t, 100.0, promptScores[0].Score)
+	assert.Equal(t, 100.0, promptScores[0].Models[1].Score)
+	assert.Equal(t, 0.0, promptScores[1].Score)
+
+	message := &models.Message{TestMessages: append(results, &models.Message{LLMID: "x"})}
+	groupTestMessagesByPrompt(promptScores, message)
+	assert.Equal(t, 3, len(message.TestMessageGroups))
+	assert.Equal(t, "a v0", message.TestMessageGroups[0].PromptLabel)
+	assert.Equal(t, 2, len(message.TestMessageGroups[0].Messages))
+	assert.Equal(t, "Test system message", message.TestMessageGroups[2].PromptLabel, "Expect results without a prompt version to be kept")
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/y2a-labs/evaluate/models"
+	"gorm.io/gorm"
+)
+
+func (s *Service) createTestRun(input ExecuteTestInput, preparedInput *RunTestInput) (*models.TestRun, error) {
+	// Keep a copy of the tested models without the scores of the previous runs
+	testModels := make([]models.TestModels, len(preparedInput.Conversation.TestModels))
+	for i, testModel := range preparedInput.Conversation.TestModels {
+		testModel.Score = 0
+		testModel.Cost = 0
+		testModel.ScoredCount = 0
+		testModels[i] = testModel
+	}
+
+	testRun := &models.TestRun{
+		ConversationID:      preparedInput.Conversation.ID,
+		ConversationVersion: preparedInput.Conversation.SelectedVersion,
+		TestMessageID:       input.TestMessageID,
+		StartedAt:           time.Now(),
+		RunCount:            input.RunCount,
+		TestMode:            preparedInput.Conversation.TestMode,
+		Status:              models.TestRunStatusRunning,
+		Models:              testModels,
+		PromptScores:        newPromptScores(preparedInput.Prompts, testModels),
+	}
+	if preparedInput.Scorer != nil {
+		testRun.Scorer = preparedInput.Scorer.Name()
+	}
+
+	tx := s.Db.Create(testRun)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return testRun, nil
+}
+
+func newTestRunError(result TestResult) models.TestRunError {
+	return models.TestRunError{
+		ProviderID:    result.ProviderID,
+		LLMID:         result.LLMID,
+		TestModelID:   result.TestModelID,
+		PromptID:      result.PromptID,
+		TestMessageID: result.TestMessageID,
+		StatusCode:    errorStatusCode(result.Err),
+		Message:       result.Err.Error(),
+		RetryCount:    result.RetryCount,
+	}
+}
+
+// finishTestRun records the end of the run along with the average score of every model
+// and the calls that failed.
+func (s *Service) finishTestRun(testRun *models.TestRun, results []*models.Message, runErrors []models.TestRunError, runErr error) error {
+	endedAt := time.Now()
+	testRun.EndedAt = &endedAt
+	testRun.Errors = runErrors
+	testRun.ErrorCount = len(runErrors)
+	switch {
+	case errors.Is(runErr, context.Canceled):
+		testRun.Status = models.TestRunStatusCancelled
+	case runErr != nil:
+		testRun.Status = models.TestRunStatusFailed
+		testRun.ErrorCount++
+		testRun.Errors = append(testRun.Errors, models.TestRunError{Message: runErr.Error()})
+	case len(runErrors) > 0 && len(results) == 0:
+		testRun.Status = models.TestRunStatusFailed
+	case len(runErrors) > 0:
+		testRun.Status = models.TestRunStatusPartial
+	default:
+		testRun.Status = models.TestRunStatusCompleted
+	}
+
+	scoreSum := map[string]float64{}
+	scoreCount := map[string]int{}
+	costSum := map[string]float64{}
+	testRun.Cost = 0
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		testRun.ResultCount++
+		if result.Metadata == nil {
+			continue
+		}
+		costSum[result.TestModelKey()] += result.Metadata.Cost
+		testRun.Cost += result.Metadata.Cost
+		if result.Metadata.Scorer == "" {
+			continue
+		}
+		scoreSum[result.TestModelKey()] += result.Metadata.Score
+		scoreCount[result.TestModelKey()]++
+	}
+
+	totalScore := 0.0
+	totalCount := 0
+	for i, testModel := range testRun.Models {
+		key := testModel.Key()
+		testRun.Models[i].Cost = costSum[key]
+		if scoreCount[key] == 0 {
+			continue
+		}
+		testRun.Models[i].Score = roundScore(scoreSum[key] / float64(scoreCount[key]))
+		testRun.Models[i].ScoredCount = scoreCount[key]
+		totalScore += scoreSum[key]
+		totalCount += scoreCount[key]
+	}
+	if totalCount > 0 {
+		testRun.Score = roundScore(totalScore / float64(totalCount))
+	}
+	scorePromptVariants(testRun.PromptScores, results)
+
+	tx := s.Db.Save(testRun)
+	return tx.Error
+}
+
+// GetLastTestRun returns the most recent run of a test, or nil if it was never run.
+func (s *Service) GetLastTestRun(conversationID string) (*models.TestRun, error) {
+	testRuns := []*models.TestRun{}
+	tx := s.Db.Where("conversation_id = ?", conversationID).Order("started_at DESC").Limit(1).Find(&testRuns)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if len(testRuns) == 0 {
+		return nil, nil
+	}
+	return testRuns[0], nil
+}
+
+// GetTestRuns returns the run history of a test, newest first.
+func (s *Service) GetTestRuns(conversationID string) ([]*models.TestRun, error) {
+	testRuns := []*models.TestRun{}
+	tx := s.Db.Where("conversation_id = ?", conversationID).Order("started_at DESC").Find(&testRuns)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return testRuns, nil
+}
+
+// GetTestRun returns a run along with the test conversation as it was when the run happened.
+// The results of the run are attached to the messages they were generated for.
+func (s *Service) GetTestRun(id string) (*models.TestRun, *models.Conversation, error) {
+	testRun := &models.TestRun{BaseModel: models.BaseModel{ID: id}}
+	tx := s.Db.Preload("Messages", func(db *gorm.DB) *gorm.DB {
+		return db.Order("llm_id ASC").Preload("Metadata")
+	}).First(testRun)
+	if tx.Error != nil {
+		return nil, nil, tx.Error
+	}
+
+	conversation, err := s.GetConversationWithMessages(testRun.ConversationID, testRun.ConversationVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scorer, err := s.GetScorer(conversation)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resultsByMessage := map[string][]*models.Message{}
+	for _, message := range testRun.Messages {
+		resultsByMessage[message.TestMessageID] = append(resultsByMessage[message.TestMessageID], message)
+	}
+
+	for i, message := range conversation.Messages {
+		message.TestMessages = resultsByMessage[message.ID]
+		if len(message.TestMessages) == 0 {
+			continue
+		}
+		if err := s.loadMessageMetadata(message); err != nil {
+			return nil, nil, err
+		}
+		err := s.scoreTestMessages(context.Background(), scorer, conversation.Messages[:i], message, message.TestMessages)
+		if err != nil {
+			return nil, nil, err
+		}
+		setTestMessageSettings(testRun.Models, message.TestMessages)
+		groupTestMessagesByPrompt(testRun.PromptScores, message)
+	}
+
+	return testRun, conversation, nil
+}
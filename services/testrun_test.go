@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestFinishTestRun(t *testing.T) {
+	s := New(":memory:", "../.env")
+	conversation := &models.Conversation{
+		BaseModel: models.BaseModel{ID: "test-run-conversation"},
+		TestModels: []models.TestModels{
+			{Provider: "openai", Model: "a", Score: 50},
+			{Provider: "openai", Model: "b"},
+		},
+	}
+	testRun, err := s.createTestRun(ExecuteTestInput{RunCount: 2}, &RunTestInput{Conversation: conversation, Scorer: exactMatchScorer{}})
+	assert.Nil(t, err)
+	assert.Equal(t, models.TestRunStatusRunning, testRun.Status)
+	assert.Equal(t, "exact_match", testRun.Scorer)
+	assert.Equal(t, 0.0, testRun.Models[0].Score, "Expect the scores of previous runs to be cleared")
+
+	results := []*models.Message{
+		{LLMID: "a", Metadata: &models.MessageMetadata{Scorer: "exact_match", Score: 1}},
+		{LLMID: "a", Metadata: &models.MessageMetadata{Scorer: "exact_match", Score: 0}},
+		{LLMID: "b", Metadata: &models.MessageMetadata{Scorer: "exact_match", Score: 1}},
+		{LLMID: "b", Metadata: &models.MessageMetadata{}},
+	}
+	err = s.finishTestRun(testRun, results, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, models.TestRunStatusCompleted, testRun.Status)
+	assert.NotNil(t, testRun.EndedAt)
+	assert.Equal(t, 4, testRun.ResultCount)
+	assert.Equal(t, 50.0, testRun.Models[0].Score)
+	assert.Equal(t, 100.0, testRun.Models[1].Score, "Expect unscored results to be left out of the average")
+	assert.Equal(t, 66.67, testRun.Score)
+
+	failedRun, err := s.createTestRun(ExecuteTestInput{RunCount: 1}, &RunTestInput{Conversation: conversation})
+	assert.Nil(t, err)
+	err = s.finishTestRun(failedRun, nil, nil, errors.New("provider error"))
+	assert.Nil(t, err)
+	assert.Equal(t, models.TestRunStatusFailed, failedRun.Status)
+	assert.Equal(t, 1, failedRun.ErrorCount)
+
+	testRuns, err := s.GetTestRuns(conversation.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(testRuns))
+}
+
+func TestSaveResultsWithErrors(t *testing.T) {
+	s := New(":memory:", "../.env")
+	resultsChan := make(chan TestResult, 5)
+	resultsChan <- TestResult{Message: &models.Message{LLMID: "a", Role: "assistant", Content: "1"}}
+	resultsChan <- TestResult{LLMID: "b", ProviderID: "openai", RetryCount: 2, Err: &openai.APIError{HTTPStatusCode: 503, Message: "unavailable"}}
+	resultsChan <- TestResult{Message: &models.Message{LLMID: "a", Role: "assistant", Content: "2"}}
+	resultsChan <- TestResult{Message: &models.Message{LLMID: "a", Role: "assistant", Content: "3"}}
+	resultsChan <- TestResult{LLMID: "b", Err: errors.New("connection reset")}
+	close(resultsChan)
+
+	results, runErrors, err := s.saveResultsInBatches(resultsChan, 5, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(results), "Expect the successful results to be kept")
+	for _, result := range results {
+		assert.NotEqual(t, "", result.ID, "Expect every result to be saved")
+	}
+	assert.Equal(t, 2, len(runErrors))
+	assert.Equal(t, 503, runErrors[0].StatusCode)
+	assert.Equal(t, 2, runErrors[0].RetryCount)
+	assert.Equal(t, "b", runErrors[0].LLMID)
+
+	testRun, err := s.createTestRun(ExecuteTestInput{RunCount: 1}, &RunTestInput{Conversation: &models.Conversation{}})
+	assert.Nil(t, err)
+	err = s.finishTestRun(testRun, results, runErrors, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, models.TestRunStatusPartial, testRun.Status)
+	assert.Equal(t, 2, testRun.ErrorCount)
+	assert.Equal(t, 3, testRun.ResultCount)
+}
+
+func TestWithRetries(t *testing.T) {
+	retryBackoff = time.Millisecond
+	calls := 0
+	retries, err := withRetries(context.Background(), 2, func() error {
+		calls++
+		return &openai.APIError{HTTPStatusCode: 429}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 3, calls, "Expect rate limited calls to be retried")
+	assert.Equal(t, 2, retries)
+
+	calls = 0
+	retries, err = withRetries(context.Background(), 2, func() error {
+		calls++
+		return &openai.APIError{HTTPStatusCode: 400}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls, "Expect bad requests to not be retried")
+	assert.Equal(t, 0, retries)
+
+	calls = 0
+	_, err = withRetries(context.Background(), 2, func() error {
+		calls++
+		if calls < 2 {
+			return &openai.RequestError{HTTPStatusCode: 502}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestFinishTestRunWithParams(t *testing.T) {
+	s := New(":memory:", "../.env")
+	conversation := &models.Conversation{
+		TestModels: []models.TestModels{
+			{ID: "cold", Provider: "openai", Model: "gpt-4o", GenerationParams: models.GenerationParams{Temperature: ptr(float32(0))}},
+			{ID: "warm", Provider: "openai", Model: "gpt-4o", GenerationParams: models.GenerationParams{Temperature: ptr(float32(0.7))}},
+		},
+	}
+	testRun, err := s.createTestRun(ExecuteTestInput{RunCount: 1}, &RunTestInput{Conversation: conversation})
+	assert.Nil(t, err)
+
+	results := []*models.Message{
+		{LLMID: "gpt-4o", TestModelID: "cold", Metadata: &models.MessageMetadata{Scorer: "exact_match", Score: 1}},
+		{LLMID: "gpt-4o", TestModelID: "warm", Metadata: &models.MessageMetadata{Scorer: "exact_match", Score: 0}},
+	}
+	err = s.finishTestRun(testRun, results, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 100.0, testRun.Models[0].Score, "Expect the entries of the same model to be scored separately")
+	assert.Equal(t, 0.0, testRun.Models[1].Score)
+	assert.Equal(t, "temperature=0.7", testRun.Models[1].GenerationParams.String())
+}
@@ -2,10 +2,12 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/y2a-labs/evaluate/models"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,6 +22,14 @@ type RunTestInput struct {
 	Conversation *models.Conversation
 	TestIndexes  []int
 	LLMs         []*models.LLM
+	Scorer       Scorer
+	TestRunID    string
+	// TestModels are the model entries to run, the same model can be in it more than once with different parameters
+	TestModels []models.TestModels
+	// Prompts are the prompt versions used as the system prompt
+	Prompts []*models.Prompt
+	// Simulator plays the user when the test is in simulate mode
+	Simulator *userSimulator
 }
 
 type ExecuteTestInput struct {
@@ -51,80 +61,175 @@ func (s *Service) prepareTestData(input ExecuteTestInput) (*RunTestInput, error)
 		return nil, err
 	}
 
+	scorer, err := s.GetScorer(conversation)
+	if err != nil {
+		return nil, err
+	}
+
+	prompts, err := s.getTestPrompts(conversation.TestPromptIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var simulator *userSimulator
+	if conversation.TestMode == models.TestModeSimulate {
+		simulator, err = s.newUserSimulator(conversation)
+		if err != nil {
+			return nil, err
+		}
+		// The whole conversation is simulated, so every run gives a single result recorded on the last assistant message
+		testIndexes = testIndexes[len(testIndexes)-1:]
+		simulator.reference, err = s.newSimulationReference(input.Context, conversation, scorer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Load the metadata of the reference messages so the results can be scored as they come in
+	for _, testIndex := range testIndexes {
+		if err := s.loadMessageMetadata(conversation.Messages[testIndex]); err != nil {
+			return nil, err
+		}
+	}
+
 	result := &RunTestInput{
 		Context:      input.Context,
 		Conversation: conversation,
 		LLMs:         llms,
+		TestModels:   conversation.TestModels,
+		Prompts:      prompts,
 		RunCount:     input.RunCount,
 		TestIndexes:  testIndexes,
+		Scorer:       scorer,
+		Simulator:    simulator,
 	}
 	return result, nil
 }
 
-func (s *Service) ExecuteTestWorkflow(input ExecuteTestInput) ([]*models.Message, error) {
+// loadMessageMetadata attaches the metadata of the message if it has any.
+func (s *Service) loadMessageMetadata(message *models.Message) error {
+	metadata := &models.MessageMetadata{}
+	tx := s.Db.Where("message_id = ?", message.ID).Limit(1).Find(metadata)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected > 0 {
+		message.Metadata = metadata
+	}
+	return nil
+}
+
+func (s *Service) ExecuteTestWorkflow(input ExecuteTestInput) (*models.TestRun, error) {
+	preparedInput, testRun, err := s.startTestRun(input)
+	if err != nil {
+		return nil, err
+	}
+	return s.executeTestRun(preparedInput, testRun, nil)
+}
+
+// startTestRun validates the input, gets all of the data for the test and records the start of the run.
+func (s *Service) startTestRun(input ExecuteTestInput) (*RunTestInput, *models.TestRun, error) {
 	if input.ConversationID == "" || input.RunCount < 1 {
-		return nil, fmt.Errorf("error trying to validate workflow input")
+		return nil, nil, fmt.Errorf("error trying to validate workflow input")
 	}
 	// Get all of the data
 	preparedInput, err := s.prepareTestData(input)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	// Record the run so the results can be looked at later
+	testRun, err := s.createTestRun(input, preparedInput)
+	if err != nil {
+		return nil, nil, err
+	}
+	preparedInput.TestRunID = testRun.ID
+	return preparedInput, testRun, nil
+}
+
+// executeTestRun generates and saves the results of a started run.
+// When a job is given its progress is updated as the results come in.
+func (s *Service) executeTestRun(preparedInput *RunTestInput, testRun *models.TestRun, job *TestJob) (*models.TestRun, error) {
 	// Generate the results
 	resultsChan, totalResultCount, err := s.runTest(preparedInput)
 	if err != nil {
+		s.finishTestRun(testRun, nil, nil, err)
 		return nil, err
 	}
+	if job != nil {
+		resultsChan = job.track(resultsChan)
+	}
 
-	// Save the results in batches
-	results, err := s.saveResultsInBatches(resultsChan, totalResultCount, input.RunCount)
+	// Save the results in batches, failed calls are recorded on the run
+	results, runErrors, err := s.saveResultsInBatches(resultsChan, totalResultCount, preparedInput.RunCount)
+	if err == nil && preparedInput.Context.Err() != nil {
+		err = fmt.Errorf("test run stopped: %w", preparedInput.Context.Err())
+	}
+	if err == nil {
+		err = s.saveTranscripts(preparedInput, results)
+	}
+	if finishErr := s.finishTestRun(testRun, results, runErrors, err); finishErr != nil {
+		return nil, finishErr
+	}
 	if err != nil {
 		return nil, err
 	}
+	if len(results) == 0 && len(runErrors) > 0 {
+		return nil, fmt.Errorf("all %d calls failed: %s", len(runErrors), runErrors[0].Message)
+	}
 
-	return results, nil
+	testRun.Messages = results
+	return testRun, nil
 }
 
-func (s *Service) saveResultsInBatches(resultsChan chan TestResult, totalResultCount, batchSize int) ([]*models.Message, error) {
-	resultCount := 0
+// saveResultsInBatches saves the successful results as they come in and collects the failed calls.
+// It only returns an error when the results can't be saved.
+func (s *Service) saveResultsInBatches(resultsChan chan TestResult, totalResultCount, batchSize int) ([]*models.Message, []models.TestRunError, error) {
+	allResults := make([]*models.Message, 0, totalResultCount)
+	runErrors := []models.TestRunError{}
 	batchIndex := 0 // This will track where in allResults the next batch starts.
-	allResults := make([]*models.Message, totalResultCount)
 
 	for result := range resultsChan {
 		if result.Err != nil {
-			return nil, result.Err
+			// Calls stopped by cancelling the run didn't fail
+			if !errors.Is(result.Err, context.Canceled) {
+				runErrors = append(runErrors, newTestRunError(result))
+			}
+			continue
 		}
 
-		// Place the result directly into the allResults slice at the correct position.
-		allResults[batchIndex+resultCount] = result.Message
-		resultCount++
+		allResults = append(allResults, result.Message)
 
-		if resultCount == batchSize {
-			// Process the batch if needed, for example, saving to a database.
-			tx := s.Db.Save(allResults[batchIndex : batchIndex+batchSize])
+		if len(allResults)-batchIndex == batchSize {
+			tx := s.Db.Save(allResults[batchIndex:])
 			if tx.Error != nil {
-				return nil, tx.Error
+				return nil, nil, tx.Error
 			}
-			batchIndex += batchSize
-			resultCount = 0
+			batchIndex = len(allResults)
 		}
 	}
 
 	// Handle any remaining results if they don't fill a complete batch.
-	if resultCount > 0 {
-		tx := s.Db.Save(allResults[batchIndex : batchIndex+resultCount])
+	if len(allResults) > batchIndex {
+		tx := s.Db.Save(allResults[batchIndex:])
 		if tx.Error != nil {
-			return nil, tx.Error
+			return nil, nil, tx.Error
 		}
 	}
 
-	return allResults, nil
+	return allResults, runErrors, nil
 }
 
 type TestResult struct {
 	Message *models.Message
 	Err     error
+	// Where the error happened
+	ProviderID    string
+	LLMID         string
+	TestModelID   string
+	PromptID      string
+	TestMessageID string
+	RetryCount    int
 }
 
 type TestResultsOutput struct {
@@ -148,11 +253,97 @@ func (s *Service) GetTestList() ([]*models.Conversation, error) {
 	return conversations, nil
 }
 
+// GetTestsByTags returns every test that has at least one of the tags.
+func (s *Service) GetTestsByTags(tags []string) ([]*models.Conversation, error) {
+	conversations := []*models.Conversation{}
+	tx := s.Db.Where("is_test = ?", true).
+		Where("EXISTS (SELECT 1 FROM json_each(conversations.tags) WHERE json_each.value IN ?)", tags).
+		Order("created_at ASC").
+		Find(&conversations)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return conversations, nil
+}
+
+// ParseTags splits a comma separated list of tags.
+func ParseTags(input string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(input, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ParseGenerationParams reads the generation parameters of a tested model from form values, empty values are left unset.
+// The stop sequences are comma separated.
+func ParseGenerationParams(temperature, topP, maxTokens, seed, stop string) (models.GenerationParams, error) {
+	params := models.GenerationParams{}
+	if temperature = strings.TrimSpace(temperature); temperature != "" {
+		value, err := strconv.ParseFloat(temperature, 32)
+		if err != nil || value < 0 || value > 2 {
+			return params, fmt.Errorf("temperature must be a number between 0 and 2")
+		}
+		params.Temperature = ptr(float32(value))
+	}
+	if topP = strings.TrimSpace(topP); topP != "" {
+		value, err := strconv.ParseFloat(topP, 32)
+		if err != nil || value <= 0 || value > 1 {
+			return params, fmt.Errorf("top_p must be a number above 0 and up to 1")
+		}
+		params.TopP = ptr(float32(value))
+	}
+	if maxTokens = strings.TrimSpace(maxTokens); maxTokens != "" {
+		value, err := strconv.Atoi(maxTokens)
+		if err != nil || value < 1 {
+			return params, fmt.Errorf("max_tokens must be a positive integer")
+		}
+		params.MaxTokens = value
+	}
+	if seed = strings.TrimSpace(seed); seed != "" {
+		value, err := strconv.Atoi(seed)
+		if err != nil {
+			return params, fmt.Errorf("seed must be an integer")
+		}
+		params.Seed = ptr(value)
+	}
+	if stop := ParseTags(stop); len(stop) > 0 {
+		params.Stop = stop
+	}
+	return params, nil
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+// SetTestTags replaces the tags of a test.
+func (s *Service) SetTestTags(conversationID string, tags []string) (*models.Conversation, error) {
+	conversation, err := s.GetConversation(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	conversation.Tags = tags
+	tx := s.Db.Model(conversation).Update("tags", conversation.Tags)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return conversation, nil
+}
+
 func (s *Service) GetTest(conversationID string, selectedVersion int) (*models.Conversation, error) {
+	conversation, _, err := s.getTest(conversationID, selectedVersion)
+	return conversation, err
+}
 
+// getTest loads and scores the test, and also returns every test message before the duplicates are removed.
+func (s *Service) getTest(conversationID string, selectedVersion int) (*models.Conversation, []*models.Message, error) {
 	conversation, err := s.GetConversationWithMessages(conversationID, selectedVersion)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// For each message, preload Metadata and TestMessages (and their Metadata).
@@ -162,40 +353,43 @@ func (s *Service) GetTest(conversationID string, selectedVersion int) (*models.C
 				return db.Where("conversation_version = ?", conversation.SelectedVersion).Preload("Metadata")
 			}).
 			Find(&message).Error; err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		conversation.Messages[i] = message
 	}
 
-	for _, message := range conversation.Messages {
+	scorer, err := s.GetScorer(conversation)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prompts, err := s.getTestPrompts(conversation.TestPromptIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	conversation.PromptScores = newPromptScores(prompts, conversation.TestModels)
+	allTestMessages := []*models.Message{}
+
+	for i, message := range conversation.Messages {
 		// If the message is not an assistant message, skip it
 		if message.Role != "assistant" {
 			continue
 		}
 
-		if message.Metadata == nil {
-			return nil, fmt.Errorf("no metadata found for message")
+		// Calculate the score for every TestMessage
+		err := s.scoreTestMessages(context.Background(), scorer, conversation.Messages[:i], message, message.TestMessages)
+		if err != nil {
+			return nil, nil, err
 		}
+		setTestMessageSettings(conversation.TestModels, message.TestMessages)
+		allTestMessages = append(allTestMessages, message.TestMessages...)
 
-		// Calculate the score for every TestMessage
 		uniqueTestMessages := make(map[string]*models.Message)
 		for _, testMessage := range message.TestMessages {
-
-			// Make sure both messages have embeddings
-			if testMessage.Metadata == nil || message.Metadata == nil {
-				return nil, fmt.Errorf("missing metadata on message")
-			}
-
-			score, err := s.CosineSimilarity(testMessage.Metadata.Embedding, message.Metadata.Embedding)
-			if err != nil {
-				score = 0
-			}
-			scoreStr := fmt.Sprintf("%.2f", score*100)
-			testMessage.Score, _ = strconv.ParseFloat(scoreStr, 64)
-
-			// If the test message content is not already in the map, add it
-			if _, exists := uniqueTestMessages[testMessage.Content]; !exists {
-				uniqueTestMessages[testMessage.Content] = testMessage
+			// If the test message content is not already in the map for its prompt version, add it
+			key := testMessage.PromptID + "/" + testMessage.Content
+			if _, exists := uniqueTestMessages[key]; !exists {
+				uniqueTestMessages[key] = testMessage
 			}
 		}
 
@@ -209,49 +403,161 @@ func (s *Service) GetTest(conversationID string, selectedVersion int) (*models.C
 		sort.Slice(message.TestMessages, func(i, j int) bool {
 			return message.TestMessages[i].Score > message.TestMessages[j].Score
 		})
+		groupTestMessagesByPrompt(conversation.PromptScores, message)
 	}
+	scorePromptVariants(conversation.PromptScores, allTestMessages)
 
-	return conversation, nil
+	return conversation, allTestMessages, nil
+}
+
+// setTestMessageSettings describes the generation parameters each test message was generated with.
+func setTestMessageSettings(testModels []models.TestModels, testMessages []*models.Message) {
+	settings := map[string]string{}
+	for _, testModel := range testModels {
+		settings[testModel.Key()] = testModel.GenerationParams.String()
+	}
+	for _, testMessage := range testMessages {
+		testMessage.Settings = settings[testMessage.TestModelKey()]
+	}
 }
 
 func (s *Service) runTest(input *RunTestInput) (chan TestResult, int, error) {
 
 	var wg sync.WaitGroup
-	testCount := len(input.TestIndexes) * input.RunCount * len(input.LLMs)
+	// Every prompt version is run against every model, without any the test runs with its own system message
+	prompts := input.Prompts
+	if len(prompts) == 0 {
+		prompts = []*models.Prompt{nil}
+	}
+	testCount := len(input.TestIndexes) * input.RunCount * len(input.TestModels) * len(prompts)
 	testResultChan := make(chan TestResult, testCount)
+	budget := s.newTestBudget(input.TestRunID)
+	// The responses are only embedded for the scorer that compares embeddings
+	var embeddingClient *openai.Client
+	if needsEmbeddings(input.Scorer) {
+		openaiProvider, ok := s.llmProviders["openai"]
+		if !ok {
+			return nil, 0, fmt.Errorf("the cosine scorer requires the openai provider")
+		}
+		embeddingClient = openaiProvider.client
+	}
 
-	for _, llm := range input.LLMs {
-		llmProvider := s.llmProviders[llm.ProviderID]
-		limiter := s.limiter.GetLimiter(llmProvider.Provider)
-		for _, testIndex := range input.TestIndexes {
-			wg.Add(input.RunCount)
-			messages := input.Conversation.Messages[:testIndex]
-			for range input.RunCount {
-				go func() {
-					defer wg.Done()
-					// Wait for permission before msaking the request
-					if err := limiter.Wait(input.Context); err != nil {
-						testResultChan <- TestResult{Err: fmt.Errorf("rate limiter wait error: %w", err)}
-						return
-					}
-
-					// Process the prompt
-					resultMessage, err := processPrompt(input.Context, messages, llm.ID, llmProvider.client, s.llmProviders["openai"].client)
-					if err != nil {
-						testResultChan <- TestResult{Err: err}
-						return
-					}
-					resultMessage.TestMessageID = input.Conversation.Messages[testIndex].ID
-					resultMessage.ConversationID = input.Conversation.ID
-					resultMessage.LLMID = llm.ID
-					resultMessage.ConversationVersion = input.Conversation.SelectedVersion
-					resultMessage.MessageIndex = input.Conversation.Messages[testIndex].MessageIndex
-
-					testResultChan <- TestResult{
-						Message: resultMessage,
-						Err:     nil,
+	for _, prompt := range prompts {
+		promptID := ""
+		if prompt != nil {
+			promptID = prompt.ID
+		}
+		for _, testModel := range input.TestModels {
+			llmProvider, ok := s.llmProviders[testModel.Provider]
+			var dispatchErr error
+			if !ok {
+				dispatchErr = fmt.Errorf("provider not found: %s", testModel.Provider)
+			} else {
+				dispatchErr = budget.check(testModel.Provider)
+			}
+			if dispatchErr != nil {
+				// Every call for this model fails, the other models still run
+				for _, testIndex := range input.TestIndexes {
+					for range input.RunCount {
+						testResultChan <- TestResult{
+							Err:           dispatchErr,
+							ProviderID:    testModel.Provider,
+							LLMID:         testModel.Model,
+							TestModelID:   testModel.Key(),
+							PromptID:      promptID,
+							TestMessageID: input.Conversation.Messages[testIndex].ID,
+						}
 					}
-				}()
+				}
+				continue
+			}
+			limiter := s.limiter.GetLimiter(llmProvider.Provider)
+			price := s.getLLMPrice(testModel.Provider, testModel.Model)
+			for _, testIndex := range input.TestIndexes {
+				wg.Add(input.RunCount)
+				messages := applyPrompt(input.Conversation.Messages[:testIndex], prompt)
+				reference := input.Conversation.Messages[testIndex]
+				if input.Simulator != nil {
+					messages = applyPrompt(seedMessages(input.Conversation.Messages), prompt)
+					reference = input.Simulator.reference
+				}
+				for range input.RunCount {
+					go func() {
+						defer wg.Done()
+						failed := TestResult{
+							ProviderID:    testModel.Provider,
+							LLMID:         testModel.Model,
+							TestModelID:   testModel.Key(),
+							PromptID:      promptID,
+							TestMessageID: input.Conversation.Messages[testIndex].ID,
+						}
+
+						// Process the prompt, retrying when the provider is rate limiting or unavailable
+						var resultMessage *models.Message
+						var retryCount int
+						var err error
+						if input.Simulator != nil {
+							resultMessage, retryCount, err = s.simulateConversation(input.Context, input.Simulator, messages, testModel, llmProvider, limiter, budget)
+						} else {
+							retryCount, err = withRetries(input.Context, maxTestRetries, func() error {
+								// Wait for permission before making the request
+								if err := limiter.Wait(input.Context); err != nil {
+									return fmt.Errorf("rate limiter wait error: %w", err)
+								}
+								// The other calls of the run may have spent the budget since the model was dispatched
+								if err := budget.check(testModel.Provider); err != nil {
+									return err
+								}
+								var err error
+								resultMessage, err = processPrompt(input.Context, messages, input.Conversation.Tools, testModel.Model, testModel.GenerationParams, llmProvider, embeddingClient)
+								return err
+							})
+						}
+						if err != nil {
+							failed.Err = err
+							failed.RetryCount = retryCount
+							testResultChan <- failed
+							return
+						}
+						resultMessage.TestMessageID = input.Conversation.Messages[testIndex].ID
+						resultMessage.TestRunID = input.TestRunID
+						resultMessage.ConversationID = input.Conversation.ID
+						resultMessage.LLMID = testModel.Model
+						resultMessage.ProviderID = testModel.Provider
+						resultMessage.TestModelID = testModel.ID
+						resultMessage.PromptID = promptID
+						resultMessage.ConversationVersion = input.Conversation.SelectedVersion
+						resultMessage.MessageIndex = input.Conversation.Messages[testIndex].MessageIndex
+						// The simulated conversations cost their calls as they make them
+						if input.Simulator == nil {
+							setCost(resultMessage.Metadata, price)
+							budget.add(testModel.Provider, resultMessage.Metadata.Cost)
+						}
+
+						// Score the result against the reference message and store it with the result. When this fails,
+						// the scorers that don't call a model score it again when the test is loaded
+						if input.Scorer != nil {
+							result, err := input.Scorer.Score(input.Context, ScoreInput{
+								History:   messages,
+								Reference: reference,
+								Candidate: resultMessage,
+								budget:    budget,
+							})
+							// The calls of the judge are part of the cost of the result, like the ones of the user simulator
+							if resultMessage.Metadata != nil {
+								resultMessage.Metadata.Cost += result.Cost
+							}
+							if err == nil {
+								setScore(resultMessage.Metadata, input.Scorer, result)
+							}
+						}
+
+						testResultChan <- TestResult{
+							Message: resultMessage,
+							Err:     nil,
+						}
+					}()
+				}
 			}
 		}
 	}
@@ -265,69 +571,101 @@ func (s *Service) runTest(input *RunTestInput) (chan TestResult, int, error) {
 	return testResultChan, testCount, nil
 }
 
-func processPrompt(ctx context.Context, messages []*models.Message, model string, llmClient *openai.Client, embeddingClient *openai.Client) (*models.Message, error) {
-
-	// Turn the message into openai format
-	openaiMessages := make([]openai.ChatCompletionMessage, len(messages))
-
-	for i, msg := range messages {
-		openaiMessages[i] = openai.ChatCompletionMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		}
-	}
-
+// processPrompt gets the response of the model to the messages. The response is embedded when there is an
+// embedding client.
+func processPrompt(ctx context.Context, messages []*models.Message, tools []openai.Tool, model string, params models.GenerationParams, llm *llmProvider, embeddingClient *openai.Client) (*models.Message, error) {
 	// Turn the message into a chat completion request
-	request := openai.ChatCompletionRequest{
-		Model:    model,
-		Messages: openaiMessages,
-		Stream:   false,
-	}
+	request := newChatCompletionRequest(messages, tools, model, params)
 
 	// Measure how long it takes for the first token
 	startTime := time.Now()
 	// Create the chat completion stream
-	resp, err := llmClient.CreateChatCompletion(ctx, request)
+	resp, err := llm.createChatCompletion(withTemperature(ctx, params.Temperature), request)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get LLM response: %w", err)
 	}
 	if len(resp.Choices) == 0 {
-		return &models.Message{
-			Role:    "assistant",
-			Content: "",
-		}, nil
+		// The call failed, an empty message would be scored and counted as a result
+		return nil, errors.New("no choices returned")
 	}
 
 	content := resp.Choices[0].Message.Content
+	toolCalls := resp.Choices[0].Message.ToolCalls
 
 	// Generate text embeddings using openai
-	responseEmbedding, err := embeddingClient.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
-		Model: "text-embedding-3-small",
-		Input: []string{content},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get text embedding: %w", err)
+	var embedding []float32
+	if embeddingClient != nil {
+		embedding, err = createEmbedding(ctx, embeddingClient, ResponseText(content, toolCalls))
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	totalLatencyMs := int(time.Since(startTime).Milliseconds())
+	usage, estimated := EstimateUsage(request, ResponseText(content, toolCalls), resp.Usage)
 
 	message := &models.Message{
-		Role:    "assistant",
-		Content: content,
+		Role:      "assistant",
+		Content:   content,
+		ToolCalls: toolCalls,
 		Metadata: &models.MessageMetadata{
 			BaseModel: models.BaseModel{
 				ID: uuid.NewString(),
 			},
 			EndLatencyMs:     totalLatencyMs,
-			OutputTokenCount: resp.Usage.CompletionTokens,
-			InputTokenCount:  resp.Usage.PromptTokens,
-			Embedding:        responseEmbedding.Data[0].Embedding,
+			OutputTokenCount: usage.CompletionTokens,
+			InputTokenCount:  usage.PromptTokens,
+			EstimatedTokens:  estimated,
+			Embedding:        embedding,
 		},
 	}
 
 	return message, nil
 }
 
+// newChatCompletionRequest turns the messages into a chat completion request with the tools and the generation
+// parameters.
+func newChatCompletionRequest(messages []*models.Message, tools []openai.Tool, model string, params models.GenerationParams) openai.ChatCompletionRequest {
+	// Turn the message into openai format, with its tool calls
+	openaiMessages := make([]openai.ChatCompletionMessage, len(messages))
+
+	for i, msg := range messages {
+		openaiMessages[i] = msg.ChatCompletionMessage()
+	}
+
+	request := openai.ChatCompletionRequest{
+		Model:     model,
+		Messages:  openaiMessages,
+		Tools:     tools,
+		Stream:    false,
+		MaxTokens: params.MaxTokens,
+		Seed:      params.Seed,
+		Stop:      params.Stop,
+	}
+	// A zero temperature is sent by the context of the request with withTemperature
+	if params.Temperature != nil {
+		request.Temperature = *params.Temperature
+	}
+	if params.TopP != nil {
+		request.TopP = *params.TopP
+	}
+	return request
+}
+
+func createEmbedding(ctx context.Context, embeddingClient *openai.Client, content string) ([]float32, error) {
+	responseEmbedding, err := embeddingClient.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Model: "text-embedding-3-small",
+		Input: []string{content},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get text embedding: %w", err)
+	}
+	if len(responseEmbedding.Data) == 0 {
+		return nil, fmt.Errorf("no text embedding returned")
+	}
+	return responseEmbedding.Data[0].Embedding, nil
+}
+
 func getTestIndexes(messages []*models.Message, testMessageID string) ([]int, error) {
 	evalIndexes := []int{}
 
@@ -357,6 +695,6 @@ func getTestIndexes(messages []*models.Message, testMessageID string) ([]int, er
 }
 
 type TestManager interface {
-	ExecuteTestWorkflow(input ExecuteTestInput) ([]*models.Message, error)
+	ExecuteTestWorkflow(input ExecuteTestInput) (*models.TestRun, error)
 	GetTestList() ([]*models.Conversation, error)
 }
@@ -2,9 +2,13 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/y2a-labs/evaluate/models"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/sashabaranov/go-openai"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -62,6 +66,7 @@ func TestRunTest(t *testing.T) {
 		Conversation: conversation,
 		RunCount:     2,
 		LLMs:         llms,
+		TestModels:   []models.TestModels{{Provider: "openrouter", Model: "openchat/openchat-7b"}},
 	})
 	for result := range resultChan {
 		assert.NotEqual(t, "", result.Message.Content)
@@ -72,3 +77,97 @@ func TestRunTest(t *testing.T) {
 	assert.Equal(t, 4, totalResultCount)
 	assert.Equal(t, 1, conversation.Version, "expect the conversation version to increment by 1")
 }
+
+func TestGetTestsByTags(t *testing.T) {
+	s := New(":memory:", "../.env")
+	_, err := s.CreateConversation(models.ConversationCreate{Name: "a", IsTest: true, Tags: []string{"smoke", "billing"}})
+	assert.Nil(t, err)
+	_, err = s.CreateConversation(models.ConversationCreate{Name: "b", IsTest: true, Tags: []string{"billing"}})
+	assert.Nil(t, err)
+	_, err = s.CreateConversation(models.ConversationCreate{Name: "c", IsTest: false, Tags: []string{"smoke"}})
+	assert.Nil(t, err)
+
+	tests, err := s.GetTestsByTags([]string{"smoke"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tests), "Expect only tests to be returned")
+	assert.Equal(t, "a", tests[0].Name)
+
+	tests, err = s.GetTestsByTags([]string{"smoke", "billing"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(tests))
+
+	assert.Equal(t, []string{"smoke", "billing"}, ParseTags(" smoke, ,billing,"))
+}
+
+func TestParseGenerationParams(t *testing.T) {
+	params, err := ParseGenerationParams("0", "", "200", "7", "END, STOP")
+	assert.Nil(t, err)
+	assert.Equal(t, float32(0), *params.Temperature, "Expect a zero temperature to be set")
+	assert.Nil(t, params.TopP)
+	assert.Equal(t, 200, params.MaxTokens)
+	assert.Equal(t, 7, *params.Seed)
+	assert.Equal(t, []string{"END", "STOP"}, params.Stop)
+	assert.Equal(t, `temperature=0, max_tokens=200, seed=7, stop=["END" "STOP"]`, params.String())
+
+	params, err = ParseGenerationParams("", "", "", "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "", params.String())
+
+	_, err = ParseGenerationParams("3", "", "", "", "")
+	assert.Error(t, err)
+	_, err = ParseGenerationParams("", "", "-1", "", "")
+	assert.Error(t, err)
+}
+
+func TestProcessPromptParams(t *testing.T) {
+	requests := []map[string]any{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/embeddings" {
+			json.NewEncoder(w).Encode(openai.EmbeddingResponse{Data: []openai.Embedding{{Embedding: []float32{1}}}})
+			return
+		}
+		request := map[string]any{}
+		json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: "assistant", Content: "Hi"}}},
+		})
+	}))
+	defer server.Close()
+	provider := newLLMProvider(&models.Provider{BaseUrl: server.URL}, "key")
+
+	messages := []*models.Message{{Role: "user", Content: "Hello"}}
+	params, _ := ParseGenerationParams("0", "0.5", "100", "3", "END")
+	_, err := processPrompt(context.Background(), messages, nil, "model", params, provider, provider.client)
+	assert.Nil(t, err)
+	_, err = processPrompt(context.Background(), messages, nil, "model", models.GenerationParams{}, provider, provider.client)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, len(requests))
+	assert.Contains(t, requests[0], "temperature", "Expect a zero temperature to be sent")
+	assert.Equal(t, 0.0, requests[0]["temperature"])
+	assert.Equal(t, 0.5, requests[0]["top_p"])
+	assert.Equal(t, 100.0, requests[0]["max_tokens"])
+	assert.Equal(t, 3.0, requests[0]["seed"])
+	assert.Equal(t, []any{"END"}, requests[0]["stop"])
+	assert.NotContains(t, requests[1], "temperature", "Expect unset parameters to use the provider default")
+	assert.NotContains(t, requests[1], "seed")
+}
+
+func TestProcessPromptNoChoices(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{}})
+	}))
+	defer server.Close()
+	config := openai.DefaultConfig("key")
+	config.BaseURL = server.URL
+	client := openai.NewClientWithConfig(config)
+
+	messages := []*models.Message{{Role: "user", Content: "Hello"}}
+	message, err := processPrompt(context.Background(), messages, nil, "model", models.GenerationParams{}, &llmProvider{client: client}, client)
+	assert.Error(t, err, "Expect a response without choices to fail the call")
+	assert.Nil(t, message)
+
+	// A message without metadata is left unscored
+	setScore(nil, exactMatchScorer{}, ScoreResult{Score: 1})
+}
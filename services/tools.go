@@ -0,0 +1,66 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// MergeToolCallDeltas adds the tool call fragments of a streamed chunk to the tool calls received so far. The
+// first fragment of a call has its ID, type and name, the next ones add to its arguments.
+func MergeToolCallDeltas(calls []openai.ToolCall, deltas []openai.ToolCall) []openai.ToolCall {
+	for _, delta := range deltas {
+		index := len(calls) - 1
+		if delta.Index != nil {
+			index = *delta.Index
+		} else if delta.ID != "" || index < 0 {
+			// Providers that don't index the fragments start a call with its ID
+			index = len(calls)
+		}
+		for len(calls) <= index {
+			calls = append(calls, openai.ToolCall{})
+		}
+		call := &calls[index]
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		if delta.Type != "" {
+			call.Type = delta.Type
+		}
+		call.Function.Name += delta.Function.Name
+		call.Function.Arguments += delta.Function.Arguments
+	}
+	return calls
+}
+
+// threadContent is what the thread hash of a message is made of. The tool calls are only added when there are
+// some, so the messages without them keep the hash they had.
+func threadContent(content string, toolCalls []openai.ToolCall, toolCallID string) string {
+	if len(toolCalls) == 0 && toolCallID == "" {
+		return content
+	}
+	calls := make([]openai.ToolCall, len(toolCalls))
+	for i, call := range toolCalls {
+		// The index is only set on streamed fragments
+		call.Index = nil
+		calls[i] = call
+	}
+	encoded, _ := json.Marshal(calls)
+	return content + "\x00" + toolCallID + "\x00" + string(encoded)
+}
+
+// ResponseText writes out the content of a message with its tool calls, to estimate its tokens and to embed it.
+func ResponseText(content string, toolCalls []openai.ToolCall) string {
+	if len(toolCalls) == 0 {
+		return content
+	}
+	lines := []string{}
+	if content != "" {
+		lines = append(lines, content)
+	}
+	for _, call := range toolCalls {
+		lines = append(lines, call.Function.Name+"("+call.Function.Arguments+")")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+var weatherTool = openai.Tool{
+	Type: openai.ToolTypeFunction,
+	Function: openai.FunctionDefinition{
+		Name: "get_weather",
+		// Like the parameters that are read back from JSON
+		Parameters: map[string]any{"type": "object", "properties": map[string]any{"city": map[string]any{"type": "string"}}},
+	},
+}
+
+var weatherCall = openai.ToolCall{
+	ID:       "call_1",
+	Type:     openai.ToolTypeFunction,
+	Function: openai.FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`},
+}
+
+func TestMergeToolCallDeltas(t *testing.T) {
+	first, second := 0, 1
+	calls := MergeToolCallDeltas(nil, []openai.ToolCall{{Index: &first, ID: "call_1", Type: "function", Function: openai.FunctionCall{Name: "get_weather"}}})
+	calls = MergeToolCallDeltas(calls, []openai.ToolCall{{Index: &first, Function: openai.FunctionCall{Arguments: `{"city":`}}})
+	calls = MergeToolCallDeltas(calls, []openai.ToolCall{{Index: &second, ID: "call_2", Type: "function", Function: openai.FunctionCall{Name: "get_time"}}})
+	calls = MergeToolCallDeltas(calls, []openai.ToolCall{{Index: &first, Function: openai.FunctionCall{Arguments: `"Paris"}`}}})
+	assert.Equal(t, []openai.ToolCall{
+		{ID: "call_1", Type: "function", Function: openai.FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+		{ID: "call_2", Type: "function", Function: openai.FunctionCall{Name: "get_time"}},
+	}, calls)
+
+	// Without an index, a fragment with an ID starts the next call
+	calls = MergeToolCallDeltas(nil, []openai.ToolCall{{ID: "call_1", Function: openai.FunctionCall{Name: "a", Arguments: "{"}}})
+	calls = MergeToolCallDeltas(calls, []openai.ToolCall{{Function: openai.FunctionCall{Arguments: "}"}}, {ID: "call_2", Function: openai.FunctionCall{Name: "b"}}})
+	assert.Equal(t, 2, len(calls))
+	assert.Equal(t, "{}", calls[0].Function.Arguments)
+}
+
+// newToolProxyService has a fake provider that calls the weather tool until it gets its result.
+func newToolProxyService(t *testing.T) (*Service, *httptest.Server, *[]openai.ChatCompletionRequest) {
+	requests := []openai.ChatCompletionRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := openai.ChatCompletionRequest{}
+		json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+		last := request.Messages[len(request.Messages)-1]
+		if last.Role == openai.ChatMessageRoleTool {
+			json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+				Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: "assistant", Content: "It is " + last.Content}}},
+			})
+			return
+		}
+		if !request.Stream {
+			json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+				Choices: []openai.ChatCompletionChoice{{
+					Message:      openai.ChatCompletionMessage{Role: "assistant", ToolCalls: []openai.ToolCall{weatherCall}},
+					FinishReason: openai.FinishReasonToolCalls,
+				}},
+			})
+			return
+		}
+		// The arguments of a streamed tool call come in fragments
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, delta := range []string{
+			`{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}`,
+			`{"index":0,"function":{"arguments":"{\"city\":"}}`,
+			`{"index":0,"function":{"arguments":"\"Paris\"}"}}`,
+		} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[%s]}}]}\n\n", delta)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	s := New(":memory:", "../.env")
+	provider := &models.Provider{BaseModel: models.BaseModel{ID: "fake"}, BaseUrl: server.URL, Requests: 100}
+	s.Db.Create(provider)
+	s.llmProviders["fake"] = newLLMProvider(provider, "key")
+	return s, server, &requests
+}
+
+func TestProxyToolCalls(t *testing.T) {
+	s, server, requests := newToolProxyService(t)
+	defer server.Close()
+	tools := []openai.Tool{weatherTool}
+
+	messages := []openai.ChatCompletionMessage{{Role: "user", Content: "What is the weather in Paris?"}}
+	response, route, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Tools: tools, Messages: messages}, ProxyOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, tools, (*requests)[0].Tools, "Expect the tools to be passed through")
+	toolCalls := response.Choices[0].Message.ToolCalls
+	assert.Nil(t, s.AddProxyResponse(route, &models.Message{Role: "assistant", ToolCalls: toolCalls, Metadata: &models.MessageMetadata{}}))
+
+	// The client sends the call back with its result, which continues the logged conversation
+	messages = append(messages,
+		openai.ChatCompletionMessage{Role: "assistant", ToolCalls: toolCalls},
+		openai.ChatCompletionMessage{Role: "tool", Content: "18C", ToolCallID: "call_1"},
+	)
+	response, next, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Tools: tools, Messages: messages}, ProxyOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, route.Conversation.ID, next.Conversation.ID)
+	assert.Nil(t, s.AddProxyResponse(next, &models.Message{Role: "assistant", Content: response.Choices[0].Message.Content, Metadata: &models.MessageMetadata{}}))
+
+	conversation, err := s.GetConversationWithMessages(route.Conversation.ID, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(conversation.Messages))
+	assert.Equal(t, []openai.ToolCall{weatherCall}, []openai.ToolCall(conversation.Messages[1].ToolCalls))
+	assert.Equal(t, "call_1", conversation.Messages[2].ToolCallID)
+	assert.Equal(t, "It is 18C", conversation.Messages[3].Content)
+	assert.Equal(t, tools, []openai.Tool(conversation.Tools))
+
+	// Replaying the conversation sends the same request again
+	replay := newChatCompletionRequest(conversation.Messages[:3], conversation.Tools, "model", models.GenerationParams{})
+	assert.Equal(t, messages, replay.Messages)
+	assert.Equal(t, tools, replay.Tools)
+
+	// Another call of the tool isn't the same conversation
+	otherCall := weatherCall
+	otherCall.Function.Arguments = `{"city":"Lyon"}`
+	other := []openai.ChatCompletionMessage{
+		messages[0],
+		{Role: "assistant", ToolCalls: []openai.ToolCall{otherCall}},
+		{Role: "tool", Content: "20C", ToolCallID: "call_1"},
+	}
+	_, otherRoute, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: "fake/model", Tools: tools, Messages: other}, ProxyOptions{})
+	assert.Nil(t, err)
+	assert.NotEqual(t, route.Conversation.ID, otherRoute.Conversation.ID)
+}
+
+func TestProxyStreamedToolCalls(t *testing.T) {
+	s, server, _ := newToolProxyService(t)
+	defer server.Close()
+	_, err := s.SetResponseCache(models.ResponseCacheCreate{Model: "fake/model", TTLSeconds: 60})
+	assert.Nil(t, err)
+
+	request := openai.ChatCompletionRequest{
+		Model: "fake/model", Stream: true, Tools: []openai.Tool{weatherTool},
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "What is the weather in Paris?"}},
+	}
+	// receive reads the stream like the proxy handler
+	receive := func(stream ChatCompletionStream) []openai.ToolCall {
+		defer stream.Close()
+		var toolCalls []openai.ToolCall
+		for {
+			resp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return toolCalls
+			}
+			assert.Nil(t, err)
+			toolCalls = MergeToolCallDeltas(toolCalls, resp.Choices[0].Delta.ToolCalls)
+		}
+	}
+	stream, route, err := s.ProxyOpenaiStream(context.Background(), request, ProxyOptions{Temperature: ptr(float32(0))})
+	assert.Nil(t, err)
+	toolCalls := receive(stream)
+	assert.Equal(t, []openai.ToolCall{weatherCall}, toolCalls)
+	message := &models.Message{Role: "assistant", ToolCalls: toolCalls, Metadata: &models.MessageMetadata{}}
+	assert.Nil(t, s.AddProxyResponse(route, message))
+	logged, err := s.GetMessage(message.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []openai.ToolCall{weatherCall}, []openai.ToolCall(logged.ToolCalls))
+
+	// The cache streams the tool calls back
+	stream, cachedRoute, err := s.ProxyOpenaiStream(context.Background(), request, ProxyOptions{Temperature: ptr(float32(0))})
+	assert.Nil(t, err)
+	assert.Equal(t, message.ID, cachedRoute.CachedFrom)
+	assert.Equal(t, []openai.ToolCall{weatherCall}, receive(stream))
+	response, _, err := s.ProxyOpenaiChat(context.Background(), openai.ChatCompletionRequest{Model: request.Model, Tools: request.Tools, Messages: request.Messages}, ProxyOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, openai.FinishReasonToolCalls, response.Choices[0].FinishReason)
+}
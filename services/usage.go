@@ -0,0 +1,257 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"unicode"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// usageStream is a stream that knows the tokens it used once it is done.
+type usageStream interface {
+	Usage() openai.Usage
+}
+
+// StreamUsage returns the token usage that the provider reported for the stream, it is empty when there was none.
+func StreamUsage(stream ChatCompletionStream) openai.Usage {
+	if stream, ok := stream.(usageStream); ok {
+		return stream.Usage()
+	}
+	return openai.Usage{}
+}
+
+// EstimateUsage fills in the token counts that the provider didn't return with an estimate,
+// and reports whether it had to.
+func EstimateUsage(req openai.ChatCompletionRequest, completion string, usage openai.Usage) (openai.Usage, bool) {
+	estimated := false
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = estimatePromptTokens(req.Messages)
+		estimated = true
+	}
+	if usage.CompletionTokens == 0 && completion != "" {
+		usage.CompletionTokens = estimateTokens(completion)
+		estimated = true
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage, estimated
+}
+
+// estimatePromptTokens counts the tokens of the messages, with the few tokens every message adds
+// and the tokens that start the reply, like the OpenAI chat format.
+func estimatePromptTokens(messages []openai.ChatCompletionMessage) int {
+	tokens := 3
+	for _, message := range messages {
+		tokens += 3 + estimateTokens(message.Content)
+		for _, part := range message.MultiContent {
+			tokens += estimateTokens(part.Text)
+		}
+		if message.Name != "" {
+			tokens += 1 + estimateTokens(message.Name)
+		}
+		for _, toolCall := range message.ToolCalls {
+			tokens += estimateTokens(toolCall.Function.Name) + estimateTokens(toolCall.Function.Arguments)
+		}
+	}
+	return tokens
+}
+
+// estimateTokens approximates the number of tokens of the text for when the provider doesn't count them.
+// Latin words are about a token per six letters, numbers a token per three digits, and every other
+// character, like punctuation or the characters of other scripts, is about a token each.
+func estimateTokens(text string) int {
+	tokens := 0
+	letters := 0
+	digits := 0
+	flush := func() {
+		tokens += (letters+5)/6 + (digits+2)/3
+		letters = 0
+		digits = 0
+	}
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			if digits > 0 {
+				flush()
+			}
+			letters++
+		case unicode.IsDigit(r):
+			if letters > 0 {
+				flush()
+			}
+			digits++
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			flush()
+			tokens++
+		}
+	}
+	flush()
+	return tokens
+}
+
+type streamUsageKey struct{}
+
+// streamRecord is what the usageTransport reads from a stream: its usage, and the chunks as the provider sent
+// them, with the fields that the openai client drops.
+type streamRecord struct {
+	usage  openai.Usage
+	chunks [][]byte
+}
+
+// usageTransport asks OpenAI compatible providers to end their streams with the token usage, and reads it
+// from the last chunk, since the openai client doesn't keep it. Only the requests with a record in their
+// context are changed.
+type usageTransport struct {
+	base http.RoundTripper
+}
+
+func (t *usageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	record, ok := req.Context().Value(streamUsageKey{}).(*streamRecord)
+	if !ok || req.Body == nil {
+		return t.base.RoundTrip(req)
+	}
+	req, err := withBodyFields(req, func(fields map[string]json.RawMessage) {
+		fields["stream_options"] = json.RawMessage(`{"include_usage":true}`)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(resp.Body, &usageWriter{record: record}), resp.Body}
+	return resp, nil
+}
+
+// usageWriter reads the server sent events of the stream as they are read by the client,
+// and keeps the usage of the chunk that has it.
+type usageWriter struct {
+	record  *streamRecord
+	pending []byte
+}
+
+func (w *usageWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		end := bytes.IndexByte(w.pending, '\n')
+		if end < 0 {
+			return len(p), nil
+		}
+		line := bytes.TrimSpace(w.pending[:end])
+		w.pending = w.pending[end+1:]
+
+		// The openai client returns a response for each of these lines, in the same order
+		data, ok := bytes.CutPrefix(line, []byte("data: "))
+		if !ok || bytes.Equal(data, []byte("[DONE]")) || bytes.HasPrefix(data, []byte(`{"error":`)) {
+			continue
+		}
+		w.record.chunks = append(w.record.chunks, bytes.Clone(data))
+		if !bytes.Contains(data, []byte(`"usage"`)) {
+			continue
+		}
+		chunk := struct {
+			Usage *openai.Usage `json:"usage"`
+		}{}
+		if json.Unmarshal(data, &chunk) == nil && chunk.Usage != nil {
+			w.record.usage = *chunk.Usage
+		}
+	}
+}
+
+// openaiStream is a stream of an OpenAI compatible provider, with the usage read by the usageTransport.
+type openaiStream struct {
+	*openai.ChatCompletionStream
+	record *streamRecord
+	chunk  []byte
+}
+
+func (s *openaiStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	resp, err := s.ChatCompletionStream.Recv()
+	s.chunk = nil
+	if err == nil && len(s.record.chunks) > 0 {
+		s.chunk, s.record.chunks = s.record.chunks[0], s.record.chunks[1:]
+	}
+	return resp, err
+}
+
+func (s *openaiStream) Usage() openai.Usage {
+	return s.record.usage
+}
+
+// rawChunk returns the last chunk that was received, as the provider sent it.
+func (s *openaiStream) rawChunk() []byte {
+	return s.chunk
+}
+
+// withStreamUsage has the usageTransport record the usage of the stream made with the context.
+func withStreamUsage(ctx context.Context) (context.Context, *streamRecord) {
+	record := &streamRecord{}
+	return context.WithValue(ctx, streamUsageKey{}, record), record
+}
+
+// rawStream is a stream that keeps the chunks as the provider sent them.
+type rawStream interface {
+	rawChunk() []byte
+}
+
+// StreamChunks writes out the chunks of a proxied stream for the client. The chunks of OpenAI compatible
+// providers are forwarded as they were sent, so fields like Azure's prompt_filter_results aren't lost. The
+// usageTransport asks every stream for its usage, the chunk with it is only forwarded when the client asked too.
+type StreamChunks struct {
+	stream       ChatCompletionStream
+	includeUsage bool
+	usageSent    bool
+	last         openai.ChatCompletionStreamResponse
+}
+
+func NewStreamChunks(stream ChatCompletionStream, includeUsage bool) *StreamChunks {
+	return &StreamChunks{stream: stream, includeUsage: includeUsage}
+}
+
+// Chunk returns the data of the chunk to send for a response of the stream, it is nil when the chunk isn't sent.
+func (c *StreamChunks) Chunk(resp openai.ChatCompletionStreamResponse) ([]byte, error) {
+	c.last = resp
+	stream, ok := c.stream.(rawStream)
+	if !ok || stream.rawChunk() == nil {
+		return json.Marshal(resp)
+	}
+	chunk := stream.rawChunk()
+	usage := struct {
+		Usage *openai.Usage `json:"usage"`
+	}{}
+	if json.Unmarshal(chunk, &usage) == nil && usage.Usage != nil {
+		if len(resp.Choices) == 0 && !c.includeUsage {
+			return nil, nil
+		}
+		c.usageSent = true
+	}
+	return chunk, nil
+}
+
+// Usage returns the chunk with the usage for a client that asked for it, when the stream didn't send one.
+// It is nil otherwise.
+func (c *StreamChunks) Usage(usage openai.Usage) ([]byte, error) {
+	if !c.includeUsage || c.usageSent {
+		return nil, nil
+	}
+	return json.Marshal(struct {
+		openai.ChatCompletionStreamResponse
+		Usage openai.Usage `json:"usage"`
+	}{
+		ChatCompletionStreamResponse: openai.ChatCompletionStreamResponse{
+			ID: c.last.ID, Object: "chat.completion.chunk", Created: c.last.Created, Model: c.last.Model,
+			Choices: []openai.ChatCompletionStreamChoice{},
+		},
+		Usage: usage,
+	})
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func TestEstimateUsage(t *testing.T) {
+	assert.Equal(t, 0, estimateTokens(""))
+	assert.Equal(t, 3, estimateTokens("Hello, world"))
+	assert.Equal(t, 2, estimateTokens("2024"))
+	assert.Equal(t, 4, estimateTokens("你好世界"))
+
+	req := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hello, world"}}}
+	usage, estimated := EstimateUsage(req, "Hi there", openai.Usage{})
+	assert.True(t, estimated)
+	assert.Equal(t, openai.Usage{PromptTokens: 9, CompletionTokens: 2, TotalTokens: 11}, usage)
+
+	usage, estimated = EstimateUsage(req, "Hi there", openai.Usage{PromptTokens: 20, CompletionTokens: 5})
+	assert.False(t, estimated, "Expect the usage of the provider to be kept")
+	assert.Equal(t, 25, usage.TotalTokens)
+}
+
+func TestOpenaiStreamUsage(t *testing.T) {
+	includeUsage := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := map[string]any{}
+		json.NewDecoder(r.Body).Decode(&request)
+		assert.Equal(t, "model", request["model"], "Expect the request to be kept")
+		assert.Equal(t, map[string]any{"include_usage": true}, request["stream_options"])
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hel\"}}],\"usage\":null}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"lo\"},\"finish_reason\":\"stop\"}],\"usage\":null}\n\n")
+		if includeUsage {
+			fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":2,\"total_tokens\":14}}\n\n")
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	provider := newLLMProvider(&models.Provider{BaseUrl: server.URL}, "key")
+	request := openai.ChatCompletionRequest{Model: "model", Stream: true, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	readStream := func() (string, openai.Usage) {
+		stream, err := provider.createChatCompletionStream(context.Background(), request)
+		assert.Nil(t, err)
+		defer stream.Close()
+		content := ""
+		for {
+			chunk, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			assert.Nil(t, err)
+			if len(chunk.Choices) > 0 {
+				content += chunk.Choices[0].Delta.Content
+			}
+		}
+		return content, StreamUsage(stream)
+	}
+
+	content, usage := readStream()
+	assert.Equal(t, "Hello", content)
+	assert.Equal(t, openai.Usage{PromptTokens: 12, CompletionTokens: 2, TotalTokens: 14}, usage)
+
+	// Providers that ignore stream_options fall back to the estimate
+	includeUsage = false
+	content, usage = readStream()
+	assert.Equal(t, openai.Usage{}, usage)
+	usage, estimated := EstimateUsage(request, content, usage)
+	assert.True(t, estimated)
+	assert.Equal(t, 1, usage.CompletionTokens)
+}
+
+func TestStreamChunks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[],\"prompt_filter_results\":[{\"prompt_index\":0}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hello\"}}],\"usage\":null}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":2,\"total_tokens\":14}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	provider := newLLMProvider(&models.Provider{BaseUrl: server.URL}, "key")
+	request := openai.ChatCompletionRequest{Model: "model", Stream: true, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	// forward returns the chunks that the proxy sends to the client
+	forward := func(stream ChatCompletionStream, includeUsage bool) []string {
+		defer stream.Close()
+		chunks := NewStreamChunks(stream, includeUsage)
+		sent := []string{}
+		for {
+			resp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			assert.Nil(t, err)
+			chunk, err := chunks.Chunk(resp)
+			assert.Nil(t, err)
+			if chunk != nil {
+				sent = append(sent, string(chunk))
+			}
+		}
+		chunk, err := chunks.Usage(StreamUsage(stream))
+		assert.Nil(t, err)
+		if chunk != nil {
+			sent = append(sent, string(chunk))
+		}
+		return sent
+	}
+
+	stream, err := provider.createChatCompletionStream(context.Background(), request)
+	assert.Nil(t, err)
+	sent := forward(stream, true)
+	assert.Equal(t, 3, len(sent), "Expect the chunks to be forwarded as they were sent")
+	assert.Contains(t, sent[0], "prompt_filter_results")
+	assert.Contains(t, sent[2], `"prompt_tokens":12`)
+
+	// The usage that only the proxy asked for is dropped
+	stream, err = provider.createChatCompletionStream(context.Background(), request)
+	assert.Nil(t, err)
+	sent = forward(stream, false)
+	assert.Equal(t, 2, len(sent))
+	assert.Contains(t, sent[0], "prompt_filter_results")
+
+	// Streams that don't send the usage end with it when the client asked for it
+	cached := &models.Message{Role: "assistant", Content: "Reply", Metadata: &models.MessageMetadata{InputTokenCount: 5, OutputTokenCount: 1}}
+	sent = forward(newCachedStream(cached), true)
+	assert.Equal(t, 2, len(sent))
+	assert.Contains(t, sent[1], `"prompt_tokens":5`)
+	assert.Equal(t, 1, len(forward(newCachedStream(cached), false)))
+}
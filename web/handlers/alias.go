@@ -0,0 +1,51 @@
+package web
+
+import (
+	"github.com/go-fuego/fuego"
+	"github.com/y2a-labs/evaluate/models"
+	service "github.com/y2a-labs/evaluate/services"
+)
+
+func (rs Resources) RegisterAliasRoutes(s *fuego.Server) {
+	AliasGroup := fuego.Group(s, "/aliases")
+
+	fuego.Post(AliasGroup, "", rs.setAlias)
+	fuego.Delete(AliasGroup, "/{id}", rs.deleteAlias)
+}
+
+type AliasInput struct {
+	Name        string
+	Provider    string
+	Model       string
+	Temperature string `form:"temperature"`
+	TopP        string `form:"topP"`
+	MaxTokens   string `form:"maxTokens"`
+	Seed        string `form:"seed"`
+	Stop        string `form:"stop"`
+}
+
+func (rs Resources) setAlias(c *fuego.ContextWithBody[AliasInput]) (fuego.HTML, error) {
+	body, err := c.Body()
+	if err != nil {
+		return "", err
+	}
+	params, err := service.ParseGenerationParams(body.Temperature, body.TopP, body.MaxTokens, body.Seed, body.Stop)
+	if err != nil {
+		return c.Render("partials/error.partials.html", err.Error())
+	}
+	_, err = rs.Service.SetAlias(models.AliasCreate{Name: body.Name, ProviderID: body.Provider, Model: body.Model, Params: params})
+	if err != nil {
+		return c.Render("partials/error.partials.html", err.Error())
+	}
+	// Reload the page since the alias may already be in the list
+	c.Res.Header().Set("HX-Redirect", "/providers")
+	return "", nil
+}
+
+func (rs Resources) deleteAlias(c fuego.ContextNoBody) (fuego.HTML, error) {
+	err := rs.Service.DeleteAlias(c.PathParam("id"))
+	if err != nil {
+		return "", err
+	}
+	return "", nil
+}
@@ -0,0 +1,45 @@
+package web
+
+import (
+	"github.com/go-fuego/fuego"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func (rs Resources) RegisterBudgetRoutes(s *fuego.Server) {
+	BudgetGroup := fuego.Group(s, "/budgets")
+
+	fuego.Get(BudgetGroup, "/warnings", rs.getBudgetWarnings)
+	fuego.Post(BudgetGroup, "", rs.setClientBudget)
+	fuego.Delete(BudgetGroup, "/{id}", rs.deleteClientBudget)
+}
+
+// getBudgetWarnings renders the budgets that reached their warning percent, it is loaded by every page.
+func (rs Resources) getBudgetWarnings(c fuego.ContextNoBody) (fuego.HTML, error) {
+	warnings, err := rs.Service.GetBudgetWarnings()
+	if err != nil {
+		return "", err
+	}
+	return c.Render("partials/budget-warnings.partials.html", warnings)
+}
+
+func (rs Resources) setClientBudget(c *fuego.ContextWithBody[models.ClientBudgetCreate]) (fuego.HTML, error) {
+	body, err := c.Body()
+	if err != nil {
+		return "", err
+	}
+	_, err = rs.Service.SetClientBudget(body)
+	if err != nil {
+		return c.Render("partials/error.partials.html", err.Error())
+	}
+	// Reload the page since the budget of the app may already be in the list
+	c.Res.Header().Set("HX-Redirect", "/providers")
+	return "", nil
+}
+
+func (rs Resources) deleteClientBudget(c fuego.ContextNoBody) (fuego.HTML, error) {
+	err := rs.Service.DeleteClientBudget(c.PathParam("id"))
+	if err != nil {
+		return "", err
+	}
+	return "", nil
+}
@@ -0,0 +1,35 @@
+package web
+
+import (
+	"github.com/go-fuego/fuego"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func (rs Resources) RegisterResponseCacheRoutes(s *fuego.Server) {
+	ResponseCacheGroup := fuego.Group(s, "/caches")
+
+	fuego.Post(ResponseCacheGroup, "", rs.setResponseCache)
+	fuego.Delete(ResponseCacheGroup, "/{id}", rs.deleteResponseCache)
+}
+
+func (rs Resources) setResponseCache(c *fuego.ContextWithBody[models.ResponseCacheCreate]) (fuego.HTML, error) {
+	body, err := c.Body()
+	if err != nil {
+		return "", err
+	}
+	_, err = rs.Service.SetResponseCache(body)
+	if err != nil {
+		return c.Render("partials/error.partials.html", err.Error())
+	}
+	// Reload the page since the cache of the model may already be in the list
+	c.Res.Header().Set("HX-Redirect", "/providers")
+	return "", nil
+}
+
+func (rs Resources) deleteResponseCache(c fuego.ContextNoBody) (fuego.HTML, error) {
+	err := rs.Service.DeleteResponseCache(c.PathParam("id"))
+	if err != nil {
+		return "", err
+	}
+	return "", nil
+}
@@ -3,6 +3,7 @@ package web
 
 import (
 	"github.com/y2a-labs/evaluate/models"
+	service "github.com/y2a-labs/evaluate/services"
 
 	"github.com/go-fuego/fuego"
 )
@@ -23,19 +24,36 @@ func (rs Resources) getConversation(c fuego.ContextNoBody) (fuego.HTML, error) {
 	if err != nil {
 		return "", err
 	}
+	if err := rs.Service.SetConversationCosts(conversation); err != nil {
+		return "", err
+	}
+	if err := rs.Service.SetMessagesMetadata(conversation.Messages); err != nil {
+		return "", err
+	}
 
 	return c.Render("pages/conversation.page.html", conversation)
 }
 
 func (rs Resources) getConversationList(c fuego.ContextNoBody) (fuego.HTML, error) {
-	conversations, err := rs.Service.GetAllConversations()
+	tags := c.QueryParam("tags")
+	user := c.QueryParam("user")
+	app := c.QueryParam("app")
+	metadata := c.QueryParam("metadata")
+	conversations, err := rs.Service.GetAllConversations(service.ParseConversationFilter(tags, user, app, metadata))
 	if err != nil {
 		return "", err
 	}
 	for i := range conversations {
 		conversations[i].CreatedAtString = conversations[i].CreatedAt.Format("January 2 03:04 PM")
 	}
-	return c.Render("pages/conversations.page.html", conversations)
+	return c.Render("pages/conversations.page.html", map[string]any{
+		"conversations": conversations,
+		"filtered":      tags != "" || user != "" || app != "" || metadata != "",
+		"tags":          tags,
+		"user":          user,
+		"app":           app,
+		"metadata":      metadata,
+	})
 }
 
 func (rs Resources) updateConversation(c *fuego.ContextWithBody[models.ConversationUpdate]) (any, error) {
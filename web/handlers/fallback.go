@@ -0,0 +1,35 @@
+package web
+
+import (
+	"github.com/go-fuego/fuego"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func (rs Resources) RegisterFallbackRoutes(s *fuego.Server) {
+	FallbackGroup := fuego.Group(s, "/fallbacks")
+
+	fuego.Post(FallbackGroup, "", rs.setFallbackChain)
+	fuego.Delete(FallbackGroup, "/{id}", rs.deleteFallbackChain)
+}
+
+func (rs Resources) setFallbackChain(c *fuego.ContextWithBody[models.FallbackChainCreate]) (fuego.HTML, error) {
+	body, err := c.Body()
+	if err != nil {
+		return "", err
+	}
+	_, err = rs.Service.SetFallbackChain(body)
+	if err != nil {
+		return c.Render("partials/error.partials.html", err.Error())
+	}
+	// Reload the page since the chain of the model may already be in the list
+	c.Res.Header().Set("HX-Redirect", "/providers")
+	return "", nil
+}
+
+func (rs Resources) deleteFallbackChain(c fuego.ContextNoBody) (fuego.HTML, error) {
+	err := rs.Service.DeleteFallbackChain(c.PathParam("id"))
+	if err != nil {
+		return "", err
+	}
+	return "", nil
+}
@@ -2,6 +2,8 @@
 package web
 
 import (
+	"fmt"
+
 	"github.com/y2a-labs/evaluate/models"
 
 	"github.com/go-fuego/fuego"
@@ -13,6 +15,8 @@ func (rs Resources) RegisterLLMRoutes(s *fuego.Server) {
 	fuego.Get(LLMGroup, "", rs.getLLMs)
 	fuego.Post(LLMGroup, "", rs.createLLM)
 	fuego.Delete(LLMGroup, "", rs.deleteLLM2)
+	fuego.Put(LLMGroup, "", rs.updateLLMPrice)
+	fuego.Post(LLMGroup, "/prices", rs.importPrices)
 
 	fuego.Get(LLMGroup, "/{id}", rs.getLLM)
 	fuego.Put(LLMGroup, "/{id}", rs.updateLLM)
@@ -37,7 +41,34 @@ func (rs Resources) createLLM(c *fuego.ContextWithBody[models.LLMCreate]) (fuego
 	if err != nil {
 		return "", err
 	}
-	return fuego.HTML("<option value='" + llm.ID + "'>" + llm.ID + "</option>"), nil
+	return c.Render("partials/model-price.partials.html", llm)
+}
+
+// updateLLMPrice sets the prices of a model, the id is sent with the form since model names can have slashes.
+func (rs Resources) updateLLMPrice(c *fuego.ContextWithBody[models.LLMUpdate]) (fuego.HTML, error) {
+	body, err := c.Body()
+	if err != nil {
+		return "", err
+	}
+	llm, err := rs.Service.UpdateLLM(body.ID, body)
+	if err != nil {
+		return c.Render("partials/error.partials.html", err.Error())
+	}
+	return c.Render("partials/model-price.partials.html", llm)
+}
+
+// importPrices sets the prices of the saved models from an uploaded price sheet.
+func (rs Resources) importPrices(c fuego.ContextNoBody) (fuego.HTML, error) {
+	file, _, err := c.Req.FormFile("file")
+	if err != nil {
+		return c.Render("partials/error.partials.html", err.Error())
+	}
+	defer file.Close()
+	result, err := rs.Service.ImportPrices(file)
+	if err != nil {
+		return c.Render("partials/error.partials.html", err.Error())
+	}
+	return fuego.HTML(fmt.Sprintf("Updated the prices of %d models, %d models of the sheet aren't saved.", result.Updated, len(result.Skipped))), nil
 }
 
 func (rs Resources) getLLM(c fuego.ContextNoBody) (*models.LLM, error) {
@@ -31,8 +31,43 @@ func (rs Resources) getAllProviders(c fuego.ContextNoBody) (fuego.HTML, error) {
 		}
 		providers[i].Models = llms
 	}
+	if err := rs.Service.SetProviderBudgets(providers); err != nil {
+		return "", err
+	}
+	clientBudgets, err := rs.Service.GetClientBudgets()
+	if err != nil {
+		return "", err
+	}
+	fallbackChains, err := rs.Service.GetFallbackChains()
+	if err != nil {
+		return "", err
+	}
+	aliases, err := rs.Service.GetAliases()
+	if err != nil {
+		return "", err
+	}
+	splits, err := rs.Service.GetTrafficSplits()
+	if err != nil {
+		return "", err
+	}
+	shadows, err := rs.Service.GetShadows()
+	if err != nil {
+		return "", err
+	}
+	responseCaches, err := rs.Service.GetResponseCaches()
+	if err != nil {
+		return "", err
+	}
 
-	return c.Render("pages/providers.page.html", providers)
+	return c.Render("pages/providers.page.html", map[string]any{
+		"providers":      providers,
+		"clientBudgets":  clientBudgets,
+		"fallbackChains": fallbackChains,
+		"aliases":        aliases,
+		"splits":         splits,
+		"shadows":        shadows,
+		"responseCaches": responseCaches,
+	})
 }
 
 func (rs Resources) pullLLMsFromProvider(c fuego.ContextNoBody) (fuego.HTML, error) {
@@ -76,6 +111,9 @@ func (rs Resources) updateProvider(c *fuego.ContextWithBody[models.ProviderUpdat
 	if err != nil {
 		return c.Render("partials/error.partials.html", err.Error())
 	}
+	if err := rs.Service.SetProviderBudgets([]*models.Provider{new}); err != nil {
+		return "", err
+	}
 
 	return c.Render("partials/provider.partials.html", new)
 }
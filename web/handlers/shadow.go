@@ -0,0 +1,35 @@
+package web
+
+import (
+	"github.com/go-fuego/fuego"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func (rs Resources) RegisterShadowRoutes(s *fuego.Server) {
+	ShadowGroup := fuego.Group(s, "/shadows")
+
+	fuego.Post(ShadowGroup, "", rs.setShadow)
+	fuego.Delete(ShadowGroup, "/{id}", rs.deleteShadow)
+}
+
+func (rs Resources) setShadow(c *fuego.ContextWithBody[models.ShadowCreate]) (fuego.HTML, error) {
+	body, err := c.Body()
+	if err != nil {
+		return "", err
+	}
+	_, err = rs.Service.SetShadow(body)
+	if err != nil {
+		return c.Render("partials/error.partials.html", err.Error())
+	}
+	// Reload the page since the shadow of the model may already be in the list
+	c.Res.Header().Set("HX-Redirect", "/providers")
+	return "", nil
+}
+
+func (rs Resources) deleteShadow(c fuego.ContextNoBody) (fuego.HTML, error) {
+	err := rs.Service.DeleteShadow(c.PathParam("id"))
+	if err != nil {
+		return "", err
+	}
+	return "", nil
+}
@@ -0,0 +1,35 @@
+package web
+
+import (
+	"github.com/go-fuego/fuego"
+	"github.com/y2a-labs/evaluate/models"
+)
+
+func (rs Resources) RegisterSplitRoutes(s *fuego.Server) {
+	SplitGroup := fuego.Group(s, "/splits")
+
+	fuego.Post(SplitGroup, "", rs.setTrafficSplit)
+	fuego.Delete(SplitGroup, "/{id}", rs.deleteTrafficSplit)
+}
+
+func (rs Resources) setTrafficSplit(c *fuego.ContextWithBody[models.TrafficSplitCreate]) (fuego.HTML, error) {
+	body, err := c.Body()
+	if err != nil {
+		return "", err
+	}
+	_, err = rs.Service.SetTrafficSplit(body)
+	if err != nil {
+		return c.Render("partials/error.partials.html", err.Error())
+	}
+	// Reload the page since the split of the model may already be in the list
+	c.Res.Header().Set("HX-Redirect", "/providers")
+	return "", nil
+}
+
+func (rs Resources) deleteTrafficSplit(c fuego.ContextNoBody) (fuego.HTML, error) {
+	err := rs.Service.DeleteTrafficSplit(c.PathParam("id"))
+	if err != nil {
+		return "", err
+	}
+	return "", nil
+}
@@ -2,13 +2,17 @@
 package web
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math"
+	"net/http"
 	"github.com/y2a-labs/evaluate/models"
 	service "github.com/y2a-labs/evaluate/services"
 	"sort"
 
 	"github.com/go-fuego/fuego"
+	"github.com/google/uuid"
 )
 
 func (rs Resources) RegisterTestRoutes(s *fuego.Server) {
@@ -20,9 +24,17 @@ func (rs Resources) RegisterTestRoutes(s *fuego.Server) {
 
 	fuego.Put(TestGroup, "/{id}/appendmodel", rs.appendTestModel)
 	fuego.Put(TestGroup, "/{id}/removemodel", rs.deleteTestModel)
+	fuego.Put(TestGroup, "/{id}/scorer", rs.updateTestScorer)
+	fuego.Put(TestGroup, "/{id}/tags", rs.updateTestTags)
+	fuego.Put(TestGroup, "/{id}/prompts", rs.updateTestPrompts)
+	fuego.Put(TestGroup, "/{id}/simulator", rs.updateTestSimulator)
 	fuego.Post(TestGroup, "/{id}/messages", rs.addMessagesToTest)
 	fuego.Get(TestGroup, "/{id}", rs.getTest)
+	fuego.Get(TestGroup, "/{id}/runs", rs.getTestRuns)
+	fuego.Get(TestGroup, "/{id}/runs/{runID}", rs.getTestRun)
 	fuego.Post(TestGroup, "/{id}", rs.runTest)
+	fuego.GetStd(TestGroup, "/{id}/jobs/{jobID}/events", rs.streamTestJob)
+	fuego.Post(TestGroup, "/{id}/jobs/{jobID}/cancel", rs.cancelTestJob)
 }
 
 func (rs Resources) getTestList(c fuego.ContextNoBody) (fuego.HTML, error) {
@@ -38,24 +50,158 @@ type RunTestInput struct {
 	TestMessageID string `form:"testMessageID"`
 }
 
-func (rs Resources) runTest(c *fuego.ContextWithBody[RunTestInput]) (any, error) {
+// runTest starts the test in the background and renders its progress.
+func (rs Resources) runTest(c *fuego.ContextWithBody[RunTestInput]) (fuego.HTML, error) {
 	conversationID := c.PathParam("id")
 	body, err := c.Body()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	_, err = rs.Service.ExecuteTestWorkflow(service.ExecuteTestInput{
-		Context:        c.Context(),
+	job, err := rs.Service.StartTestJob(service.ExecuteTestInput{
 		RunCount:       body.RunCount,
 		ConversationID: conversationID,
 		TestMessageID:  body.TestMessageID,
 	})
 	if err != nil {
-		return nil, err
+		return c.Render("partials/error.partials.html", err.Error())
+	}
+	return c.Render("partials/test-job.partials.html", map[string]any{
+		"testID": conversationID,
+		"job":    job.Progress(),
+	})
+}
+
+// streamTestJob sends the progress of a job as server sent events until the job is done.
+func (rs Resources) streamTestJob(w http.ResponseWriter, r *http.Request) {
+	job, err := rs.Service.GetTestJob(r.PathValue("jobID"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	controller := http.NewResponseController(w)
+
+	updates, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+	for {
+		progress := job.Progress()
+		data, err := json.Marshal(progress)
+		if err != nil {
+			return
+		}
+		_, err = fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+		if err != nil {
+			return
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+		if progress.Done() {
+			return
+		}
+
+		select {
+		case <-updates:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (rs Resources) cancelTestJob(c fuego.ContextNoBody) (fuego.HTML, error) {
+	err := rs.Service.CancelTestJob(c.PathParam("jobID"))
+	if err != nil {
+		return c.Render("partials/error.partials.html", err.Error())
+	}
+	return "", nil
+}
+
+type ScorerInput struct {
+	Scorer        string `form:"scorer"`
+	ScorerConfig  string `form:"scorerConfig"`
+	JudgeModel    string `form:"judgeModel"`
+	JudgePromptID string `form:"judgePromptID"`
+}
+
+func (rs Resources) updateTestScorer(c *fuego.ContextWithBody[ScorerInput]) (fuego.HTML, error) {
+	id := c.PathParam("id")
+	body, err := c.Body()
+	if err != nil {
+		return "", err
+	}
+	_, err = rs.Service.SetTestScorer(id, models.ScorerUpdate{
+		Scorer:        body.Scorer,
+		ScorerConfig:  body.ScorerConfig,
+		JudgeModel:    body.JudgeModel,
+		JudgePromptID: body.JudgePromptID,
+	})
+	if err != nil {
+		return c.Render("partials/error.partials.html", err.Error())
+	}
+	// Reload the page so the scores are recomputed
+	c.Res.Header().Set("HX-Redirect", "/tests/"+id)
+	return "", nil
+}
+
+type TagsInput struct {
+	Tags string `form:"tags"`
+}
+
+func (rs Resources) updateTestTags(c *fuego.ContextWithBody[TagsInput]) (fuego.HTML, error) {
+	id := c.PathParam("id")
+	body, err := c.Body()
+	if err != nil {
+		return "", err
+	}
+	_, err = rs.Service.SetTestTags(id, service.ParseTags(body.Tags))
+	if err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// updateTestPrompts sets the prompt versions of the test from the checked promptIDs.
+func (rs Resources) updateTestPrompts(c fuego.ContextNoBody) (fuego.HTML, error) {
+	id := c.PathParam("id")
+	if err := c.Req.ParseForm(); err != nil {
+		return "", err
+	}
+	_, err := rs.Service.SetTestPrompts(id, c.Req.Form["promptIDs"])
+	if err != nil {
+		return c.Render("partials/error.partials.html", err.Error())
+	}
+	// Reload the page so the scores are grouped by the new prompt versions
+	c.Res.Header().Set("HX-Redirect", "/tests/"+id)
+	return "", nil
+}
+
+type SimulatorInput struct {
+	TestMode          string `form:"testMode"`
+	SimulatorModel    string `form:"simulatorModel"`
+	SimulatorPromptID string `form:"simulatorPromptID"`
+	SimulatorTurns    int    `form:"simulatorTurns"`
+}
+
+func (rs Resources) updateTestSimulator(c *fuego.ContextWithBody[SimulatorInput]) (fuego.HTML, error) {
+	id := c.PathParam("id")
+	body, err := c.Body()
+	if err != nil {
+		return "", err
+	}
+	_, err = rs.Service.SetTestSimulator(id, models.SimulatorUpdate{
+		TestMode:          body.TestMode,
+		SimulatorModel:    body.SimulatorModel,
+		SimulatorPromptID: body.SimulatorPromptID,
+		SimulatorTurns:    body.SimulatorTurns,
+	})
+	if err != nil {
+		return c.Render("partials/error.partials.html", err.Error())
 	}
-	url := c.Req.URL
-	c.Res.Header().Set("HX-Redirect", url.String())
-	return nil, err
+	c.Res.Header().Set("HX-Redirect", "/tests/"+id)
+	return "", nil
 }
 
 type AddMessages struct {
@@ -103,8 +249,14 @@ func (rs Resources) createTest(c *fuego.ContextWithBody[models.ConversationCreat
 }
 
 type ModelInput struct {
-	Provider string
-	Model    string
+	ID          string `form:"id"`
+	Provider    string
+	Model       string
+	Temperature string `form:"temperature"`
+	TopP        string `form:"topP"`
+	MaxTokens   string `form:"maxTokens"`
+	Seed        string `form:"seed"`
+	Stop        string `form:"stop"`
 }
 
 func (rs Resources) appendTestModel(c *fuego.ContextWithBody[ModelInput]) (fuego.HTML, error) {
@@ -120,12 +272,16 @@ func (rs Resources) appendTestModel(c *fuego.ContextWithBody[ModelInput]) (fuego
 		return "", tx.Error
 	}
 
-	model := models.TestModels{Provider: body.Provider, Model: body.Model}
+	params, err := service.ParseGenerationParams(body.Temperature, body.TopP, body.MaxTokens, body.Seed, body.Stop)
+	if err != nil {
+		return "", err
+	}
+	model := models.TestModels{ID: uuid.NewString(), Provider: body.Provider, Model: body.Model, GenerationParams: params}
 
-	// Check if there's any item with the same Provider and Model
+	// The same model can be tested more than once, as long as the parameters are different
 	for _, testModel := range conversation.TestModels {
-		if testModel.Provider == model.Provider && testModel.Model == model.Model {
-			return "", errors.New("a model with the same provider and model name already exists")
+		if testModel.Provider == model.Provider && testModel.Model == model.Model && testModel.GenerationParams.String() == params.String() {
+			return "", errors.New("a model with the same provider, model name and parameters already exists")
 		}
 	}
 
@@ -159,7 +315,7 @@ func (rs Resources) deleteTestModel(c *fuego.ContextWithBody[ModelInput]) (fuego
 	// Create a new slice excluding the matching item
 	newTestModels := []models.TestModels{}
 	for _, testModel := range conversation.TestModels {
-		if testModel.Provider != body.Provider || testModel.Model != body.Model {
+		if testModel.ID != body.ID || testModel.Provider != body.Provider || testModel.Model != body.Model {
 			newTestModels = append(newTestModels, testModel)
 		}
 	}
@@ -195,14 +351,30 @@ func (rs Resources) getTest(c fuego.ContextNoBody) (fuego.HTML, error) {
 		return "", err
 	}
 
+	prompts, err := rs.Service.GetAllPrompts()
+	if err != nil {
+		return "", err
+	}
+
+	lastRun, err := rs.Service.GetLastTestRun(id)
+	if err != nil {
+		return "", err
+	}
+
+	selectedPrompts := map[string]bool{}
+	for _, promptID := range conversation.TestPromptIDs {
+		selectedPrompts[promptID] = true
+	}
+
 	versions := make([]int, conversation.Version+1)
 	for i := range versions {
 		versions[i] = i
 	}
 
-	// Get the score for each llm
+	// Get the score and the cost for each llm
 	scoreSum := map[string]float64{}
 	scoreCount := map[string]int{}
+	costSum := map[string]float64{}
 
 	// Gets the sum of the scores
 	for _, msg := range conversation.Messages {
@@ -210,15 +382,19 @@ func (rs Resources) getTest(c fuego.ContextNoBody) (fuego.HTML, error) {
 			continue
 		}
 		for _, testMsg := range msg.TestMessages {
-			scoreSum[testMsg.LLMID] += testMsg.Score
-			scoreCount[testMsg.LLMID]++
+			scoreSum[testMsg.TestModelKey()] += testMsg.Score
+			scoreCount[testMsg.TestModelKey()]++
+			if testMsg.Metadata != nil {
+				costSum[testMsg.TestModelKey()] += testMsg.Metadata.Cost
+			}
 		}
 	}
 
 	// Puts the new scores into the models slice
 	for i, llm := range conversation.TestModels {
-		if scoreCount[llm.Model] > 0 {
-			averageScore := scoreSum[llm.Model] / float64(scoreCount[llm.Model])
+		conversation.TestModels[i].Cost = costSum[llm.Key()]
+		if scoreCount[llm.Key()] > 0 {
+			averageScore := scoreSum[llm.Key()] / float64(scoreCount[llm.Key()])
 			roundedScore := math.Round(averageScore*100) / 100
 			conversation.TestModels[i].Score = roundedScore
 		}
@@ -231,10 +407,42 @@ func (rs Resources) getTest(c fuego.ContextNoBody) (fuego.HTML, error) {
 	})
 
 	return c.Render("pages/test.page.html", map[string]any{
-		"test":         conversation,
-		"llmProviders": providers,
-		"versions":     versions,
-		"llms":         llms,
+		"test":            conversation,
+		"llmProviders":    providers,
+		"versions":        versions,
+		"llms":            llms,
+		"scorers":         service.ScorerNames(),
+		"prompts":         prompts,
+		"selectedPrompts": selectedPrompts,
+		"lastRun":         lastRun,
+		"exportFormats":   service.ExportFormats(),
+	})
+}
+
+func (rs Resources) getTestRuns(c fuego.ContextNoBody) (fuego.HTML, error) {
+	id := c.PathParam("id")
+	conversation, err := rs.Service.GetConversation(id)
+	if err != nil {
+		return "", err
+	}
+	testRuns, err := rs.Service.GetTestRuns(id)
+	if err != nil {
+		return "", err
+	}
+	return c.Render("pages/test-runs.page.html", map[string]any{
+		"test": conversation,
+		"runs": testRuns,
+	})
+}
+
+func (rs Resources) getTestRun(c fuego.ContextNoBody) (fuego.HTML, error) {
+	testRun, conversation, err := rs.Service.GetTestRun(c.PathParam("runID"))
+	if err != nil {
+		return "", err
+	}
+	return c.Render("pages/test-run.page.html", map[string]any{
+		"test": conversation,
+		"run":  testRun,
 	})
 }
 